@@ -11,8 +11,30 @@
       event.preventDefault();
       createUser();
     });
+    id('UserName').addEventListener('blur', checkUserNameAvailability);
   });
 
+  /**
+   * checkUserNameAvailability asks the server whether the entered username can
+   * be used, and displays an error before the form is submitted if it cannot
+   */
+  const checkUserNameAvailability = () => {
+    const userName = id('UserName').value;
+    if (userName.length === 0) {
+      return;
+    }
+
+    fetch(BASE_URL + "/availability?userName=" + encodeURIComponent(userName))
+      .then(checkStatus)
+      .then(res => res.json())
+      .then(availability => {
+        if (!availability.userName.available) {
+          displayError("Username " + userName + " is " + availability.userName.reason);
+        }
+      })
+      .catch(displayError)
+  }
+
   /**
    * createUser makes a request to create a new user
    */
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"serverside-final-project/servers/gateway/config"
+	"serverside-final-project/servers/gateway/handlers"
+	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/sessions"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// sessionKeyLength is the number of random bytes in a generated session key
+const sessionKeyLength = 32
+
+// shortIDLength is how much of a session ID is printed by list-sessions.
+// Full session IDs are bearer tokens, so they are never printed.
+const shortIDLength = 12
+
+// sessionEntry is a session ID along with the state saved for it
+type sessionEntry struct {
+	ID    sessions.SessionID
+	State *handlers.SessionState
+}
+
+// runCreateUser creates a new user account. The password is read from
+// standard input so that it doesn't end up in the shell history.
+func runCreateUser(args []string) int {
+	flags := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	email := flags.String("email", "", "email address of the new user")
+	userName := flags.String("username", "", "user name of the new user")
+	firstName := flags.String("first", "", "first name of the new user")
+	lastName := flags.String("last", "", "last name of the new user")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	userStore, db, err := openUserStore()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer db.Close()
+
+	password, err := readPassword("Password for " + *email)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	newUser := &users.NewUser{
+		Email:        *email,
+		Password:     password,
+		PasswordConf: password,
+		UserName:     *userName,
+		FirstName:    *firstName,
+		LastName:     *lastName,
+	}
+	user, err := newUser.ToUser()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+
+	user, err = userStore.Insert(context.Background(), user)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	fmt.Printf("Created user %d (%s)\n", user.ID, user.UserName)
+	return 0
+}
+
+// runResetPassword sets a new password for a user, read from standard input,
+// and signs the user out everywhere
+func runResetPassword(args []string) int {
+	flags := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	id := flags.Int64("id", 0, "ID of the user")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	userStore, db, err := openUserStore()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer db.Close()
+
+	user, err := userStore.GetByID(ctx, *id)
+	if err != nil {
+		fmt.Printf("Error finding user %d: %v\n", *id, err)
+		return 1
+	}
+	password, err := readPassword("New password for " + user.UserName)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if len(password) < 6 {
+		fmt.Println("Password has fewer than 6 characters")
+		return 1
+	}
+	if err := user.SetPassword(password); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if err := userStore.SetPassHash(ctx, user.ID, user.PassHash); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	fmt.Printf("Reset password for user %d (%s)\n", user.ID, user.UserName)
+
+	return revokeUserSessions(ctx, user.ID)
+}
+
+// runSuspendUser suspends a user and signs them out everywhere, or lifts
+// their suspension with -lift
+func runSuspendUser(args []string) int {
+	flags := flag.NewFlagSet("suspend-user", flag.ContinueOnError)
+	id := flags.Int64("id", 0, "ID of the user")
+	lift := flags.Bool("lift", false, "lift the suspension instead")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	userStore, db, err := openUserStore()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer db.Close()
+
+	user, err := userStore.GetByID(ctx, *id)
+	if err != nil {
+		fmt.Printf("Error finding user %d: %v\n", *id, err)
+		return 1
+	}
+	if err := userStore.SetSuspended(ctx, user.ID, !*lift); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if *lift {
+		fmt.Printf("Lifted suspension of user %d (%s)\n", user.ID, user.UserName)
+		return 0
+	}
+	fmt.Printf("Suspended user %d (%s)\n", user.ID, user.UserName)
+
+	return revokeUserSessions(ctx, user.ID)
+}
+
+// runListSessions prints the sessions in redis, optionally only those of one user
+func runListSessions(args []string) int {
+	flags := flag.NewFlagSet("list-sessions", flag.ContinueOnError)
+	userID := flags.Int64("user", 0, "only list sessions of the user with this ID")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	_, entries, err := listSessions(context.Background(), *userID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	for _, entry := range entries {
+		userName := ""
+		var id int64
+		if entry.State.User != nil {
+			id, userName = entry.State.User.ID, entry.State.User.UserName
+		}
+		fmt.Printf("%s\tuser %d (%s)\tbegan %s\n", shortID(entry.ID), id, userName, entry.State.Time.Format(time.RFC3339))
+	}
+	fmt.Printf("%d session(s)\n", len(entries))
+	return 0
+}
+
+// runRevokeSessions deletes sessions from redis, signing their users out
+func runRevokeSessions(args []string) int {
+	flags := flag.NewFlagSet("revoke-sessions", flag.ContinueOnError)
+	userID := flags.Int64("user", 0, "revoke every session of the user with this ID")
+	sid := flags.String("sid", "", "revoke the session whose ID starts with this prefix, as printed by list-sessions")
+	all := flags.Bool("all", false, "revoke every session")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	switch {
+	case *userID > 0 && len(*sid) == 0 && !*all:
+		return revokeUserSessions(ctx, *userID)
+	case len(*sid) > 0 && *userID == 0 && !*all:
+		store, entries, err := listSessions(ctx, 0)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		matches := []sessionEntry{}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.ID.String(), *sid) {
+				matches = append(matches, entry)
+			}
+		}
+		if len(matches) != 1 {
+			fmt.Printf("Expected exactly one session to start with %q, but found %d\n", *sid, len(matches))
+			return 1
+		}
+		return revokeSessions(ctx, store, matches)
+	case *all && *userID == 0 && len(*sid) == 0:
+		store, entries, err := listSessions(ctx, 0)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		return revokeSessions(ctx, store, entries)
+	default:
+		fmt.Println("Exactly one of -user, -sid, or -all must be given")
+		return 2
+	}
+}
+
+// runRotateSessionKey generates a new session signing key. Sessions signed with
+// the old key stop validating once the gateway is restarted with the new key, so
+// they are revoked unless -keep-sessions is given.
+func runRotateSessionKey(args []string) int {
+	flags := flag.NewFlagSet("rotate-session-key", flag.ContinueOnError)
+	keepSessions := flags.Bool("keep-sessions", false, "don't revoke the sessions signed with the old key")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	key := make([]byte, sessionKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		fmt.Printf("Error generating session key: %v\n", err)
+		return 1
+	}
+
+	if !*keepSessions {
+		ctx := context.Background()
+		store, entries, err := listSessions(ctx, 0)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		if code := revokeSessions(ctx, store, entries); code != 0 {
+			return code
+		}
+	}
+
+	fmt.Println("Set SESSIONKEY to the new key below and restart every gateway replica:")
+	fmt.Println(base64.URLEncoding.EncodeToString(key))
+	return 0
+}
+
+// runPrintConfig prints the configuration the server would start with, with
+// secrets redacted, followed by any problems that would stop it starting
+func runPrintConfig(args []string) int {
+	flags := flag.NewFlagSet("print-config", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := readConfig()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	out, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		fmt.Printf("Error printing configuration: %v\n", err)
+		return 1
+	}
+	fmt.Print(string(out))
+
+	if err := validateConfig(cfg); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	return 0
+}
+
+// readConfig reads the configuration without validating it. The server
+// validates it with validateConfig, and admin commands skip that since they
+// don't need everything the server does, such as the TLS files.
+func readConfig() (*config.Config, error) {
+	return config.Read(os.Getenv(config.FileEnv), os.Getenv)
+}
+
+// openUserStore opens the MySQL user store in the configured DSN.
+// The caller must close the returned database.
+func openUserStore() (*users.MySQLStore, *sql.DB, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cfg.DSN) == 0 {
+		return nil, nil, fmt.Errorf("DSN should be set")
+	}
+	db, err := sql.Open("mysql", cfg.DSN)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userStore := users.NewMySQLStore(db)
+	userStore.OperationTimeout = cfg.MySQLTimeout
+	return userStore, db, nil
+}
+
+// openSessionStore returns the configured redis session store. Listing
+// sessions scans the whole keyspace, so the operation timeout is relaxed.
+func openSessionStore() (*sessions.RedisStore, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisAddr,
+	})
+	store := sessions.NewRedisStore(client, time.Hour*100000)
+	store.OperationTimeout = time.Minute
+	return store, nil
+}
+
+// listSessions opens the session store and returns it along with the sessions
+// in it, or only those belonging to `userID` if it isn't zero. Sessions that
+// expire while listing are skipped, and listing doesn't extend the others.
+func listSessions(ctx context.Context, userID int64) (*sessions.RedisStore, []sessionEntry, error) {
+	store, err := openSessionStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, err := store.IDs(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error listing sessions: %v", err)
+	}
+
+	entries := []sessionEntry{}
+	for _, id := range ids {
+		state := &handlers.SessionState{}
+		if err := store.Peek(ctx, id, state); err == sessions.ErrStateNotFound {
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Error reading session %s: %v", shortID(id), err)
+		}
+		if userID != 0 && (state.User == nil || state.User.ID != userID) {
+			continue
+		}
+		entries = append(entries, sessionEntry{id, state})
+	}
+	return store, entries, nil
+}
+
+// revokeUserSessions deletes every session belonging to `userID`
+func revokeUserSessions(ctx context.Context, userID int64) int {
+	store, entries, err := listSessions(ctx, userID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	return revokeSessions(ctx, store, entries)
+}
+
+// revokeSessions deletes each of the sessions in `entries`
+func revokeSessions(ctx context.Context, store *sessions.RedisStore, entries []sessionEntry) int {
+	for _, entry := range entries {
+		if err := store.Delete(ctx, entry.ID); err != nil {
+			fmt.Printf("Error revoking session %s: %v\n", shortID(entry.ID), err)
+			return 1
+		}
+	}
+	fmt.Printf("Revoked %d session(s)\n", len(entries))
+	return 0
+}
+
+// readPassword prompts for a password on standard error and reads it from
+// standard input, without echoing it if standard input is a terminal.
+// Passwords piped in are read as one line.
+func readPassword(prompt string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("Error reading password: %v", err)
+		}
+		return string(password), nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return "", fmt.Errorf("Error reading password: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// shortID returns the prefix of a session ID that identifies it in output
+func shortID(sid sessions.SessionID) string {
+	if len(sid) <= shortIDLength {
+		return sid.String()
+	}
+	return sid.String()[:shortIDLength]
+}
@@ -0,0 +1,65 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEOptions configures how certificates are obtained from an ACME CA
+type ACMEOptions struct {
+	// Domains are the only host names certificates are requested for
+	Domains []string
+	// Email is the contact address of the ACME account, which the CA uses
+	// to warn about certificates that are about to expire. It may be empty.
+	Email string
+	// CacheDir is the directory that the account key and certificates are
+	// kept in, so that they survive restarts and are shared by replicas
+	// that mount it
+	CacheDir string
+	// DirectoryURL is the directory of the ACME CA. Defaults to Let's
+	// Encrypt's production directory.
+	DirectoryURL string
+	// CACert is a PEM file of the certificate authorities trusted to serve
+	// DirectoryURL, for CAs such as Pebble whose own certificate isn't
+	// publicly trusted. Defaults to the system roots.
+	CACert string
+}
+
+// NewManager constructs an autocert.Manager that obtains certificates for
+// the domains in `opts` and renews them before they expire. The manager
+// answers tls-alpn-01 challenges during TLS handshakes, and http-01
+// challenges with the handler returned by its HTTPHandler method.
+func NewManager(opts ACMEOptions) (*autocert.Manager, error) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(opts.CacheDir),
+		HostPolicy: autocert.HostWhitelist(opts.Domains...),
+		Email:      opts.Email,
+	}
+	if len(opts.DirectoryURL) == 0 && len(opts.CACert) == 0 {
+		return manager, nil
+	}
+
+	client := &acme.Client{DirectoryURL: opts.DirectoryURL}
+	if len(opts.CACert) > 0 {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading ACME CA certificate: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ACME CA certificate file %s contains no PEM certificates", opts.CACert)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	manager.Client = client
+	return manager, nil
+}
@@ -0,0 +1,102 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestNewManager(t *testing.T) {
+	dir := t.TempDir()
+	manager, err := NewManager(ACMEOptions{Domains: []string{"api.example.com"}, Email: "ops@example.com", CacheDir: dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if manager.Client != nil {
+		t.Errorf("expected Let's Encrypt to be used by default, but got a client for %s", manager.Client.DirectoryURL)
+	}
+	if manager.Cache != autocert.DirCache(dir) || manager.Email != "ops@example.com" {
+		t.Errorf("expected the cache and email to be set, but got %v and %q", manager.Cache, manager.Email)
+	}
+	if err := manager.HostPolicy(context.Background(), "api.example.com"); err != nil {
+		t.Errorf("expected the domain to be allowed, but got %v", err)
+	}
+	if err := manager.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Errorf("expected other domains to be refused")
+	}
+
+	caCert := filepath.Join(dir, "ca.pem")
+	writeCertificate(t, caCert, filepath.Join(dir, "ca-key.pem"), "test CA")
+	manager, err = NewManager(ACMEOptions{CacheDir: dir, DirectoryURL: "https://localhost:14000/dir", CACert: caCert})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if manager.Client == nil || manager.Client.DirectoryURL != "https://localhost:14000/dir" || manager.Client.HTTPClient == nil {
+		t.Errorf("expected a client for the test CA, but got %+v", manager.Client)
+	}
+
+	if _, err := NewManager(ACMEOptions{CacheDir: dir, CACert: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Errorf("expected an error for a missing CA certificate")
+	}
+	if _, err := NewManager(ACMEOptions{CacheDir: dir, CACert: filepath.Join(dir, "ca-key.pem")}); err == nil {
+		t.Errorf("expected an error for a CA file without certificates")
+	}
+}
+
+// TestManagerPebble obtains a certificate from a Pebble test CA, such as one
+// started with
+//
+//	PEBBLE_VA_ALWAYS_VALID=1 pebble -config test/config/pebble-config.json
+//
+// and is skipped unless ACMETESTDIRECTORY is its directory URL and
+// ACMETESTCACERT is the certificate Pebble serves it with. Without
+// PEBBLE_VA_ALWAYS_VALID, ACMETESTDOMAIN must resolve to this host, where
+// challenges are answered on Pebble's default ports 5002 and 5001.
+func TestManagerPebble(t *testing.T) {
+	directory, caCert := os.Getenv("ACMETESTDIRECTORY"), os.Getenv("ACMETESTCACERT")
+	if len(directory) == 0 || len(caCert) == 0 {
+		t.Skip("ACMETESTDIRECTORY and ACMETESTCACERT are not set")
+	}
+	domain := os.Getenv("ACMETESTDOMAIN")
+	if len(domain) == 0 {
+		domain = "gateway.test"
+	}
+
+	cacheDir := t.TempDir()
+	manager, err := NewManager(ACMEOptions{Domains: []string{domain}, CacheDir: cacheDir, DirectoryURL: directory, CACert: caCert})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	httpListener, err := net.Listen("tcp", ":5002")
+	if err != nil {
+		t.Fatalf("error listening for http-01 challenges: %v", err)
+	}
+	httpServer := &http.Server{Handler: manager.HTTPHandler(Redirect(":443"))}
+	go httpServer.Serve(httpListener)
+	defer httpServer.Close()
+	tlsListener, err := tls.Listen("tcp", ":5001", TLSConfig(manager, tls.VersionTLS12, nil))
+	if err != nil {
+		t.Fatalf("error listening for tls-alpn-01 challenges: %v", err)
+	}
+	tlsServer := &http.Server{Handler: http.NotFoundHandler()}
+	go tlsServer.Serve(tlsListener)
+	defer tlsServer.Close()
+
+	cert, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+	if err != nil {
+		t.Fatalf("error obtaining a certificate from Pebble: %v", err)
+	}
+	if err := cert.Leaf.VerifyHostname(domain); err != nil {
+		t.Errorf("expected a certificate for %s: %v", domain, err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, domain)); err != nil {
+		t.Errorf("expected the certificate to be cached: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Package certs provides the certificates the gateway serves HTTPS with,
+// either loaded from files and reloaded when they change or obtained from
+// an ACME certificate authority such as Let's Encrypt, along with the TLS
+// versions and cipher suites they are served with.
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Source provides the certificate for each TLS handshake
+type Source interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// versions maps the names of the TLS versions that may be required to
+// their values. Versions before 1.2 are not offered at all.
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion returns the TLS version named `name`, such as "1.2"
+func ParseVersion(name string) (uint16, error) {
+	version, found := versions[name]
+	if !found {
+		return 0, fmt.Errorf("unsupported TLS version %q, must be 1.2 or 1.3", name)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites returns the cipher suites named `names`, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Suites with known security
+// problems are rejected.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	secure := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, found := secure[name]
+		if !found {
+			known := make([]string, 0, len(secure))
+			for name := range secure {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q, must be one of %s", name, strings.Join(known, ", "))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// TLSConfig returns the TLS configuration of a server whose certificates
+// come from `source`. Connections must use at least `minVersion`, and TLS
+// 1.2 connections one of `cipherSuites`, or else any suite Go considers
+// secure if it is empty. TLS 1.3 suites are not configurable.
+func TLSConfig(source Source, minVersion uint16, cipherSuites []uint16) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: source.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	// ACME CAs may validate domains with the tls-alpn-01 challenge
+	if _, ok := source.(*autocert.Manager); ok {
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+	}
+	return config
+}
+
+// Redirect returns a handler that redirects every request to the same host
+// and URI over HTTPS, on the port of `httpsAddr`
+func Redirect(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if len(port) > 0 && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		// 308 tells clients to repeat the request with the same method and body
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,86 @@
+package certs
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+	for name, expected := range cases {
+		if version, err := ParseVersion(name); err != nil || version != expected {
+			t.Errorf("expected %q to be version %x, but got %x (%v)", name, expected, version, err)
+		}
+	}
+	for _, name := range []string{"", "1.0", "1.1", "TLS1.2"} {
+		if _, err := ParseVersion(name); err == nil {
+			t.Errorf("expected an error for TLS version %q", name)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}
+	if len(suites) != len(expected) || suites[0] != expected[0] || suites[1] != expected[1] {
+		t.Errorf("expected suites %v, but got %v", expected, suites)
+	}
+
+	if suites, err := ParseCipherSuites(nil); err != nil || len(suites) != 0 {
+		t.Errorf("expected no suites and no error, but got %v (%v)", suites, err)
+	}
+	for _, name := range []string{"TLS_RSA_WITH_RC4_128_SHA", "TLS_NOT_A_SUITE"} {
+		if _, err := ParseCipherSuites([]string{name}); err == nil {
+			t.Errorf("expected an error for cipher suite %q", name)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	reloader := newTestReloader(t)
+	config := TLSConfig(reloader, tls.VersionTLS13, nil)
+	if config.MinVersion != tls.VersionTLS13 || config.CipherSuites != nil {
+		t.Errorf("expected TLS 1.3 with the default suites, but got %x and %v", config.MinVersion, config.CipherSuites)
+	}
+	if cert, err := config.GetCertificate(&tls.ClientHelloInfo{}); err != nil || cert == nil {
+		t.Errorf("expected the reloader's certificate, but got %v (%v)", cert, err)
+	}
+	for _, proto := range config.NextProtos {
+		if proto == acme.ALPNProto {
+			t.Errorf("expected no ACME protocol without an ACME manager")
+		}
+	}
+
+	config = TLSConfig(&autocert.Manager{}, tls.VersionTLS12, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+	if last := config.NextProtos[len(config.NextProtos)-1]; last != acme.ALPNProto {
+		t.Errorf("expected the tls-alpn-01 protocol to be offered with an ACME manager, but got %v", config.NextProtos)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	cases := []struct {
+		httpsAddr string
+		method    string
+		target    string
+		expected  string
+	}{
+		{":443", http.MethodGet, "http://api.example.com/v1/users?q=1", "https://api.example.com/v1/users?q=1"},
+		{":443", http.MethodPost, "http://api.example.com:80/v1/sessions", "https://api.example.com/v1/sessions"},
+		{":8443", http.MethodGet, "http://localhost:8080/", "https://localhost:8443/"},
+	}
+	for _, c := range cases {
+		resp := httptest.NewRecorder()
+		Redirect(c.httpsAddr).ServeHTTP(resp, httptest.NewRequest(c.method, c.target, nil))
+		if resp.Code != http.StatusPermanentRedirect || resp.Header().Get("Location") != c.expected {
+			t.Errorf("%s %s: expected a 308 to %s, but got %d to %s", c.method, c.target, c.expected, resp.Code, resp.Header().Get("Location"))
+		}
+	}
+}
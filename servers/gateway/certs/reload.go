@@ -0,0 +1,115 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is how often a Reloader checks its files by default
+const DefaultReloadInterval = time.Minute
+
+// fileVersion identifies the contents of a file without reading it
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader is a Source serving the certificate in a pair of PEM files, which
+// it loads again whenever they change so that renewed certificates are
+// served without restarting the gateway
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	versions [2]fileVersion
+}
+
+// NewReloader constructs a Reloader serving the certificate chain in
+// `certFile` with the private key in `keyFile`, returning an error if they
+// can't be loaded
+func NewReloader(certFile string, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the certificate most recently loaded
+func (r *Reloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the certificate again if either file changed since it was
+// last loaded, and reports whether it did. The certificate being served is
+// kept if the files can't be loaded, such as when only one of them has been
+// replaced so far.
+func (r *Reloader) Reload() (bool, error) {
+	versions := [2]fileVersion{}
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("Error reading TLS certificate: %v", err)
+		}
+		versions[i] = fileVersion{info.ModTime(), info.Size()}
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && versions == r.versions
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("Error loading TLS certificate: %v", err)
+	}
+	// Older Go versions don't parse the leaf certificate while loading it
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, fmt.Errorf("Error parsing TLS certificate: %v", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.versions = versions
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch checks the files for changes every `interval` until `ctx` is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				slog.Error("Error reloading TLS certificate, still serving the previous one", "certFile", r.certFile, "error", err)
+			} else if reloaded {
+				r.mu.RLock()
+				notAfter := r.cert.Leaf.NotAfter
+				r.mu.RUnlock()
+				slog.Info("Reloaded TLS certificate", "certFile", r.certFile, "notAfter", notAfter)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for `commonName` and
+// its key to `certFile` and `keyFile`
+func writeCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("error writing certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("error writing key: %v", err)
+	}
+}
+
+// newTestReloader returns a Reloader serving a certificate for "first.test"
+func newTestReloader(t *testing.T) *Reloader {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "fullchain.pem"), filepath.Join(dir, "privkey.pem")
+	writeCertificate(t, certFile, keyFile, "first.test")
+	reloader, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return reloader
+}
+
+// servedName returns the common name of the certificate `reloader` serves
+func servedName(t *testing.T, reloader *Reloader) string {
+	cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewReloader(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("expected an error for missing files")
+	}
+	invalid := filepath.Join(dir, "invalid.pem")
+	os.WriteFile(invalid, []byte("not a certificate"), 0600)
+	if _, err := NewReloader(invalid, invalid); err == nil {
+		t.Errorf("expected an error for invalid files")
+	}
+}
+
+func TestReload(t *testing.T) {
+	reloader := newTestReloader(t)
+	if name := servedName(t, reloader); name != "first.test" {
+		t.Fatalf("expected the first certificate, but got %q", name)
+	}
+	if reloaded, err := reloader.Reload(); reloaded || err != nil {
+		t.Errorf("expected unchanged files not to be reloaded, but got %v (%v)", reloaded, err)
+	}
+
+	// A certificate whose key hasn't been replaced yet doesn't match it
+	other := t.TempDir()
+	writeCertificate(t, filepath.Join(other, "cert.pem"), filepath.Join(other, "key.pem"), "second.test")
+	renewed, _ := os.ReadFile(filepath.Join(other, "cert.pem"))
+	os.WriteFile(reloader.certFile, renewed, 0600)
+	if reloaded, err := reloader.Reload(); reloaded || err == nil {
+		t.Errorf("expected a mismatched key to fail to reload, but got %v (%v)", reloaded, err)
+	}
+	if name := servedName(t, reloader); name != "first.test" {
+		t.Errorf("expected the previous certificate to still be served, but got %q", name)
+	}
+
+	writeCertificate(t, reloader.certFile, reloader.keyFile, "second.test")
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Errorf("expected the renewed certificate to be reloaded, but got %v (%v)", reloaded, err)
+	}
+	if name := servedName(t, reloader); name != "second.test" {
+		t.Errorf("expected the renewed certificate to be served, but got %q", name)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	reloader := newTestReloader(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reloader.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	writeCertificate(t, reloader.certFile, reloader.keyFile, "second.test")
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(t, reloader) != "second.test" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the watcher to reload the renewed certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the watcher to stop when its context is done")
+	}
+}
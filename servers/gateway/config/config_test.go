@@ -0,0 +1,213 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEnv returns a getenv function that looks names up in `env`
+func fakeEnv(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+// writeFile writes `contents` to a file named `name` in a temporary directory
+// and returns its path
+func writeFile(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+	return path
+}
+
+func TestReadDefaults(t *testing.T) {
+	config, err := Read("", fakeEnv(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(config, Default()) {
+		t.Errorf("expected the defaults, but got %+v", config)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents string
+	}{
+		{
+			"gateway.yaml",
+			`
+addr: ":4000"
+redisTimeout: 500ms
+webSocketOrigins: ["https://example.com"]
+upstreams:
+  messaging:
+    urls: ["http://messaging1", "http://messaging2"]
+    routes: ["/v1/channels"]
+cors:
+  origins: ["https://*.example.com"]
+  credentials: true
+  routes:
+    /v1/events/:
+      origins: ["https://partner.example.org"]
+rateLimits:
+  - route: /v1/channels/
+    methods: [POST]
+    requests: 30
+    period: 1m
+`,
+		},
+		{
+			"gateway.toml",
+			`
+addr = ":4000"
+redisTimeout = "500ms"
+webSocketOrigins = ["https://example.com"]
+
+[upstreams.messaging]
+urls = ["http://messaging1", "http://messaging2"]
+routes = ["/v1/channels"]
+
+[cors]
+origins = ["https://*.example.com"]
+credentials = true
+
+[cors.routes."/v1/events/"]
+origins = ["https://partner.example.org"]
+
+[[rateLimits]]
+route = "/v1/channels/"
+methods = ["POST"]
+requests = 30
+period = "1m"
+`,
+		},
+	}
+
+	for _, c := range cases {
+		config, err := Read(writeFile(t, c.name, c.contents), fakeEnv(nil))
+		if err != nil {
+			t.Errorf("case %s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if config.Addr != ":4000" || config.RedisTimeout != 500*time.Millisecond {
+			t.Errorf("case %s: settings from file not applied: %+v", c.name, config)
+		}
+		if !reflect.DeepEqual(config.WebSocketOrigins, []string{"https://example.com"}) {
+			t.Errorf("case %s: wrong origins: %v", c.name, config.WebSocketOrigins)
+		}
+		expected := &Upstream{URLs: []string{"http://messaging1", "http://messaging2"}, Routes: []string{"/v1/channels"}}
+		if !reflect.DeepEqual(config.Upstreams["messaging"], expected) {
+			t.Errorf("case %s: wrong messaging upstream: %+v", c.name, config.Upstreams["messaging"])
+		}
+		// Rate limits in the file replace the defaults
+		expectedLimits := []*RateLimit{{Route: "/v1/channels/", Methods: []string{"POST"}, Requests: 30, Period: time.Minute}}
+		if !reflect.DeepEqual(config.RateLimits, expectedLimits) {
+			t.Errorf("case %s: wrong rate limits: %+v", c.name, config.RateLimits)
+		}
+		// CORS settings missing from the file keep their defaults
+		if !reflect.DeepEqual(config.CORS.Origins, []string{"https://*.example.com"}) || !config.CORS.Credentials || len(config.CORS.Methods) != 5 {
+			t.Errorf("case %s: wrong CORS policy: %+v", c.name, config.CORS)
+		}
+		override := config.CORS.Routes["/v1/events/"]
+		if override == nil || !reflect.DeepEqual(override.Origins, []string{"https://partner.example.org"}) || override.Credentials || len(override.Methods) != 0 {
+			t.Errorf("case %s: wrong CORS override: %+v", c.name, override)
+		}
+		// Settings missing from the file keep their defaults
+		if config.RabbitMQQueue != "events" || config.Upstreams["meetup"] == nil {
+			t.Errorf("case %s: defaults not kept: %+v", c.name, config)
+		}
+	}
+}
+
+func TestReadEnvOverridesFile(t *testing.T) {
+	path := writeFile(t, "gateway.yaml", "addr: \":4000\"\nsessionKey: fromfile\n")
+	env := fakeEnv(map[string]string{
+		"ADDR":             ":5000",
+		"MYSQLTIMEOUT":     "1s",
+		"SHUTDOWNTIMEOUT":  "30s",
+		"MESSAGESADDR":     "messagingserver, http://messaging2:80",
+		"WSORIGINS":        "https://a.com,https://b.com",
+		"ACMEDOMAINS":      "api.example.com, www.example.com",
+		"CORSORIGINS":      "https://a.com, https://*.b.com",
+		"WSPINGINTERVAL":   "10s",
+		"WSMAXMESSAGESIZE": "8192",
+		"INMEMORYUSERS":    "true",
+	})
+
+	config, err := Read(path, env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Addr != ":5000" || config.SessionKey != "fromfile" || config.MySQLTimeout != time.Second || config.ShutdownTimeout != 30*time.Second {
+		t.Errorf("environment not applied over file: %+v", config)
+	}
+	if urls := config.Upstreams["messaging"].URLs; !reflect.DeepEqual(urls, []string{"messagingserver", "http://messaging2:80"}) {
+		t.Errorf("wrong messaging URLs: %v", urls)
+	}
+	if !reflect.DeepEqual(config.WebSocketOrigins, []string{"https://a.com", "https://b.com"}) {
+		t.Errorf("wrong origins: %v", config.WebSocketOrigins)
+	}
+	if !reflect.DeepEqual(config.ACMEDomains, []string{"api.example.com", "www.example.com"}) {
+		t.Errorf("wrong ACME domains: %v", config.ACMEDomains)
+	}
+	if !reflect.DeepEqual(config.CORS.Origins, []string{"https://a.com", "https://*.b.com"}) {
+		t.Errorf("wrong CORS origins: %v", config.CORS.Origins)
+	}
+	if config.WebSocketPingInterval != 10*time.Second || config.WebSocketMaxMessageSize != 8192 || config.WebSocketPongWait != time.Minute {
+		t.Errorf("wrong WebSocket settings: ping %v, pong %v, max message size %d", config.WebSocketPingInterval, config.WebSocketPongWait, config.WebSocketMaxMessageSize)
+	}
+	if !config.InMemoryUsers {
+		t.Error("expected users to be kept in memory")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		path string
+		env  map[string]string
+	}{
+		{"Missing File", filepath.Join(os.TempDir(), "does-not-exist.yaml"), nil},
+		{"Unknown Extension", writeFile(t, "gateway.json", "{}"), nil},
+		{"Malformed YAML", writeFile(t, "gateway.yaml", "addr: [\n"), nil},
+		{"Bad Duration", "", map[string]string{"REDISTIMEOUT": "soon"}},
+		{"Bad Size", "", map[string]string{"WSMAXMESSAGESIZE": "4KB"}},
+		{"Bad Bool", "", map[string]string{"INMEMORYUSERS": "sure"}},
+	}
+
+	for _, c := range cases {
+		config, err := Read(c.path, fakeEnv(c.env))
+		if _, ok := err.(Errors); !ok {
+			t.Errorf("case %s: expected Errors but got %v", c.name, err)
+		}
+		if config == nil {
+			t.Errorf("case %s: expected the configuration to be returned with the error", c.name)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	config := Default()
+	config.SessionKey = "supersecretsessionkey"
+	config.IdentityKey = "supersecretidentitykey"
+	config.DSN = "root:testpwd@tcp(mysqlserver:3306)/infodb"
+
+	redacted := config.Redacted()
+	for _, value := range []string{redacted.SessionKey, redacted.IdentityKey, redacted.DSN, redacted.RabbitMQURL} {
+		if strings.Contains(value, "supersecret") || strings.Contains(value, "testpwd") || strings.Contains(value, "guest:guest") {
+			t.Errorf("secret was not redacted from %q", value)
+		}
+	}
+	if config.SessionKey != "supersecretsessionkey" {
+		t.Error("Redacted modified the original configuration")
+	}
+}
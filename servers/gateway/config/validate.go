@@ -0,0 +1,262 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MinSessionKeyLength is the shortest session or identity signing key that
+// is accepted
+const MinSessionKeyLength = 16
+
+// Errors lists every problem found in a configuration
+type Errors []error
+
+// Error returns the problems one per line
+func (errs Errors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return "Invalid configuration:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Validate checks every setting and returns an Errors listing all of the
+// problems found, or nil if the configuration is valid. The TLS versions and
+// cipher suites, CORS policies, upstream pools and rate limit rules are only
+// checked for what can be told from the settings alone, and are checked in
+// full by the packages they configure.
+func (c *Config) Validate() error {
+	errs := Errors{}
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(validateAddr("addr (ADDR)", c.Addr))
+	if len(c.HTTPAddr) > 0 {
+		check(validateAddr("httpAddr (HTTPADDR)", c.HTTPAddr))
+	}
+	if len(c.AdminAddr) > 0 {
+		check(validateAddr("adminAddr (ADMINADDR)", c.AdminAddr))
+	}
+	if c.ShutdownTimeout < 0 {
+		check(fmt.Errorf("shutdownTimeout (SHUTDOWNTIMEOUT) can't be negative"))
+	}
+	if c.HealthCheckTimeout < 0 {
+		check(fmt.Errorf("healthCheckTimeout (HEALTHCHECKTIMEOUT) can't be negative"))
+	}
+	if err := new(slog.Level).UnmarshalText([]byte(c.LogLevel)); len(c.LogLevel) > 0 && err != nil {
+		check(fmt.Errorf("logLevel (LOGLEVEL) must be debug, info, warn or error"))
+	}
+	if len(c.TracingEndpoint) > 0 {
+		check(validateURL("tracingEndpoint (TRACINGENDPOINT)", c.TracingEndpoint, "http", "https"))
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		check(fmt.Errorf("tracingSampleRatio must be between 0 and 1"))
+	}
+	if len(c.ACMEDomains) == 0 {
+		check(validateFile("tlsCert (TLSCERT)", c.TLSCert))
+		check(validateFile("tlsKey (TLSKEY)", c.TLSKey))
+	} else {
+		check(c.validateACME())
+	}
+	if c.TLSReloadInterval < 0 {
+		check(fmt.Errorf("tlsReloadInterval (TLSRELOADINTERVAL) can't be negative"))
+	}
+	if len(c.SessionKey) < MinSessionKeyLength {
+		check(fmt.Errorf("sessionKey (SESSIONKEY) must be at least %d characters long", MinSessionKeyLength))
+	}
+	if len(c.IdentityKey) < MinSessionKeyLength {
+		check(fmt.Errorf("identityKey (IDENTITYKEY) must be at least %d characters long", MinSessionKeyLength))
+	} else if c.IdentityKey == c.SessionKey {
+		check(fmt.Errorf("identityKey (IDENTITYKEY) must be different from sessionKey (SESSIONKEY)"))
+	}
+
+	check(validateAddr("redisAddr (REDISADDR)", c.RedisAddr))
+	if c.RedisTimeout < 0 {
+		check(fmt.Errorf("redisTimeout (REDISTIMEOUT) can't be negative"))
+	}
+	if len(c.DSN) > 0 {
+		if _, err := mysql.ParseDSN(c.DSN); err != nil {
+			check(fmt.Errorf("dsn (DSN) is not a valid MySQL DSN: %v", err))
+		}
+		if c.InMemoryUsers {
+			check(fmt.Errorf("inMemoryUsers (INMEMORYUSERS) can't be enabled along with dsn (DSN)"))
+		}
+	} else if !c.InMemoryUsers {
+		check(fmt.Errorf("dsn (DSN) must be set, unless inMemoryUsers (INMEMORYUSERS) is enabled to run without MySQL"))
+	}
+	if c.MySQLTimeout < 0 {
+		check(fmt.Errorf("mysqlTimeout (MYSQLTIMEOUT) can't be negative"))
+	}
+
+	check(validateURL("rabbitMQURL (RABBITMQURL)", c.RabbitMQURL, "amqp", "amqps"))
+	if len(c.RabbitMQQueue) == 0 {
+		check(fmt.Errorf("rabbitMQQueue (RABBITMQQUEUE) must be set"))
+	}
+	for _, origin := range c.WebSocketOrigins {
+		check(validateURL("webSocketOrigins (WSORIGINS)", origin, "http", "https"))
+	}
+	if c.WebSocketPingInterval <= 0 {
+		check(fmt.Errorf("webSocketPingInterval (WSPINGINTERVAL) must be positive"))
+	} else if c.WebSocketPongWait <= c.WebSocketPingInterval {
+		check(fmt.Errorf("webSocketPongWait (WSPONGWAIT) must be longer than webSocketPingInterval (WSPINGINTERVAL)"))
+	}
+	if c.WebSocketWriteWait <= 0 {
+		check(fmt.Errorf("webSocketWriteWait (WSWRITEWAIT) must be positive"))
+	}
+	if c.WebSocketMaxMessageSize <= 0 {
+		check(fmt.Errorf("webSocketMaxMessageSize (WSMAXMESSAGESIZE) must be positive"))
+	}
+	if c.CORS == nil {
+		check(fmt.Errorf("cors must be set"))
+	} else {
+		routes := make([]string, 0, len(c.CORS.Routes))
+		for route := range c.CORS.Routes {
+			routes = append(routes, route)
+		}
+		sort.Strings(routes)
+		for _, route := range routes {
+			if !strings.HasPrefix(route, "/") {
+				check(fmt.Errorf("cors.routes route %q must start with /", route))
+			} else if c.CORS.Routes[route] == nil {
+				check(fmt.Errorf("cors.routes[%q] is empty", route))
+			}
+		}
+	}
+
+	// Check upstreams in name order so errors are reported consistently
+	names := make([]string, 0, len(c.Upstreams))
+	for name := range c.Upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	routeOwners := map[string]string{}
+	for _, name := range names {
+		u := c.Upstreams[name]
+		field := fmt.Sprintf("upstreams.%s", name)
+		if u == nil || len(u.URLs) == 0 {
+			check(fmt.Errorf("%s must have at least one URL", field))
+		} else if _, err := u.Targets(); err != nil {
+			check(fmt.Errorf("%s: %v", field, err))
+		}
+		if u == nil || len(u.Routes) == 0 {
+			check(fmt.Errorf("%s must have at least one route", field))
+			continue
+		}
+		for _, route := range u.Routes {
+			if !strings.HasPrefix(route, "/") {
+				check(fmt.Errorf("%s route %q must start with /", field, route))
+			} else if owner, found := routeOwners[route]; found {
+				check(fmt.Errorf("%s route %q is already routed to upstreams.%s", field, route, owner))
+			} else {
+				routeOwners[route] = name
+			}
+		}
+	}
+
+	for i, limit := range c.RateLimits {
+		if limit == nil {
+			check(fmt.Errorf("rateLimits[%d] is empty", i))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateACME checks the settings used to obtain certificates from an
+// ACME CA, which replace the certificate files
+func (c *Config) validateACME() error {
+	if len(c.TLSCert) > 0 || len(c.TLSKey) > 0 {
+		return fmt.Errorf("tlsCert (TLSCERT) and tlsKey (TLSKEY) can't be set along with acmeDomains (ACMEDOMAINS)")
+	}
+	for _, domain := range c.ACMEDomains {
+		if len(domain) == 0 || strings.ContainsAny(domain, ":/") {
+			return fmt.Errorf("acmeDomains (ACMEDOMAINS): %q must be a host name without a scheme or port", domain)
+		}
+	}
+	if len(c.ACMECacheDir) == 0 {
+		return fmt.Errorf("acmeCacheDir (ACMECACHEDIR) must be set")
+	}
+	if len(c.ACMEDirectoryURL) > 0 {
+		if err := validateURL("acmeDirectoryURL (ACMEDIRECTORYURL)", c.ACMEDirectoryURL, "https"); err != nil {
+			return err
+		}
+	}
+	if len(c.ACMECACert) > 0 {
+		return validateFile("acmeCACert (ACMECACERT)", c.ACMECACert)
+	}
+	return nil
+}
+
+// Targets parses the URLs of the upstream. URLs without a scheme, such as
+// "messagingserver:80", are assumed to be http.
+func (u *Upstream) Targets() ([]*url.URL, error) {
+	targets := make([]*url.URL, len(u.URLs))
+	for i, rawURL := range u.URLs {
+		if !strings.Contains(rawURL, "://") {
+			rawURL = "http://" + rawURL
+		}
+		target, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q: %v", u.URLs[i], err)
+		}
+		if (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+			return nil, fmt.Errorf("URL %q must be an http or https URL with a host", u.URLs[i])
+		}
+		targets[i] = target
+	}
+	return targets, nil
+}
+
+// validateAddr checks that `addr` is a host:port address
+func validateAddr(field string, addr string) error {
+	if len(addr) == 0 {
+		return fmt.Errorf("%s must be set", field)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("%s must be a host:port address: %v", field, err)
+	}
+	return nil
+}
+
+// validateFile checks that `path` names a readable file
+func validateFile(field string, path string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("%s must be set", field)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %v", field, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: %s is a directory", field, path)
+	}
+	return nil
+}
+
+// validateURL checks that `rawURL` is an absolute URL with a host and one of `schemes`
+func validateURL(field string, rawURL string, schemes ...string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%s: invalid URL %q: %v", field, rawURL, err)
+	}
+	for _, scheme := range schemes {
+		if parsed.Scheme == scheme && len(parsed.Host) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: URL %q must have a host and one of the schemes %s", field, rawURL, strings.Join(schemes, ", "))
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration that passes validation
+func validConfig(t *testing.T) *Config {
+	config := Default()
+	config.TLSCert = writeFile(t, "fullchain.pem", "cert")
+	config.TLSKey = writeFile(t, "privkey.pem", "key")
+	config.SessionKey = "0123456789abcdef"
+	config.IdentityKey = "fedcba9876543210"
+	config.RedisAddr = "redisserver:6379"
+	config.DSN = "root:testpwd@tcp(mysqlserver:3306)/infodb"
+	config.Upstreams["messaging"].URLs = []string{"messagingserver"}
+	config.Upstreams["meetup"].URLs = []string{"http://meetupserver:80"}
+	return config
+}
+
+func TestValidateValid(t *testing.T) {
+	config := validConfig(t)
+	if err := config.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	targets, err := config.Upstreams["messaging"].Targets()
+	if err != nil || len(targets) != 1 || targets[0].String() != "http://messagingserver" {
+		t.Errorf("expected URL without a scheme to default to http, but got %v (%v)", targets, err)
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	config := validConfig(t)
+	config.Addr = "443"
+	config.AdminAddr = "localhost"
+	config.LogLevel = "verbose"
+	config.TLSKey = "/does/not/exist.pem"
+	config.SessionKey = "key"
+	config.IdentityKey = "key"
+	config.DSN = "not a dsn"
+	config.RabbitMQURL = "http://rabbitmqserver"
+	config.WebSocketOrigins = []string{"client.info441summary.me"}
+	config.Upstreams["meetup"].URLs = nil
+	config.Upstreams["meetup"].Routes = append(config.Upstreams["meetup"].Routes, "/v1/channels", "v1/events")
+
+	err := config.Validate()
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("expected Errors but got %v", err)
+	}
+
+	expected := []string{
+		"addr (ADDR)",
+		"adminAddr (ADMINADDR)",
+		"logLevel (LOGLEVEL)",
+		"tlsKey (TLSKEY)",
+		"sessionKey (SESSIONKEY)",
+		"identityKey (IDENTITYKEY)",
+		"dsn (DSN)",
+		"rabbitMQURL (RABBITMQURL)",
+		"webSocketOrigins (WSORIGINS)",
+		"upstreams.meetup must have at least one URL",
+		`upstreams.meetup route "v1/events" must start with /`,
+		`upstreams.messaging route "/v1/channels" is already routed to upstreams.meetup`,
+	}
+	if len(errs) != len(expected) {
+		t.Errorf("expected %d errors but got %d:\n%v", len(expected), len(errs), errs)
+	}
+	message := errs.Error()
+	for _, problem := range expected {
+		if !strings.Contains(message, problem) {
+			t.Errorf("expected an error about %q in:\n%s", problem, message)
+		}
+	}
+}
+
+func TestValidateUserStore(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{"MySQL", func(c *Config) {}, true},
+		{"In Memory", func(c *Config) { c.DSN = ""; c.InMemoryUsers = true }, true},
+		{"No DSN", func(c *Config) { c.DSN = "" }, false},
+		{"Both", func(c *Config) { c.InMemoryUsers = true }, false},
+	}
+	for _, c := range cases {
+		config := validConfig(t)
+		c.modify(config)
+		if err := config.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	config := validConfig(t)
+	for value, expected := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		config.LogLevel = value
+		if level := config.Level(); level != expected {
+			t.Errorf("expected log level %q to be %v, but got %v", value, expected, level)
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	caCert := writeFile(t, "pebble.minica.pem", "ca")
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{"Negative Reload Interval", func(c *Config) { c.TLSReloadInterval = -time.Second }, false},
+		{"HTTP Listener", func(c *Config) { c.HTTPAddr = ":80" }, true},
+		{"Invalid HTTP Listener", func(c *Config) { c.HTTPAddr = "80" }, false},
+		{"ACME", func(c *Config) {
+			c.TLSCert, c.TLSKey = "", ""
+			c.ACMEDomains = []string{"api.example.com"}
+			c.ACMEDirectoryURL = "https://localhost:14000/dir"
+			c.ACMECACert = caCert
+		}, true},
+		{"ACME With Files", func(c *Config) { c.ACMEDomains = []string{"api.example.com"} }, false},
+		{"ACME Domain With Port", func(c *Config) {
+			c.TLSCert, c.TLSKey = "", ""
+			c.ACMEDomains = []string{"api.example.com:443"}
+		}, false},
+		{"ACME Without Cache", func(c *Config) {
+			c.TLSCert, c.TLSKey = "", ""
+			c.ACMEDomains = []string{"api.example.com"}
+			c.ACMECacheDir = ""
+		}, false},
+		{"ACME Plain HTTP Directory", func(c *Config) {
+			c.TLSCert, c.TLSKey = "", ""
+			c.ACMEDomains = []string{"api.example.com"}
+			c.ACMEDirectoryURL = "http://localhost:14000/dir"
+		}, false},
+		{"ACME Missing CA", func(c *Config) {
+			c.TLSCert, c.TLSKey = "", ""
+			c.ACMEDomains = []string{"api.example.com"}
+			c.ACMECACert = "/does/not/exist.pem"
+		}, false},
+	}
+
+	for _, c := range cases {
+		config := validConfig(t)
+		c.modify(config)
+		if err := config.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got error %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{"Any Origin", func(c *Config) { c.CORS.Origins = []string{"*"} }, true},
+		{"Missing", func(c *Config) { c.CORS = nil }, false},
+		{"Route", func(c *Config) { c.CORS.Routes = map[string]*CORS{"/v1/events/": {Methods: []string{"GET"}}} }, true},
+		{"Relative Route", func(c *Config) { c.CORS.Routes = map[string]*CORS{"v1/events/": {}} }, false},
+		{"Empty Route", func(c *Config) { c.CORS.Routes = map[string]*CORS{"/v1/events/": nil} }, false},
+	}
+
+	for _, c := range cases {
+		config := validConfig(t)
+		c.modify(config)
+		if err := config.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got error %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestValidateWebSocket(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{"Shorter Ping Interval", func(c *Config) { c.WebSocketPingInterval = 5 * time.Second }, true},
+		{"No Ping Interval", func(c *Config) { c.WebSocketPingInterval = 0 }, false},
+		{"Pong Wait Not Longer Than Ping Interval", func(c *Config) { c.WebSocketPongWait = c.WebSocketPingInterval }, false},
+		{"No Write Wait", func(c *Config) { c.WebSocketWriteWait = 0 }, false},
+		{"Negative Max Message Size", func(c *Config) { c.WebSocketMaxMessageSize = -1 }, false},
+	}
+
+	for _, c := range cases {
+		config := validConfig(t)
+		c.modify(config)
+		if err := config.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got error %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestValidateUpstreamURLs(t *testing.T) {
+	cases := []struct {
+		url   string
+		valid bool
+	}{
+		{"messagingserver", true},
+		{"messagingserver:4000", true},
+		{"https://messaging.example.com", true},
+		{"ftp://messagingserver", false},
+		{"http://", false},
+		{"http://messaging server", false},
+	}
+
+	for _, c := range cases {
+		upstream := &Upstream{URLs: []string{c.url}, Routes: []string{"/"}}
+		if _, err := upstream.Targets(); (err == nil) != c.valid {
+			t.Errorf("URL %q: expected valid to be %v, but got error %v", c.url, c.valid, err)
+		}
+	}
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	config := validConfig(t)
+	config.RateLimits = append(config.RateLimits, nil)
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "rateLimits[4] is empty") {
+		t.Errorf("expected an error about the empty rate limit, but got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"serverside-final-project/servers/gateway/certs"
+	"serverside-final-project/servers/gateway/config"
+	"serverside-final-project/servers/gateway/handlers"
+	"serverside-final-project/servers/gateway/ratelimit"
+	"serverside-final-project/servers/gateway/upstream"
+	"sort"
+)
+
+// validateConfig checks the configuration along with the settings that only
+// the packages they configure can check, and returns a config.Errors listing
+// all of the problems found, or nil if the gateway can start with it
+func validateConfig(cfg *config.Config) error {
+	errs := config.Errors{}
+	if err := cfg.Validate(); err != nil {
+		if problems, ok := err.(config.Errors); ok {
+			errs = append(errs, problems...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := certs.ParseVersion(cfg.TLSMinVersion); err != nil {
+		check(fmt.Errorf("tlsMinVersion (TLSMINVERSION): %v", err))
+	}
+	if _, err := certs.ParseCipherSuites(cfg.TLSCipherSuites); err != nil {
+		check(fmt.Errorf("tlsCipherSuites (TLSCIPHERSUITES): %v", err))
+	}
+	if cfg.CORS != nil {
+		if err := corsPolicy(cfg.CORS, nil).Validate(); err != nil {
+			check(fmt.Errorf("cors: %v", err))
+		}
+		routes := make([]string, 0, len(cfg.CORS.Routes))
+		for route, override := range cfg.CORS.Routes {
+			if override != nil {
+				routes = append(routes, route)
+			}
+		}
+		sort.Strings(routes)
+		for _, route := range routes {
+			if err := corsPolicy(cfg.CORS.Routes[route], cfg.CORS).Validate(); err != nil {
+				check(fmt.Errorf("cors.routes[%q]: %v", route, err))
+			}
+		}
+	}
+
+	// Check upstreams in name order so errors are reported consistently
+	names := make([]string, 0, len(cfg.Upstreams))
+	for name, service := range cfg.Upstreams {
+		if service != nil && len(service.URLs) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Invalid URLs have already been reported by cfg.Validate
+		if _, err := cfg.Upstreams[name].Targets(); err != nil {
+			continue
+		}
+		if _, err := newPool(name, cfg.Upstreams[name]); err != nil {
+			check(fmt.Errorf("upstreams.%s: %v", name, err))
+		}
+	}
+
+	for i, limit := range cfg.RateLimits {
+		if limit == nil {
+			continue
+		}
+		if err := rateLimitRule(limit).Validate(); err != nil {
+			check(fmt.Errorf("rateLimits[%d]: %v", i, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// corsPolicy returns the CORS policy of `c`, inheriting the settings left
+// out from `parent` if it isn't nil
+func corsPolicy(c *config.CORS, parent *config.CORS) *handlers.CORSPolicy {
+	policy := &handlers.CORSPolicy{
+		Origins:        c.Origins,
+		Methods:        c.Methods,
+		Headers:        c.Headers,
+		ExposedHeaders: c.ExposedHeaders,
+		Credentials:    c.Credentials,
+		MaxAge:         c.MaxAge,
+	}
+	if parent == nil {
+		return policy
+	}
+	if len(policy.Origins) == 0 {
+		policy.Origins = parent.Origins
+	}
+	if len(policy.Methods) == 0 {
+		policy.Methods = parent.Methods
+	}
+	if len(policy.Headers) == 0 {
+		policy.Headers = parent.Headers
+	}
+	if len(policy.ExposedHeaders) == 0 {
+		policy.ExposedHeaders = parent.ExposedHeaders
+	}
+	if policy.MaxAge == 0 {
+		policy.MaxAge = parent.MaxAge
+	}
+	return policy
+}
+
+// corsOverrides returns the CORS policies of routes that override the
+// default policy `c`, by route
+func corsOverrides(c *config.CORS) map[string]*handlers.CORSPolicy {
+	overrides := make(map[string]*handlers.CORSPolicy, len(c.Routes))
+	for route, override := range c.Routes {
+		overrides[route] = corsPolicy(override, c)
+	}
+	return overrides
+}
+
+// rateLimitRule returns the rate limiting rule of `limit`, applying defaults
+func rateLimitRule(limit *config.RateLimit) *ratelimit.Rule {
+	by := limit.By
+	if len(by) == 0 {
+		by = ratelimit.ByUser
+	}
+	return &ratelimit.Rule{
+		Route:    limit.Route,
+		Methods:  limit.Methods,
+		Requests: limit.Requests,
+		Period:   limit.Period,
+		Burst:    limit.Burst,
+		By:       by,
+	}
+}
+
+// rateLimitRules returns the rules of every rate limit, in order
+func rateLimitRules(limits []*config.RateLimit) []*ratelimit.Rule {
+	rules := make([]*ratelimit.Rule, len(limits))
+	for i, limit := range limits {
+		rules[i] = rateLimitRule(limit)
+	}
+	return rules
+}
+
+// newPool constructs the pool that balances requests across the URLs of
+// the upstream `service`
+func newPool(name string, service *config.Upstream) (*upstream.Pool, error) {
+	targets, err := service.Targets()
+	if err != nil {
+		return nil, err
+	}
+	return upstream.NewPool(name, targets, upstream.Options{
+		Strategy:       upstream.Strategy(service.Strategy),
+		Weights:        service.Weights,
+		HealthPath:     service.HealthPath,
+		HealthInterval: service.HealthInterval,
+		HealthTimeout:  service.HealthTimeout,
+		MaxFails:       service.MaxFails,
+		Attempts:       service.Attempts,
+
+		DialTimeout:           service.DialTimeout,
+		ResponseHeaderTimeout: service.ResponseHeaderTimeout,
+		Timeout:               service.Timeout,
+		BreakerFailures:       service.BreakerFailures,
+		BreakerCooldown:       service.BreakerCooldown,
+	})
+}
+
+// webSocketOptions returns the heartbeat and limits of WebSocket connections
+func webSocketOptions(cfg *config.Config) handlers.WebSocketOptions {
+	return handlers.WebSocketOptions{
+		PingInterval:   cfg.WebSocketPingInterval,
+		PongWait:       cfg.WebSocketPongWait,
+		WriteWait:      cfg.WebSocketWriteWait,
+		MaxMessageSize: cfg.WebSocketMaxMessageSize,
+	}
+}
+
+// tlsVersion returns the oldest TLS version accepted, which is 1.2 if
+// TLSMinVersion is invalid
+func tlsVersion(cfg *config.Config) uint16 {
+	version, err := certs.ParseVersion(cfg.TLSMinVersion)
+	if err != nil {
+		return tls.VersionTLS12
+	}
+	return version
+}
+
+// cipherSuites returns the cipher suites allowed for TLS 1.2 connections,
+// or nil for Go's defaults
+func cipherSuites(cfg *config.Config) []uint16 {
+	suites, _ := certs.ParseCipherSuites(cfg.TLSCipherSuites)
+	if len(suites) == 0 {
+		return nil
+	}
+	return suites
+}
+
+// acmeOptions returns how certificates are obtained from the ACME CA
+func acmeOptions(cfg *config.Config) certs.ACMEOptions {
+	return certs.ACMEOptions{
+		Domains:      cfg.ACMEDomains,
+		Email:        cfg.ACMEEmail,
+		CacheDir:     cfg.ACMECacheDir,
+		DirectoryURL: cfg.ACMEDirectoryURL,
+		CACert:       cfg.ACMECACert,
+	}
+}
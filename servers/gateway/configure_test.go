@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"serverside-final-project/servers/gateway/config"
+	"serverside-final-project/servers/gateway/handlers"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration that passes validation
+func validConfig(t *testing.T) *config.Config {
+	cfg := config.Default()
+	dir := t.TempDir()
+	cfg.TLSCert = filepath.Join(dir, "fullchain.pem")
+	cfg.TLSKey = filepath.Join(dir, "privkey.pem")
+	for _, path := range []string{cfg.TLSCert, cfg.TLSKey} {
+		if err := ioutil.WriteFile(path, []byte("pem"), 0600); err != nil {
+			t.Fatalf("error writing %s: %v", path, err)
+		}
+	}
+	cfg.SessionKey = "0123456789abcdef"
+	cfg.IdentityKey = "fedcba9876543210"
+	cfg.RedisAddr = "redisserver:6379"
+	cfg.DSN = "root:testpwd@tcp(mysqlserver:3306)/infodb"
+	cfg.Upstreams["messaging"].URLs = []string{"messagingserver"}
+	cfg.Upstreams["meetup"].URLs = []string{"http://meetupserver:80"}
+	return cfg
+}
+
+// The defaults of the config package must match those of the packages they
+// configure
+func TestConfigDefaults(t *testing.T) {
+	cfg := config.Default()
+	if options := webSocketOptions(cfg); options != handlers.DefaultWebSocketOptions() {
+		t.Errorf("expected the default WebSocket options %+v, but got %+v", handlers.DefaultWebSocketOptions(), options)
+	}
+	origins := cfg.CORS.Origins
+	if policy := corsPolicy(cfg.CORS, nil); !reflect.DeepEqual(policy, handlers.DefaultCORSPolicy(origins...)) {
+		t.Errorf("expected the default CORS policy %+v, but got %+v", handlers.DefaultCORSPolicy(origins...), policy)
+	}
+	if tlsVersion(cfg) != tls.VersionTLS12 || cipherSuites(cfg) != nil {
+		t.Errorf("expected TLS 1.2 and the default cipher suites by default, but got %x and %v", tlsVersion(cfg), cipherSuites(cfg))
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *config.Config)
+		valid  bool
+	}{
+		{"Defaults", func(c *config.Config) {}, true},
+		{"TLS 1.3", func(c *config.Config) { c.TLSMinVersion = "1.3" }, true},
+		{"TLS 1.1", func(c *config.Config) { c.TLSMinVersion = "1.1" }, false},
+		{"Cipher Suites", func(c *config.Config) { c.TLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"} }, true},
+		{"Insecure Cipher Suite", func(c *config.Config) { c.TLSCipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"} }, false},
+		{"Any Origin With Credentials", func(c *config.Config) { c.CORS.Origins = []string{"*"}; c.CORS.Credentials = true }, false},
+		{"No Origins", func(c *config.Config) { c.CORS.Origins = nil }, false},
+		{"Invalid Route Origin", func(c *config.Config) {
+			c.CORS.Routes = map[string]*config.CORS{"/v1/events/": {Origins: []string{"example.org"}}}
+		}, false},
+		{"Missing CORS", func(c *config.Config) { c.CORS = nil }, false},
+		{"Invalid Upstream URL", func(c *config.Config) { c.Upstreams["meetup"].URLs = []string{"ftp://meetupserver"} }, false},
+	}
+
+	for _, c := range cases {
+		cfg := validConfig(t)
+		c.modify(cfg)
+		if err := validateConfig(cfg); (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got error %v", c.name, c.valid, err)
+		}
+	}
+}
+
+// Problems found by the config package and by the packages it configures
+// are reported together
+func TestValidateConfigReportsEveryError(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.SessionKey = "key"
+	cfg.TLSMinVersion = "1.1"
+	cfg.Upstreams["messaging"].Strategy = "random"
+	cfg.RateLimits[0].By = "session"
+
+	err := validateConfig(cfg)
+	errs, ok := err.(config.Errors)
+	if !ok {
+		t.Fatalf("expected config.Errors but got %v", err)
+	}
+	expected := []string{"sessionKey (SESSIONKEY)", "tlsMinVersion (TLSMINVERSION)", "upstreams.messaging", "rateLimits[0]"}
+	if len(errs) != len(expected) {
+		t.Errorf("expected %d errors but got %d:\n%v", len(expected), len(errs), errs)
+	}
+	for _, problem := range expected {
+		if !strings.Contains(errs.Error(), problem) {
+			t.Errorf("expected an error about %q in:\n%s", problem, errs.Error())
+		}
+	}
+}
+
+func TestCORSOverrides(t *testing.T) {
+	cfg := config.Default()
+	cfg.CORS.Credentials = true
+	cfg.CORS.Routes = map[string]*config.CORS{"/v1/events/": {Origins: []string{"https://partner.example.org"}}}
+
+	override := corsOverrides(cfg.CORS)["/v1/events/"]
+	if override == nil || !reflect.DeepEqual(override.Origins, []string{"https://partner.example.org"}) {
+		t.Fatalf("wrong CORS override: %+v", override)
+	}
+	// Settings left out of an override are inherited, and credentials aren't
+	if override.Credentials || override.MaxAge != 10*time.Minute || !reflect.DeepEqual(override.Methods, cfg.CORS.Methods) {
+		t.Errorf("wrong CORS override: %+v", override)
+	}
+}
+
+func TestValidateUpstreamPool(t *testing.T) {
+	cases := []struct {
+		name     string
+		upstream *config.Upstream
+		valid    bool
+	}{
+		{"Defaults", &config.Upstream{}, true},
+		{"Weighted", &config.Upstream{Strategy: "weighted", Weights: []int{3, 1}}, true},
+		{"Least Connections", &config.Upstream{Strategy: "least-connections", Attempts: 1}, true},
+		{"Unknown Strategy", &config.Upstream{Strategy: "random"}, false},
+		{"Missing Weight", &config.Upstream{Strategy: "weighted", Weights: []int{3}}, false},
+		{"Relative Health Path", &config.Upstream{HealthPath: "healthz"}, false},
+		{"Negative Max Fails", &config.Upstream{MaxFails: -1}, false},
+		{"Timeouts", &config.Upstream{DialTimeout: time.Second, Timeout: time.Minute, BreakerCooldown: time.Minute}, true},
+		{"Negative Timeout", &config.Upstream{ResponseHeaderTimeout: -time.Second}, false},
+	}
+
+	for _, c := range cases {
+		cfg := validConfig(t)
+		c.upstream.URLs = []string{"messaging1", "messaging2"}
+		c.upstream.Routes = cfg.Upstreams["messaging"].Routes
+		cfg.Upstreams["messaging"] = c.upstream
+		if err := validateConfig(cfg); (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got error %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestValidateRateLimitRules(t *testing.T) {
+	cases := []struct {
+		name  string
+		limit *config.RateLimit
+		valid bool
+	}{
+		{"Per User", &config.RateLimit{Route: "/v1/events", Methods: []string{"POST"}, Requests: 10, Period: time.Minute}, true},
+		{"Per IP With Burst", &config.RateLimit{Route: "/v1/", Requests: 100, Period: time.Second, Burst: 200, By: "ip"}, true},
+		{"Relative Route", &config.RateLimit{Route: "v1/events", Requests: 10, Period: time.Minute}, false},
+		{"No Requests", &config.RateLimit{Route: "/v1/events", Period: time.Minute}, false},
+		{"No Period", &config.RateLimit{Route: "/v1/events", Requests: 10}, false},
+		{"Negative Burst", &config.RateLimit{Route: "/v1/events", Requests: 10, Period: time.Minute, Burst: -1}, false},
+		{"Unknown Subject", &config.RateLimit{Route: "/v1/events", Requests: 10, Period: time.Minute, By: "session"}, false},
+		{"Empty", nil, false},
+	}
+
+	for _, c := range cases {
+		cfg := validConfig(t)
+		cfg.RateLimits = append(cfg.RateLimits, c.limit)
+		if err := validateConfig(cfg); (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got error %v", c.name, c.valid, err)
+		}
+	}
+
+	if rule := rateLimitRules(config.Default().RateLimits)[2]; rule.By != "user" {
+		t.Errorf("expected rate limits to be per user by default, but got %q", rule.By)
+	}
+}
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"net/http"
+	"serverside-final-project/servers/gateway/logging"
+	"serverside-final-project/servers/gateway/metrics"
 	"serverside-final-project/servers/gateway/models/users"
 	"serverside-final-project/servers/gateway/sessions"
 	"strconv"
@@ -14,43 +16,52 @@ import (
 // UsersHandler creates new user accounts
 func (hc *Context) UsersHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		contentType := r.Header.Get("Content-type")
-		if strings.HasPrefix(contentType, "application/json") {
+		if isJSONContentType(r) {
 			newUser := &users.NewUser{}
 			dec := json.NewDecoder(r.Body)
 			if err := dec.Decode(newUser); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				WriteProblem(w, r, http.StatusBadRequest, "Request body is not a valid new user: "+err.Error())
 				return
 			}
 
 			err := newUser.Validate()
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				WriteProblem(w, r, http.StatusBadRequest, err.Error())
 				return
 			}
 
-			user, _ := newUser.ToUser()
-			insertedUser, err := hc.UserStore.Insert(user)
+			user, err := newUser.ToUser()
 			if err != nil {
-				fmt.Printf("Error inserting user into database: %v\n", err)
+				slog.ErrorContext(r.Context(), "Error converting new user", "error", err)
+				WriteProblem(w, r, http.StatusInternalServerError, "Error creating user")
+				return
+			}
+
+			// The user and their first sign-in are saved together so that a
+			// failure never leaves behind a user without a sign-in record
+			signInTime := time.Now()
+			insertedUser, err := hc.UserStore.InsertAndLogUser(r.Context(), user, signInTime, getClientIP(r))
+			if err != nil {
+				writeStoreProblem(w, r, err, "Error creating user")
+				return
 			}
-			sessionState := NewSessionState(time.Now(), insertedUser)
-			_, sessionErr := sessions.BeginSession(hc.SessionIDKey, hc.SessionStore, sessionState, w)
-			if sessionErr != nil {
-				fmt.Printf("Error creating session: %v\n", err)
+			logging.SetUserID(r.Context(), insertedUser.ID)
+
+			sessionState := NewSessionState(signInTime, insertedUser)
+
+			if _, err := sessions.BeginSession(r.Context(), hc.SessionIDKey, hc.SessionStore, sessionState, w); err != nil {
+				// The account exists at this point, so the client can still sign in
+				slog.ErrorContext(r.Context(), "Error creating session", "error", err)
+				WriteProblem(w, r, http.StatusInternalServerError, "Account created but the session could not be started, please sign in")
 				return
 			}
 
-			w.WriteHeader(http.StatusCreated)
-			w.Header().Set("Content-Type", "application/json")
-			userJSON, _ := json.Marshal(insertedUser)
-			w.Write(userJSON)
+			WriteJSON(w, http.StatusCreated, insertedUser)
 		} else {
-			w.WriteHeader(http.StatusUnsupportedMediaType)
-			w.Write([]byte("Request body must be in JSON"))
+			WriteProblem(w, r, http.StatusUnsupportedMediaType, "Request body must be in JSON")
 		}
 	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r, http.MethodPost)
 	}
 }
 
@@ -58,7 +69,7 @@ func (hc *Context) UsersHandler(w http.ResponseWriter, r *http.Request) {
 func (hc *Context) SpecificUserHandler(w http.ResponseWriter, r *http.Request) {
 	_, err := sessions.GetSessionID(r, hc.SessionIDKey)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeSessionProblem(w, r, err)
 		return
 	}
 
@@ -68,55 +79,64 @@ func (hc *Context) SpecificUserHandler(w http.ResponseWriter, r *http.Request) {
 		var idValue int64
 		var UserID string = URL[i+1 : len(URL)]
 		if UserID == "me" {
-			sessionState := &SessionState{}
-			sessions.GetState(r, hc.SessionIDKey, hc.SessionStore, sessionState)
-			user := sessionState.User
-			idValue = user.ID
+			sessionState, err := hc.getSessionState(r)
+			if err != nil {
+				writeSessionProblem(w, r, err)
+				return
+			}
+			logging.SetUserID(r.Context(), sessionState.User.ID)
+			idValue = sessionState.User.ID
 		} else {
-			idValue, _ = strconv.ParseInt(UserID, 10, 64)
+			idValue, err = strconv.ParseInt(UserID, 10, 64)
+			if err != nil {
+				WriteProblem(w, r, http.StatusBadRequest, "User ID must be an integer or \"me\"")
+				return
+			}
 		}
-		user, err := hc.UserStore.GetByID(idValue)
+		user, err := hc.UserStore.GetByID(r.Context(), idValue)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeStoreProblem(w, r, err, "Error fetching user")
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json")
-		userJSON, _ := json.Marshal(user)
-		w.Write(userJSON)
+		WriteJSON(w, http.StatusOK, user)
 	} else if r.Method == http.MethodPatch {
 		URL := r.URL.RequestURI()
 		i := strings.LastIndex(URL, "/")
 		UserID := URL[i+1 : len(URL)]
-		sessionState := &SessionState{}
-		sessions.GetState(r, hc.SessionIDKey, hc.SessionStore, sessionState)
+		sessionState, err := hc.getSessionState(r)
+		if err != nil {
+			writeSessionProblem(w, r, err)
+			return
+		}
+		logging.SetUserID(r.Context(), sessionState.User.ID)
 		if UserID != "me" {
-			userID, _ := strconv.ParseInt(UserID, 10, 64)
+			userID, err := strconv.ParseInt(UserID, 10, 64)
+			if err != nil {
+				WriteProblem(w, r, http.StatusBadRequest, "User ID must be an integer or \"me\"")
+				return
+			}
 			if userID != sessionState.User.ID {
-				w.WriteHeader(http.StatusForbidden)
-				w.Write([]byte("Invalid UserID"))
+				WriteProblem(w, r, http.StatusForbidden, "Invalid UserID")
 				return
 			}
 		}
-		contentType := r.Header.Get("Content-type")
-		if !strings.HasPrefix(contentType, "application/json") {
-			w.WriteHeader(http.StatusUnsupportedMediaType)
-			w.Write([]byte("Request body must be in JSON"))
+		if !isJSONContentType(r) {
+			WriteProblem(w, r, http.StatusUnsupportedMediaType, "Request body must be in JSON")
 			return
 		}
 		updates := &users.Updates{}
 		dec := json.NewDecoder(r.Body)
 		if err := dec.Decode(updates); err != nil {
-			fmt.Printf("error decoding JSON: %v\n", err)
+			WriteProblem(w, r, http.StatusBadRequest, "Request body is not a valid update: "+err.Error())
 			return
 		}
-		sessionState.User.ApplyUpdates(updates)
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json")
-		userJSON, _ := json.Marshal(sessionState.User)
-		w.Write(userJSON)
+		if err := sessionState.User.ApplyUpdates(updates); err != nil {
+			WriteProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, sessionState.User)
 	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r, http.MethodGet, http.MethodPatch)
 	}
 }
 
@@ -124,67 +144,114 @@ func (hc *Context) SpecificUserHandler(w http.ResponseWriter, r *http.Request) {
 // allows clients to begin a new session using an existing user's credentials.
 func (hc *Context) SessionsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if isJSONContentType(r) {
 			credentials := &users.Credentials{}
 			decoder := json.NewDecoder(r.Body)
 			if err := decoder.Decode(credentials); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				WriteProblem(w, r, http.StatusBadRequest, "Request body is not valid credentials: "+err.Error())
 				return
 			}
 
-			user, err := hc.UserStore.GetByEmail(credentials.Email)
-			if err != nil {
+			// Only unknown emails are failed sign-ins, other errors mean the
+			// store couldn't be asked
+			user, err := hc.UserStore.GetByEmail(r.Context(), credentials.Email)
+			if err == users.ErrUserNotFound {
+				metrics.SignIn(false)
 				time.Sleep(time.Second)
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte("invalid credentials"))
+				WriteProblem(w, r, http.StatusUnauthorized, "invalid credentials")
+				return
+			} else if err != nil {
+				writeStoreProblem(w, r, err, "Error fetching user")
 				return
 			}
 
 			if user.Authenticate(credentials.Password) != nil {
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte("invalid credentials"))
+				metrics.SignIn(false)
+				WriteProblem(w, r, http.StatusUnauthorized, "invalid credentials")
+				return
+			}
+
+			// Only reveal the suspension to someone who knows the password
+			if user.Suspended {
+				metrics.SignIn(false)
+				WriteProblem(w, r, http.StatusForbidden, "This account is suspended")
 				return
 			}
 
 			sessionState := NewSessionState(time.Now(), user)
-			sessions.BeginSession(hc.SessionIDKey, hc.SessionStore, sessionState, w)
-
-			clientIP := r.Header.Get("X-Forwarded-For")
-			if len(clientIP) != 0 {
-				ipList := strings.Split(clientIP, ", ")
-				clientIP = ipList[0]
-			} else {
-				clientIP = r.RemoteAddr
+			if _, err := sessions.BeginSession(r.Context(), hc.SessionIDKey, hc.SessionStore, sessionState, w); err != nil {
+				slog.ErrorContext(r.Context(), "Error creating session", "error", err)
+				WriteProblem(w, r, http.StatusInternalServerError, "Error creating session")
+				return
 			}
+			metrics.SignIn(true)
+			logging.SetUserID(r.Context(), user.ID)
 
-			hc.UserStore.LogUser(user.ID, sessionState.Time, clientIP)
+			// The session has begun, so a sign-in that can't be logged is
+			// only reported
+			if err := hc.UserStore.LogUser(r.Context(), user.ID, sessionState.Time, getClientIP(r)); err != nil {
+				slog.ErrorContext(r.Context(), "Error logging user sign in", "error", err)
+			}
 
-			w.WriteHeader(http.StatusCreated)
-			w.Header().Set("Content-Type", "application/json")
-			userJSON, _ := json.Marshal(user)
-			w.Write(userJSON)
+			WriteJSON(w, http.StatusCreated, user)
 		} else {
-			w.WriteHeader(http.StatusUnsupportedMediaType)
-			w.Write([]byte("Request body must be in JSON"))
+			WriteProblem(w, r, http.StatusUnsupportedMediaType, "Request body must be in JSON")
 		}
 	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r, http.MethodPost)
 	}
 }
 
+// SignOutResponse is the response to ending a session
+type SignOutResponse struct {
+	Message string `json:"message"`
+}
+
 // SpecificSessionHandler handles requests related to a specific
 // authenticated session
 func (hc *Context) SpecificSessionHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodDelete {
 		pathElements := strings.Split(r.URL.RequestURI(), "/")
 		if pathElements[len(pathElements)-1] != "mine" {
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte("Last element of URL must be mine, got " + pathElements[len(pathElements)-1]))
+			WriteProblem(w, r, http.StatusForbidden, "Last element of URL must be mine, got "+pathElements[len(pathElements)-1])
+			return
+		}
+		// Signing out without a valid session has nothing to end, but a
+		// session that couldn't be deleted is still valid
+		_, err := sessions.EndSession(r, hc.SessionIDKey, hc.SessionStore)
+		if err != nil && !sessionRejected(err) {
+			slog.ErrorContext(r.Context(), "Error ending session", "error", err)
+			WriteProblem(w, r, http.StatusInternalServerError, "Error signing out")
 			return
 		}
-		sessions.EndSession(r, hc.SessionIDKey, hc.SessionStore)
-		w.Write([]byte("Signed out"))
+		WriteJSON(w, http.StatusOK, &SignOutResponse{Message: "Signed out"})
 	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r, http.MethodDelete)
+	}
+}
+
+// getClientIP returns the IP address of the client that made the request,
+// preferring the first address in the X-Forwarded-For header if present
+func getClientIP(r *http.Request) string {
+	clientIP := r.Header.Get("X-Forwarded-For")
+	if len(clientIP) != 0 {
+		ipList := strings.Split(clientIP, ", ")
+		return ipList[0]
+	}
+	return r.RemoteAddr
+}
+
+// writeStoreProblem maps an error returned by the user store to the matching
+// problem response. Conflicts and missing users are reported to the client,
+// while any other error is logged and hidden behind `detail`.
+func writeStoreProblem(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	switch err {
+	case users.ErrEmailTaken, users.ErrUserNameTaken:
+		WriteProblem(w, r, http.StatusConflict, err.Error())
+	case users.ErrUserNotFound:
+		WriteProblem(w, r, http.StatusNotFound, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), "User store error", "error", err)
+		WriteProblem(w, r, http.StatusInternalServerError, detail)
 	}
 }
@@ -2,14 +2,18 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"serverside-final-project/servers/gateway/models/users"
 	"serverside-final-project/servers/gateway/sessions"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Set request content type header to wrong type and look for status code
@@ -111,7 +115,7 @@ func TestValidNewUserUsersHandler(t *testing.T) {
 	}
 	// Check the testuserstore to see that hardcoded value equals return inputted values
 	testuserstore := &users.TestUserStore{Client: "client"}
-	hardCodedUser, _ := testuserstore.GetByEmail("stanley@gmail.com")
+	hardCodedUser, _ := testuserstore.GetByEmail(req.Context(), "stanley@gmail.com")
 	if hardCodedUser.Email != user.Email || hardCodedUser.FirstName != user.FirstName || hardCodedUser.LastName != user.LastName ||
 		hardCodedUser.PhotoURL != user.PhotoURL || hardCodedUser.UserName != user.UserName {
 		t.Errorf("Database returned wrong information")
@@ -280,6 +284,26 @@ func TestUnauthenticatedUserIDSpecificUserHandler(t *testing.T) {
 	}
 }
 
+// Test if the user ID in the request URL is neither "me" nor a number,
+// respond with StatusBadRequest (400)
+func TestNonNumericUserIDSpecificUserHandler(t *testing.T) {
+	Context := NewContext("key", sessions.NewMemStore(3*time.Minute, 3*time.Minute), users.NewTestUserStore("client"))
+	rr, context := CreateNewUser(Context)
+
+	req, err := http.NewRequest("PATCH", "/v1/users/someone", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", rr.Header().Get("Authorization"))
+	rrTwo := httptest.NewRecorder()
+	handler := http.HandlerFunc(context.SpecificUserHandler)
+	handler.ServeHTTP(rrTwo, req)
+	if status := rrTwo.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
 // Test if header does not start with application/json, return http.StatusUnsupportedMediaType (415)
 func TestWrongContentTypeSpecificUserHandler(t *testing.T) {
 	Context := NewContext("key", sessions.NewMemStore(3*time.Minute, 3*time.Minute), users.NewTestUserStore("client"))
@@ -513,6 +537,40 @@ func TestSessionsHandlerBadCredentials(t *testing.T) {
 	}
 }
 
+// unavailableUserStore is a users.Store whose database can't be reached
+type unavailableUserStore struct {
+	*users.TestUserStore
+}
+
+func (us *unavailableUserStore) GetByEmail(ctx context.Context, email string) (*users.User, error) {
+	return nil, fmt.Errorf("dial tcp 10.0.0.8:3306: connect: connection refused")
+}
+
+// A user store outage is not a failed sign-in
+func TestSessionsHandlerUserStoreError(t *testing.T) {
+	userCredentials := &users.Credentials{Email: "stanley@gmail.com", Password: "123456"}
+	buffer, _ := json.Marshal(userCredentials)
+	req, err := http.NewRequest("POST", "", bytes.NewReader(buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	context := NewContext("key", sessions.NewMemStore(3*time.Minute, 3*time.Minute), &unavailableUserStore{users.NewTestUserStore("client")})
+
+	handler := http.HandlerFunc(context.SessionsHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	if strings.Contains(rr.Body.String(), "10.0.0.8") {
+		t.Errorf("expected the store's error to be hidden, but got %s", rr.Body.String())
+	}
+}
+
 func TestSessionsHandlerBadPassword(t *testing.T) {
 	rr := httptest.NewRecorder()
 
@@ -538,6 +596,46 @@ func TestSessionsHandlerBadPassword(t *testing.T) {
 	}
 }
 
+func TestSessionsHandlerSuspendedUser(t *testing.T) {
+	ctx := context.Background()
+	userStore := users.NewMemStore()
+	passHash, _ := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	user, err := userStore.Insert(ctx, &users.User{Email: "stanley@gmail.com", PassHash: passHash, UserName: "swu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hctx := NewContext("key", sessions.NewMemStore(3*time.Minute, 3*time.Minute), userStore)
+
+	cases := []struct {
+		password  string
+		suspended bool
+		status    int
+	}{
+		{"123456", false, http.StatusCreated},
+		{"123456", true, http.StatusForbidden},
+		{"hi", true, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		userStore.SetSuspended(ctx, user.ID, c.suspended)
+
+		buffer, _ := json.Marshal(&users.Credentials{Email: "stanley@gmail.com", Password: c.password})
+		req, err := http.NewRequest("POST", "/v1/sessions", bytes.NewReader(buffer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(hctx.SessionsHandler).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != c.status {
+			t.Errorf("suspended %v, password %q: handler returned wrong status code: got %v want %v",
+				c.suspended, c.password, status, c.status)
+		}
+	}
+}
+
 func TestSessionsHandlerIPAddress(t *testing.T) {
 	rr := httptest.NewRecorder()
 
@@ -576,9 +674,14 @@ func TestSpecificSessionHandlerCorrectInput(t *testing.T) {
 
 	handler := http.HandlerFunc(context.SpecificSessionHandler)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Body.String(); status != "Signed out" {
+	response := &SignOutResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil || response.Message != "Signed out" {
 		t.Errorf("handler returned wrong status message: got %v want %v",
-			status, "Signed out")
+			rr.Body.String(), "Signed out")
+	}
+	if content := rr.Header().Get("Content-Type"); content != "application/json" {
+		t.Errorf("handler returned wrong content type: got %v want %v",
+			content, "application/json")
 	}
 }
 
@@ -619,3 +722,54 @@ func TestSpecificSessionHandlerBadURL(t *testing.T) {
 			status, http.StatusForbidden)
 	}
 }
+
+// A session that the store fails to delete is still valid, so signing out
+// must not be reported as successful
+func TestSpecificSessionHandlerStoreError(t *testing.T) {
+	context := NewContext("key", &unavailableSessionStore{sessions.NewMemStore(3*time.Minute, 3*time.Minute)}, users.NewTestUserStore("client"))
+	sid, err := sessions.NewSessionID("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodDelete, "/v1/sessions/mine", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+sid.String())
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(context.SpecificSessionHandler)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+}
+
+// A session store outage must not sign the user out
+func TestSessionStoreErrorSpecificUserHandler(t *testing.T) {
+	context := NewContext("key", &unavailableSessionStore{sessions.NewMemStore(3*time.Minute, 3*time.Minute)}, users.NewTestUserStore("client"))
+	sid, err := sessions.NewSessionID("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPatch} {
+		req, err := http.NewRequest(method, "/v1/users/me", strings.NewReader(`{"firstName":"Stan"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+sid.String())
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(context.SpecificUserHandler)
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusServiceUnavailable {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				method, status, http.StatusServiceUnavailable)
+		}
+		if strings.Contains(rr.Body.String(), "10.0.0.7") {
+			t.Errorf("%s: expected the store's error to be hidden, but got %s", method, rr.Body.String())
+		}
+	}
+}
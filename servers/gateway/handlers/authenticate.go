@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"serverside-final-project/servers/gateway/identity"
+	"serverside-final-project/servers/gateway/logging"
+	"serverside-final-project/servers/gateway/sessions"
+)
+
+// Authenticate returns middleware that authenticates requests before they
+// are proxied to an upstream service by `next`. Identity headers sent by the
+// client are always removed. Requests without a session ID are passed on
+// without an identity, leaving the upstream to decide whether they are
+// allowed. Requests whose session ID is invalid, expired or deleted are
+// rejected with 401, and the user of a valid session is added to the request
+// as a signed X-User header. Session store failures are answered by
+// writeSessionProblem.
+func (hc *Context) Authenticate(signer *identity.Signer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity.Strip(r.Header)
+
+		sessionState, err := hc.getSessionState(r)
+		if err == sessions.ErrNoSessionID {
+			next.ServeHTTP(w, r)
+			return
+		} else if err != nil {
+			writeSessionProblem(w, r, err)
+			return
+		}
+
+		logging.SetUserID(r.Context(), sessionState.User.ID)
+		user, err := json.Marshal(sessionState.User)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error encoding user for X-User header", "error", err)
+			WriteProblem(w, r, http.StatusInternalServerError, "Error authenticating request")
+			return
+		}
+		signer.Sign(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeSessionProblem maps an error getting the session state of a request
+// to the matching problem response. Requests without a session, or whose
+// session is invalid, expired or deleted, are answered with 401 so that the
+// client signs in again. Any other error, such as the session store being
+// unreachable, is logged and answered with 503 without its details, since
+// the session may still be valid.
+func writeSessionProblem(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, sessions.ErrNoSessionID):
+		WriteProblem(w, r, http.StatusUnauthorized, "Please sign in")
+	case sessionRejected(err):
+		WriteProblem(w, r, http.StatusUnauthorized, "Your session is invalid or has expired, please sign in again")
+	default:
+		slog.ErrorContext(r.Context(), "Error getting session state", "error", err)
+		WriteProblem(w, r, http.StatusServiceUnavailable, "Sessions are temporarily unavailable, please try again")
+	}
+}
+
+// sessionRejected reports whether `err` means that the request has no valid
+// session, rather than that its session couldn't be read
+func sessionRejected(err error) bool {
+	return errors.Is(err, sessions.ErrNoSessionID) || errors.Is(err, sessions.ErrInvalidScheme) ||
+		errors.Is(err, sessions.ErrInvalidID) || errors.Is(err, sessions.ErrStateNotFound)
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"serverside-final-project/servers/gateway/identity"
+	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/sessions"
+	"serverside-final-project/servers/gateway/upstream"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testSessionKey  = "test session key"
+	testIdentityKey = "test identity key"
+)
+
+// identityEcho is an upstream that verifies the identity of each request
+// and echoes it back in the response headers
+type identityEcho struct {
+	requests int32
+	verifier *identity.Verifier
+}
+
+func (e *identityEcho) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&e.requests, 1)
+	user, err := e.verifier.Verify(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Echo-User", string(user))
+	w.Header().Set("Echo-Signed", r.Header.Get(identity.SignatureHeader))
+	w.WriteHeader(http.StatusOK)
+}
+
+// unavailableSessionStore is a sessions.Store whose server can't be reached
+type unavailableSessionStore struct {
+	*sessions.MemStore
+}
+
+// errSessionStoreUnavailable is the internal error of unavailableSessionStore,
+// which must never be shown to clients
+var errSessionStoreUnavailable = errors.New("dial tcp 10.0.0.7:6379: connect: connection refused")
+
+func (us *unavailableSessionStore) Get(ctx context.Context, sid sessions.SessionID, sessionState interface{}) error {
+	return errSessionStoreUnavailable
+}
+
+func (us *unavailableSessionStore) Delete(ctx context.Context, sid sessions.SessionID) error {
+	return errSessionStoreUnavailable
+}
+
+// newTestProxy starts an identityEcho upstream and returns it along with
+// the gateway handler that authenticates requests and proxies them to it
+func newTestProxy(t *testing.T, store sessions.Store) (*identityEcho, http.Handler) {
+	echo := &identityEcho{verifier: identity.NewVerifier([]byte(testIdentityKey), identity.DefaultMaxAge)}
+	server := httptest.NewServer(echo)
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+	pool, err := upstream.NewPool("meetup", []*url.URL{target}, upstream.Options{})
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	hctx := NewContext(testSessionKey, store, users.NewTestUserStore("client"))
+	return echo, hctx.Authenticate(identity.NewSigner([]byte(testIdentityKey)), NewProxy(pool))
+}
+
+func TestAuthenticateProxy(t *testing.T) {
+	store := sessions.NewMemStore(time.Hour, time.Hour)
+	user := &users.User{ID: 1, UserName: "tester", FirstName: "Test", LastName: "User"}
+	sid, err := sessions.NewSessionID(testSessionKey)
+	if err != nil {
+		t.Fatalf("error generating session ID: %v", err)
+	}
+	if err := store.Save(context.Background(), sid, NewSessionState(time.Now(), user)); err != nil {
+		t.Fatalf("error saving session: %v", err)
+	}
+	deleted, _ := sessions.NewSessionID(testSessionKey)
+	otherKey, _ := sessions.NewSessionID("another key")
+	forged := `{"id":2,"userName":"admin"}`
+
+	cases := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+		expectProxied  bool
+		expectedUser   *users.User
+	}{
+		{"Valid Session", "Bearer " + string(sid), http.StatusOK, true, user},
+		{"No Session", "", http.StatusOK, true, nil},
+		{"Deleted Session", "Bearer " + string(deleted), http.StatusUnauthorized, false, nil},
+		{"Session Signed With Another Key", "Bearer " + string(otherKey), http.StatusUnauthorized, false, nil},
+		{"Unsupported Scheme", "Basic dGVzdGVyOnBhc3N3b3Jk", http.StatusUnauthorized, false, nil},
+	}
+
+	for _, c := range cases {
+		echo, handler := newTestProxy(t, store)
+		req := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+		if len(c.authorization) > 0 {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		// Clients must never be able to choose their own identity
+		req.Header.Set(identity.Header, forged)
+		req.Header.Set(identity.SignatureHeader, "v1,t=0,s=forged")
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		if resp.Code != c.expectedStatus {
+			t.Errorf("case %s: expected status %d, but got %d: %s", c.name, c.expectedStatus, resp.Code, resp.Body.String())
+		}
+		if proxied := atomic.LoadInt32(&echo.requests) > 0; proxied != c.expectProxied {
+			t.Errorf("case %s: expected proxied to be %v, but got %v", c.name, c.expectProxied, proxied)
+		}
+		if resp.Code == http.StatusUnauthorized && resp.Header().Get(contentTypeHeader) != contentTypeProblemJSON {
+			t.Errorf("case %s: expected a problem response, but got %s", c.name, resp.Header().Get(contentTypeHeader))
+		}
+		if !c.expectProxied {
+			continue
+		}
+
+		echoed := resp.Header().Get("Echo-User")
+		if c.expectedUser == nil {
+			if len(echoed) > 0 || len(resp.Header().Get("Echo-Signed")) > 0 {
+				t.Errorf("case %s: expected the client's identity headers to be stripped, but upstream got %q", c.name, echoed)
+			}
+			continue
+		}
+		received := &users.User{}
+		if err := json.Unmarshal([]byte(echoed), received); err != nil {
+			t.Fatalf("case %s: error decoding X-User %q: %v", c.name, echoed, err)
+		}
+		if received.ID != c.expectedUser.ID || received.UserName != c.expectedUser.UserName {
+			t.Errorf("case %s: expected upstream to get user %+v, but got %+v", c.name, c.expectedUser, received)
+		}
+	}
+}
+
+// A session store outage must not sign users out, nor reveal its cause
+func TestAuthenticateSessionStoreUnavailable(t *testing.T) {
+	echo, handler := newTestProxy(t, &unavailableSessionStore{sessions.NewMemStore(time.Hour, time.Hour)})
+	sid, err := sessions.NewSessionID(testSessionKey)
+	if err != nil {
+		t.Fatalf("error generating session ID: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer "+string(sid))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, but got %d: %s", http.StatusServiceUnavailable, resp.Code, resp.Body.String())
+	}
+	if strings.Contains(resp.Body.String(), "10.0.0.7") {
+		t.Errorf("expected the store's error to be hidden, but got %s", resp.Body.String())
+	}
+	if atomic.LoadInt32(&echo.requests) > 0 {
+		t.Error("expected the request not to be proxied")
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"serverside-final-project/servers/gateway/models/users"
+	"strings"
+)
+
+// Reasons reported when a user name or email is not available
+const (
+	reasonInvalid  = "invalid"
+	reasonReserved = "reserved"
+	reasonTaken    = "taken"
+)
+
+// Availability reports whether a single user name or email can be used to
+// sign up, and if not, why
+type Availability struct {
+	Value     string `json:"value"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// AvailabilityResponse is the response body of the availability endpoint.
+// Only the fields that were queried are included.
+type AvailabilityResponse struct {
+	UserName *Availability `json:"userName,omitempty"`
+	Email    *Availability `json:"email,omitempty"`
+}
+
+// UserAvailabilityHandler reports whether the `userName` and/or `email` query
+// string parameters are free to be used by a new account. User names are
+// compared in their normalized form, so a name that only differs from an
+// existing one by case or confusable characters is reported as taken.
+func (hc *Context) UserAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	query := r.URL.Query()
+	_, hasUserName := query["userName"]
+	_, hasEmail := query["email"]
+	if !hasUserName && !hasEmail {
+		WriteProblem(w, r, http.StatusBadRequest, "At least one of the userName or email query parameters is required")
+		return
+	}
+
+	response := &AvailabilityResponse{}
+	if hasUserName {
+		availability, err := hc.userNameAvailability(r.Context(), query.Get("userName"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking user name availability", "error", err)
+			WriteProblem(w, r, http.StatusInternalServerError, "Error checking user name availability")
+			return
+		}
+		response.UserName = availability
+	}
+	if hasEmail {
+		availability, err := hc.emailAvailability(r.Context(), query.Get("email"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking email availability", "error", err)
+			WriteProblem(w, r, http.StatusInternalServerError, "Error checking email availability")
+			return
+		}
+		response.Email = availability
+	}
+
+	WriteJSON(w, http.StatusOK, response)
+}
+
+// userNameAvailability checks the user name against the validation rules, the
+// reserved names list and the user store
+func (hc *Context) userNameAvailability(ctx context.Context, userName string) (*Availability, error) {
+	availability := &Availability{Value: userName}
+	switch {
+	case len(userName) == 0 || strings.Contains(userName, " "):
+		availability.Reason = reasonInvalid
+	case users.IsReservedUserName(userName):
+		availability.Reason = reasonReserved
+	default:
+		_, err := hc.UserStore.GetByUserName(ctx, userName)
+		if err == nil {
+			availability.Reason = reasonTaken
+		} else if err != users.ErrUserNotFound {
+			return nil, err
+		}
+	}
+	availability.Available = len(availability.Reason) == 0
+	return availability, nil
+}
+
+// emailAvailability checks the email address against the validation rules and
+// the user store
+func (hc *Context) emailAvailability(ctx context.Context, email string) (*Availability, error) {
+	availability := &Availability{Value: email}
+	if _, err := mail.ParseAddress(email); err != nil {
+		availability.Reason = reasonInvalid
+	} else {
+		_, err := hc.UserStore.GetByEmail(ctx, users.NormalizeEmail(email))
+		if err == nil {
+			availability.Reason = reasonTaken
+		} else if err != users.ErrUserNotFound {
+			return nil, err
+		}
+	}
+	availability.Available = len(availability.Reason) == 0
+	return availability, nil
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/sessions"
+	"testing"
+	"time"
+)
+
+func TestUserAvailabilityHandler(t *testing.T) {
+	context := NewContext("key", sessions.NewMemStore(3*time.Minute, 3*time.Minute), users.NewTestUserStore("client"))
+
+	cases := []struct {
+		name     string
+		query    url.Values
+		userName *Availability
+		email    *Availability
+	}{
+		{
+			"Available user name",
+			url.Values{"userName": {"hawk"}},
+			&Availability{"hawk", true, ""},
+			nil,
+		},
+		{
+			"Taken user name",
+			url.Values{"userName": {"swu"}},
+			&Availability{"swu", false, reasonTaken},
+			nil,
+		},
+		{
+			"Taken user name with different case",
+			url.Values{"userName": {"SWU"}},
+			&Availability{"SWU", false, reasonTaken},
+			nil,
+		},
+		{
+			"Reserved user name with confusables",
+			url.Values{"userName": {"Аdmіn"}},
+			&Availability{"Аdmіn", false, reasonReserved},
+			nil,
+		},
+		{
+			"Invalid user name",
+			url.Values{"userName": {"stan ley"}},
+			&Availability{"stan ley", false, reasonInvalid},
+			nil,
+		},
+		{
+			"Available email",
+			url.Values{"email": {"hawk@gmail.com"}},
+			nil,
+			&Availability{"hawk@gmail.com", true, ""},
+		},
+		{
+			"Taken email with different case",
+			url.Values{"email": {"Stanley@Gmail.com"}},
+			nil,
+			&Availability{"Stanley@Gmail.com", false, reasonTaken},
+		},
+		{
+			"Invalid email",
+			url.Values{"email": {"stanley"}},
+			nil,
+			&Availability{"stanley", false, reasonInvalid},
+		},
+		{
+			"Both user name and email",
+			url.Values{"userName": {"admin"}, "email": {"hawk@gmail.com"}},
+			&Availability{"admin", false, reasonReserved},
+			&Availability{"hawk@gmail.com", true, ""},
+		},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/v1/users/availability?"+c.query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		context.UserAvailabilityHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("case %s: wrong status code: got %v want %v", c.name, rr.Code, http.StatusOK)
+			continue
+		}
+		response := &AvailabilityResponse{}
+		if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+			t.Errorf("case %s: error decoding response body: %v", c.name, err)
+			continue
+		}
+		if !equalAvailability(response.UserName, c.userName) {
+			t.Errorf("case %s: wrong user name availability: got %+v want %+v", c.name, response.UserName, c.userName)
+		}
+		if !equalAvailability(response.Email, c.email) {
+			t.Errorf("case %s: wrong email availability: got %+v want %+v", c.name, response.Email, c.email)
+		}
+	}
+}
+
+func TestUserAvailabilityHandlerBadRequests(t *testing.T) {
+	context := NewContext("key", sessions.NewMemStore(3*time.Minute, 3*time.Minute), users.NewTestUserStore("client"))
+
+	cases := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{"No query parameters", http.MethodGet, "/v1/users/availability", http.StatusBadRequest},
+		{"Wrong method", http.MethodPost, "/v1/users/availability?userName=hawk", http.StatusMethodNotAllowed},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		rr := httptest.NewRecorder()
+		context.UserAvailabilityHandler(rr, req)
+		if rr.Code != c.status {
+			t.Errorf("case %s: wrong status code: got %v want %v", c.name, rr.Code, c.status)
+		}
+		if ctype := rr.Header().Get("Content-Type"); ctype != contentTypeProblemJSON {
+			t.Errorf("case %s: wrong content type: got %v want %v", c.name, ctype, contentTypeProblemJSON)
+		}
+	}
+}
+
+// equalAvailability compares two possibly nil Availability values
+func equalAvailability(a, b *Availability) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -10,6 +10,13 @@ type Context struct {
 	SessionIDKey string         `json:"sessionIDKey"`
 	SessionStore sessions.Store `json:"sessionStore"`
 	UserStore    users.Store    `json:"userStore"`
+	// WebSocketOrigins are the origins allowed to open WebSocket connections
+	WebSocketOrigins []string `json:"webSocketOrigins"`
+	// WebSocket configures the heartbeat and limits of WebSocket connections
+	WebSocket WebSocketOptions `json:"webSocket"`
+	// Channels decides which channels WebSocket clients may subscribe to.
+	// Subscriptions are refused when it is nil.
+	Channels ChannelAuthorizer `json:"-"`
 }
 
 // NewContext constructs a new Context struct,
@@ -21,5 +28,5 @@ func NewContext(sessionIDKey string, sessionStore sessions.Store, userStore user
 	if userStore == nil {
 		panic("nil MySQL session")
 	}
-	return &Context{sessionIDKey, sessionStore, userStore}
+	return &Context{SessionIDKey: sessionIDKey, SessionStore: sessionStore, UserStore: userStore, WebSocket: DefaultWebSocketOptions()}
 }
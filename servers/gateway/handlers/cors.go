@@ -1,31 +1,220 @@
 package handlers
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"serverside-final-project/servers/gateway/logging"
+	"strconv"
+	"strings"
+	"time"
 
-// CORSHeader is a middleware handler that adds CORS headers to a response
+	"github.com/gorilla/websocket"
+)
+
+// CORSPolicy describes the cross-origin requests browsers may make
+type CORSPolicy struct {
+	// Origins are the origins allowed to make requests: exact origins such
+	// as https://client.example.com, patterns with one wildcard in the host
+	// such as https://*.example.com, or "*" for any origin
+	Origins []string
+	// Methods are the request methods allowed
+	Methods []string
+	// Headers are the request headers allowed
+	Headers []string
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string
+	// Credentials allows requests with cookies or HTTP authentication
+	Credentials bool
+	// MaxAge is how long browsers may cache the answer to a preflight request
+	MaxAge time.Duration
+}
+
+// DefaultCORSPolicy returns the policy allowing the requests that the gateway
+// and the services behind it expect from browsers at `origins`
+func DefaultCORSPolicy(origins ...string) *CORSPolicy {
+	return &CORSPolicy{
+		Origins:        origins,
+		Methods:        []string{"GET", "PUT", "POST", "PATCH", "DELETE"},
+		Headers:        []string{"Content-Type", "Authorization", logging.RequestIDHeader},
+		ExposedHeaders: []string{"Authorization", "Retry-After", RateLimitRemainingHeader, logging.RequestIDHeader},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// Validate checks that the policy can be applied
+func (p *CORSPolicy) Validate() error {
+	if len(p.Origins) == 0 {
+		return fmt.Errorf("at least one origin is required")
+	}
+	for _, origin := range p.Origins {
+		if origin == "*" {
+			if p.Credentials {
+				return fmt.Errorf("origin * can't be allowed with credentials")
+			}
+			continue
+		}
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("origin %q can have at most one wildcard", origin)
+		}
+		parsed, err := url.Parse(strings.Replace(origin, "*", "wildcard", 1))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 || len(parsed.Path) > 0 {
+			return fmt.Errorf("origin %q must be a scheme and host such as https://client.example.com", origin)
+		}
+		if strings.Contains(origin, "*") && !strings.Contains(parsed.Hostname(), "wildcard") {
+			return fmt.Errorf("origin %q can only have a wildcard in its host", origin)
+		}
+	}
+	for _, method := range p.Methods {
+		if method != strings.ToUpper(method) || strings.ContainsAny(method, " ,") {
+			return fmt.Errorf("method %q must be an upper case method name", method)
+		}
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("max age can't be negative")
+	}
+	return nil
+}
+
+// allowsOrigin reports whether requests from `origin` are allowed
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		prefix, suffix, found := strings.Cut(allowed, "*")
+		if !found || len(origin) <= len(prefix)+len(suffix) {
+			continue
+		}
+		if strings.EqualFold(origin[:len(prefix)], prefix) && strings.EqualFold(origin[len(origin)-len(suffix):], suffix) {
+			// The wildcard only stands for subdomains, not ports or paths
+			if wildcard := origin[len(prefix) : len(origin)-len(suffix)]; !strings.ContainsAny(wildcard, ":/@") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsAnyOrigin reports whether requests from every origin are allowed
+func (p *CORSPolicy) allowsAnyOrigin() bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsMethod reports whether requests may use `method`
+func (p *CORSPolicy) allowsMethod(method string) bool {
+	for _, allowed := range p.Methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeader reports whether requests may send the header `name`
+func (p *CORSPolicy) allowsHeader(name string) bool {
+	for _, allowed := range p.Headers {
+		if strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSHeader is a middleware handler that applies CORS policies to
+// requests from other origins
 type CORSHeader struct {
 	handler http.Handler
+	routes  *http.ServeMux
+	// Policy applies to every route without an override
+	Policy *CORSPolicy
+	// Overrides are the policies of some routes, by their patterns in routes
+	Overrides map[string]*CORSPolicy
 }
 
-// NewCORSHeader constructs a new ResponseHeader middleware handler
-func NewCORSHeader(handlerToWrap http.Handler) *CORSHeader {
-	return &CORSHeader{handlerToWrap}
+// NewCORSHeader constructs a new CORSHeader middleware handler that applies
+// `policy` to requests to the routes in `routes`
+func NewCORSHeader(routes *http.ServeMux, policy *CORSPolicy, handlerToWrap http.Handler) *CORSHeader {
+	return &CORSHeader{handler: handlerToWrap, routes: routes, Policy: policy}
 }
 
-// ServeHTTP handles the request by adding CORS response headers
-// If the request header method is OPTION return StatusOK so the
-// actual client request can be sent
+// ServeHTTP handles the request by applying the CORS policy of its route.
+// Requests from origins the policy doesn't allow are rejected with 403.
+// Preflight requests for known routes are answered with 204 if the method
+// and headers they ask for are allowed, and with 403 otherwise. Requests
+// without an Origin, from the gateway's own origin, or opening WebSockets,
+// which check their origin themselves, are passed on untouched.
 func (ch *CORSHeader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, PATCH, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Set("Access-Control-Expose-Headers", "Authorization")
-	w.Header().Set("Access-Control-Max-Age", "600")
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
+	// The response depends on the origin, so caches must not share it
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 || isSameOrigin(r, origin) || websocket.IsWebSocketUpgrade(r) {
+		ch.handler.ServeHTTP(w, r)
+		return
+	}
+
+	_, route := ch.routes.Handler(r)
+	policy := ch.Policy
+	if override, found := ch.Overrides[route]; found {
+		policy = override
+	}
+	if !policy.allowsOrigin(origin) {
+		WriteProblem(w, r, http.StatusForbidden, "Requests from origin "+origin+" are not allowed")
+		return
+	}
+
+	preflight := r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0
+	if preflight && len(route) > 0 {
+		w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !policy.allowsMethod(method) {
+			WriteProblem(w, r, http.StatusForbidden, "Method "+method+" is not allowed from origin "+origin)
+			return
+		}
+		for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 && !policy.allowsHeader(name) {
+				WriteProblem(w, r, http.StatusForbidden, "Header "+name+" is not allowed from origin "+origin)
+				return
+			}
+		}
+	}
+
+	allowedOrigin := origin
+	if !policy.Credentials && policy.allowsAnyOrigin() {
+		allowedOrigin = "*"
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+	if policy.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if preflight && len(route) > 0 {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+		if len(policy.Headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+		}
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
+	if len(policy.ExposedHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+	}
 	ch.handler.ServeHTTP(w, r)
 }
+
+// isSameOrigin reports whether `origin` is the origin of the gateway itself,
+// which browsers send on some requests that aren't cross-origin
+func isSameOrigin(r *http.Request, origin string) bool {
+	scheme := "https"
+	if r.TLS == nil {
+		scheme = "http"
+	}
+	return strings.EqualFold(origin, scheme+"://"+r.Host)
+}
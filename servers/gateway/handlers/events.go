@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EventVersion is the version of the event envelope the gateway
+// understands. Events of other versions are dropped.
+const EventVersion = 1
+
+// Types of events
+const (
+	ChannelNewEvent    = "channel-new"
+	ChannelUpdateEvent = "channel-update"
+	ChannelDeleteEvent = "channel-delete"
+	MessageNewEvent    = "message-new"
+	MessageUpdateEvent = "message-update"
+	MessageDeleteEvent = "message-delete"
+)
+
+// ErrUnknownEventType is returned when validating an event of a type the
+// gateway doesn't know
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// Event is the envelope of the events that the messaging service publishes
+// to RabbitMQ and the gateway writes to WebSocket connections, such as
+//
+//	{"type": "message-new", "version": 1, "channelID": 5, "payload": {...},
+//	 "eventID": "0b7c...", "ts": "2024-05-01T12:00:00.000Z"}
+//
+// The producer, newEvent in servers/messaging/handlers/rabbitmqhelpers.js,
+// must be changed along with it.
+type Event struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	// ChannelID is the channel the event is about
+	ChannelID int64 `json:"channelID"`
+	// Payload is a ChannelPayload for channel-new and channel-update events,
+	// a MessagePayload for message-new and message-update events, and a
+	// DeletedPayload for channel-delete and message-delete events
+	Payload json.RawMessage `json:"payload"`
+	// EventID identifies the event, so that clients can ignore events that
+	// RabbitMQ redelivers
+	EventID string `json:"eventID"`
+	// TS is when the event happened
+	TS time.Time `json:"ts"`
+}
+
+// ChannelPayload is the channel of channel-new and channel-update events,
+// as the messaging service responds with it. Its timestamps are kept as the
+// service formats them.
+type ChannelPayload struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Private     bool            `json:"private"`
+	Members     json.RawMessage `json:"members,omitempty"`
+	CreatedAt   json.RawMessage `json:"createdAt"`
+	Creator     json.RawMessage `json:"creator"`
+	EditedAt    json.RawMessage `json:"editedAt,omitempty"`
+}
+
+// MessagePayload is the message of message-new and message-update events,
+// as the messaging service responds with it
+type MessagePayload struct {
+	ID        int64           `json:"id"`
+	ChannelID int64           `json:"channelID"`
+	Body      string          `json:"body"`
+	CreatedAt json.RawMessage `json:"createdAt"`
+	Creator   json.RawMessage `json:"creator"`
+	EditedAt  json.RawMessage `json:"editedAt,omitempty"`
+}
+
+// DeletedPayload identifies the channel or message of channel-delete and
+// message-delete events
+type DeletedPayload struct {
+	ID int64 `json:"id"`
+}
+
+// Validate checks that the event is of a known type and version, and that
+// its payload matches its type and channel. Events of unknown types return
+// an error wrapping ErrUnknownEventType.
+func (e *Event) Validate() error {
+	if e.Version != EventVersion {
+		return fmt.Errorf("unsupported event version %d", e.Version)
+	}
+	if len(e.EventID) == 0 {
+		return fmt.Errorf("eventID is required")
+	}
+	if e.TS.IsZero() {
+		return fmt.Errorf("ts is required")
+	}
+	if e.ChannelID <= 0 {
+		return fmt.Errorf("channelID must be positive")
+	}
+
+	switch e.Type {
+	case ChannelNewEvent, ChannelUpdateEvent:
+		channel := &ChannelPayload{}
+		if err := json.Unmarshal(e.Payload, channel); err != nil {
+			return fmt.Errorf("%s payload must be a channel: %v", e.Type, err)
+		}
+		if channel.ID != e.ChannelID {
+			return fmt.Errorf("%s payload is channel %d, not %d", e.Type, channel.ID, e.ChannelID)
+		}
+	case MessageNewEvent, MessageUpdateEvent:
+		message := &MessagePayload{}
+		if err := json.Unmarshal(e.Payload, message); err != nil {
+			return fmt.Errorf("%s payload must be a message: %v", e.Type, err)
+		}
+		if message.ID <= 0 || message.ChannelID != e.ChannelID {
+			return fmt.Errorf("%s payload must be a message with an id in channel %d", e.Type, e.ChannelID)
+		}
+	case ChannelDeleteEvent, MessageDeleteEvent:
+		deleted := &DeletedPayload{}
+		if err := json.Unmarshal(e.Payload, deleted); err != nil || deleted.ID <= 0 {
+			return fmt.Errorf("%s payload must have the id that was deleted", e.Type)
+		}
+		if e.Type == ChannelDeleteEvent && deleted.ID != e.ChannelID {
+			return fmt.Errorf("%s payload is channel %d, not %d", e.Type, deleted.ID, e.ChannelID)
+		}
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownEventType, e.Type)
+	}
+	return nil
+}
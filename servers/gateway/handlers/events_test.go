@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestEventValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		message string
+		valid   bool
+	}{
+		{"New Channel", `{"type":"channel-new","version":1,"channelID":5,"payload":{"id":5,"name":"general","private":false,"createdAt":"2024-5-1 12:0:0"},"eventID":"a","ts":"2024-05-01T12:00:00.000Z","userIDs":[]}`, true},
+		{"Updated Channel", `{"type":"channel-update","version":1,"channelID":5,"payload":{"id":5,"name":"random","editedAt":null},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, true},
+		{"Deleted Channel", `{"type":"channel-delete","version":1,"channelID":5,"payload":{"id":5},"eventID":"a","ts":"2024-05-01T12:00:00.000Z","private":true,"userIDs":[1,2]}`, true},
+		{"New Message", `{"type":"message-new","version":1,"channelID":5,"payload":{"id":9,"channelID":5,"body":"hi"},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, true},
+		{"Deleted Message", `{"type":"message-delete","version":1,"channelID":5,"payload":{"id":9,"channelID":5},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, true},
+		{"Other Version", `{"type":"message-new","version":2,"channelID":5,"payload":{"id":9,"channelID":5},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, false},
+		{"No Version", `{"type":"message-new","channelID":5,"payload":{"id":9,"channelID":5},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, false},
+		{"No Event ID", `{"type":"message-new","version":1,"channelID":5,"payload":{"id":9,"channelID":5},"ts":"2024-05-01T12:00:00.000Z"}`, false},
+		{"No Timestamp", `{"type":"message-new","version":1,"channelID":5,"payload":{"id":9,"channelID":5},"eventID":"a"}`, false},
+		{"No Channel", `{"type":"message-new","version":1,"payload":{"id":9,"channelID":5},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, false},
+		{"Message In Other Channel", `{"type":"message-new","version":1,"channelID":5,"payload":{"id":9,"channelID":6},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, false},
+		{"Other Channel", `{"type":"channel-update","version":1,"channelID":5,"payload":{"id":6},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, false},
+		{"Payload Not A Channel", `{"type":"channel-new","version":1,"channelID":5,"payload":"general","eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, false},
+		{"No Payload", `{"type":"message-delete","version":1,"channelID":5,"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`, false},
+		{"Invalid User", `{"type":"channel-delete","version":1,"channelID":5,"payload":{"id":5},"eventID":"a","ts":"2024-05-01T12:00:00.000Z","private":true,"userIDs":[0]}`, false},
+		{"Members Of Public Channel", `{"type":"channel-delete","version":1,"channelID":5,"payload":{"id":5},"eventID":"a","ts":"2024-05-01T12:00:00.000Z","private":false,"userIDs":[1]}`, false},
+	}
+	for _, c := range cases {
+		msg := &Message{}
+		if err := json.Unmarshal([]byte(c.message), msg); err != nil {
+			t.Fatalf("case %s: error decoding message: %v", c.name, err)
+		}
+		err := msg.Validate()
+		if (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got %v", c.name, c.valid, err)
+		}
+		if errors.Is(err, ErrUnknownEventType) {
+			t.Errorf("case %s: expected a known type, but got %v", c.name, err)
+		}
+	}
+
+	msg := &Message{}
+	json.Unmarshal([]byte(`{"type":"channel-archive","version":1,"channelID":5,"payload":{"id":5},"eventID":"a","ts":"2024-05-01T12:00:00.000Z"}`), msg)
+	if err := msg.Validate(); !errors.Is(err, ErrUnknownEventType) {
+		t.Errorf("expected an unknown event type, but got %v", err)
+	}
+}
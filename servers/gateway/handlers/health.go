@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckTimeout is the default upper bound on how long each
+// readiness check may take before its component is reported as down
+const DefaultHealthCheckTimeout = 2 * time.Second
+
+// Statuses reported by the health endpoints
+const (
+	healthStatusUp   = "up"
+	healthStatusDown = "down"
+)
+
+// HealthCheck reports whether a dependency of the gateway is usable,
+// returning an error describing the problem if it isn't
+type HealthCheck func(ctx context.Context) error
+
+// ComponentHealth is the result of running the HealthCheck of one component
+type ComponentHealth struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthReport is the body of a readiness response
+type HealthReport struct {
+	Status     string                      `json:"status"`
+	Components map[string]*ComponentHealth `json:"components,omitempty"`
+}
+
+// HealthChecker runs a named HealthCheck for each dependency of the gateway
+// and serves the liveness and readiness endpoints
+type HealthChecker struct {
+	// Timeout bounds each check. Zero means checks are only bounded by
+	// the request they are run for.
+	Timeout time.Duration
+	checks  map[string]HealthCheck
+}
+
+// NewHealthChecker constructs a new HealthChecker with no checks
+func NewHealthChecker(timeout time.Duration) *HealthChecker {
+	return &HealthChecker{timeout, map[string]HealthCheck{}}
+}
+
+// Add registers `check` as the check for the component `name`. Checks
+// should be added before the checker starts serving requests.
+func (checker *HealthChecker) Add(name string, check HealthCheck) {
+	checker.checks[name] = check
+}
+
+// Check runs every check concurrently and reports whether they all passed,
+// along with the result of each one
+func (checker *HealthChecker) Check(ctx context.Context) (bool, map[string]*ComponentHealth) {
+	results := make(map[string]*ComponentHealth, len(checker.checks))
+	var mx sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checker.checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+			result := checker.run(ctx, check)
+			mx.Lock()
+			results[name] = result
+			mx.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	healthy := true
+	for _, result := range results {
+		if result.Status != healthStatusUp {
+			healthy = false
+		}
+	}
+	return healthy, results
+}
+
+// run runs a single check, bounded by the checker's timeout. A check that
+// doesn't return in time is reported as down without waiting for it.
+func (checker *HealthChecker) run(ctx context.Context, check HealthCheck) *ComponentHealth {
+	if checker.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, checker.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		result <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out: %v", ctx.Err())
+	}
+
+	health := &ComponentHealth{Status: healthStatusUp, Duration: time.Since(start).String()}
+	if err != nil {
+		health.Status = healthStatusDown
+		health.Error = err.Error()
+	}
+	return health
+}
+
+// LivenessHandler handles requests for the /healthz resource. It only
+// reports that the process is running and able to serve requests, so that
+// an orchestrator doesn't restart the gateway when a dependency is down.
+func (checker *HealthChecker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		WriteMethodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	WriteJSON(w, http.StatusOK, &HealthReport{Status: healthStatusUp})
+}
+
+// ReadinessHandler handles requests for the /readyz resource. It runs every
+// check and responds with the status of each component, using status code
+// 503 if any of them is down so that traffic is sent elsewhere.
+func (checker *HealthChecker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		WriteMethodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	healthy, components := checker.Check(r.Context())
+	report := &HealthReport{Status: healthStatusUp, Components: components}
+	status := http.StatusOK
+	if !healthy {
+		report.Status = healthStatusDown
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, status, report)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLivenessHandler(t *testing.T) {
+	checker := NewHealthChecker(time.Second)
+	checker.Add("broken", func(ctx context.Context) error {
+		return errors.New("broken")
+	})
+
+	resp := httptest.NewRecorder()
+	checker.LivenessHandler(resp, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf("expected liveness to ignore dependencies and return %d, but got %d", http.StatusOK, resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	checker.LivenessHandler(resp, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d for POST, but got %d", http.StatusMethodNotAllowed, resp.Code)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		checks         map[string]HealthCheck
+		expectedStatus int
+		expectedDown   []string
+	}{
+		{
+			"All Up",
+			map[string]HealthCheck{
+				"redis": func(ctx context.Context) error { return nil },
+				"mysql": func(ctx context.Context) error { return nil },
+			},
+			http.StatusOK,
+			nil,
+		},
+		{
+			"One Down",
+			map[string]HealthCheck{
+				"redis": func(ctx context.Context) error { return nil },
+				"mysql": func(ctx context.Context) error { return errors.New("connection refused") },
+			},
+			http.StatusServiceUnavailable,
+			[]string{"mysql"},
+		},
+		{
+			"Timed Out",
+			map[string]HealthCheck{
+				"redis": func(ctx context.Context) error { return nil },
+				"rabbitmq": func(ctx context.Context) error {
+					// Ignores its context, as a stuck client library would
+					time.Sleep(time.Second)
+					return nil
+				},
+			},
+			http.StatusServiceUnavailable,
+			[]string{"rabbitmq"},
+		},
+	}
+
+	for _, c := range cases {
+		checker := NewHealthChecker(50 * time.Millisecond)
+		for name, check := range c.checks {
+			checker.Add(name, check)
+		}
+
+		start := time.Now()
+		resp := httptest.NewRecorder()
+		checker.ReadinessHandler(resp, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("case %s: readiness took %v, longer than the check timeout", c.name, elapsed)
+		}
+		if resp.Code != c.expectedStatus {
+			t.Errorf("case %s: expected status %d, but got %d", c.name, c.expectedStatus, resp.Code)
+		}
+		if contentType := resp.Header().Get(contentTypeHeader); contentType != contentTypeJSON {
+			t.Errorf("case %s: expected content type %s, but got %s", c.name, contentTypeJSON, contentType)
+		}
+
+		report := &HealthReport{}
+		if err := json.Unmarshal(resp.Body.Bytes(), report); err != nil {
+			t.Fatalf("case %s: error decoding report: %v", c.name, err)
+		}
+		if len(report.Components) != len(c.checks) {
+			t.Errorf("case %s: expected %d components, but got %+v", c.name, len(c.checks), report.Components)
+		}
+		down := map[string]bool{}
+		for _, name := range c.expectedDown {
+			down[name] = true
+		}
+		for name, component := range report.Components {
+			if (component.Status == healthStatusDown) != down[name] {
+				t.Errorf("case %s: unexpected status for %s: %+v", c.name, name, component)
+			}
+			if down[name] && len(component.Error) == 0 {
+				t.Errorf("case %s: expected an error for %s", c.name, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"serverside-final-project/servers/gateway/upstream"
+)
+
+// NewProxy constructs a ReverseProxy that sends requests to the backends of
+// `pool`, responding with a problem when they can't be reached
+func NewProxy(pool *upstream.Pool) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		// The pool addresses each request to the backend it chooses
+		Director:     func(r *http.Request) {},
+		Transport:    pool,
+		ErrorHandler: ProxyErrorHandler(pool.Name),
+	}
+}
+
+// ProxyErrorHandler returns an ErrorHandler for the ReverseProxy of the
+// upstream named `name`. It responds with a problem whose status code tells
+// the client why the request failed: 503 if no backend can take requests,
+// 504 if the backend took too long, and 502 for any other failure.
+func ProxyErrorHandler(name string) func(w http.ResponseWriter, r *http.Request, err error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		status := http.StatusBadGateway
+		detail := fmt.Sprintf("The %s service could not be reached", name)
+		switch {
+		case errors.Is(err, upstream.ErrNoHealthyBackends), errors.Is(err, upstream.ErrCircuitOpen):
+			status = http.StatusServiceUnavailable
+			detail = fmt.Sprintf("The %s service is unavailable", name)
+		case isTimeout(err):
+			status = http.StatusGatewayTimeout
+			detail = fmt.Sprintf("The %s service did not respond in time", name)
+		}
+
+		slog.ErrorContext(r.Context(), "Error proxying request", "method", r.Method, "path", r.URL.Path, "upstream", name, "error", err)
+		WriteProblem(w, r, status, detail)
+	}
+}
+
+// UpstreamsHandler serves the state of the backends of every upstream pool,
+// including their circuit breakers, keyed by upstream name
+type UpstreamsHandler struct {
+	Pools []*upstream.Pool
+}
+
+// ServeHTTP handles requests for the upstreams resource
+func (h *UpstreamsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	statuses := make(map[string][]*upstream.BackendStatus, len(h.Pools))
+	for _, pool := range h.Pools {
+		statuses[pool.Name] = pool.Status()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, statuses)
+}
+
+// isTimeout reports whether `err` was caused by a deadline or network timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
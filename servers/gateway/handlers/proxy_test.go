@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"serverside-final-project/servers/gateway/upstream"
+	"testing"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestProxyErrorHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		err            error
+		expectedStatus int
+	}{
+		{"No Healthy Backends", fmt.Errorf("upstream meetup: %w", upstream.ErrNoHealthyBackends), http.StatusServiceUnavailable},
+		{"Circuit Open", fmt.Errorf("upstream meetup: %w", upstream.ErrCircuitOpen), http.StatusServiceUnavailable},
+		{"Deadline Exceeded", context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{"Network Timeout", &url.Error{Op: "Get", URL: "http://meetupserver", Err: timeoutError{}}, http.StatusGatewayTimeout},
+		{"Connection Refused", errors.New("connection refused"), http.StatusBadGateway},
+	}
+
+	handler := ProxyErrorHandler("meetup")
+	for _, c := range cases {
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest(http.MethodGet, "/v1/events", nil), c.err)
+		if resp.Code != c.expectedStatus {
+			t.Errorf("case %s: expected status %d, but got %d", c.name, c.expectedStatus, resp.Code)
+		}
+		if contentType := resp.Header().Get(contentTypeHeader); contentType != contentTypeProblemJSON {
+			t.Errorf("case %s: expected content type %s, but got %s", c.name, contentTypeProblemJSON, contentType)
+		}
+		problem := &Problem{}
+		if err := json.Unmarshal(resp.Body.Bytes(), problem); err != nil || problem.Status != c.expectedStatus || problem.Instance != "/v1/events" {
+			t.Errorf("case %s: unexpected problem %+v (%v)", c.name, problem, err)
+		}
+	}
+}
+
+func TestUpstreamsHandler(t *testing.T) {
+	targets := []*url.URL{{Scheme: "http", Host: "meetup1"}, {Scheme: "http", Host: "meetup2"}}
+	pool, err := upstream.NewPool("meetup", targets, upstream.Options{})
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	handler := &UpstreamsHandler{Pools: []*upstream.Pool{pool}}
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/upstreams", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, but got %d", http.StatusOK, resp.Code)
+	}
+	statuses := map[string][]map[string]interface{}{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	backends := statuses["meetup"]
+	if len(backends) != 2 || backends[0]["url"] != "http://meetup1" || backends[0]["breaker"] != "closed" || backends[0]["healthy"] != true {
+		t.Errorf("unexpected upstream status %v", statuses)
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/upstreams", nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, but got %d", http.StatusMethodNotAllowed, resp.Code)
+	}
+}
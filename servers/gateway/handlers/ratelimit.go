@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"serverside-final-project/servers/gateway/ratelimit"
+	"strconv"
+)
+
+// RateLimitRemainingHeader tells clients how many more requests the rate
+// limit that applies to their request allows right now
+const RateLimitRemainingHeader = "X-RateLimit-Remaining"
+
+// RateLimit returns middleware that limits requests to `next` with the first
+// of `rules` that applies to each request. Requests that exceed their limit
+// are rejected with 429 and a Retry-After header. Requests are let through
+// if the limiter fails, since rejecting every request would be worse than
+// not limiting them for a while.
+func (hc *Context) RateLimit(limiter ratelimit.Limiter, rules []*ratelimit.Rule, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := ratelimit.Match(rules, r.Method, r.URL.Path)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, r := hc.rateLimitKey(r, rule)
+		result, err := limiter.Allow(r.Context(), rule, key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error limiting rate", "method", r.Method, "path", r.URL.Path, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			WriteProblem(w, r, http.StatusTooManyRequests, "Too many requests, please try again in "+strconv.Itoa(retryAfter)+" seconds")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey returns the key of the bucket that `r` takes a token from:
+// the ID of the signed in user if `rule` limits users, or else the client's
+// IP address. The session state looked up for rules that limit users is kept
+// on the returned request, so that authenticating it doesn't ask the session
+// store again.
+func (hc *Context) rateLimitKey(r *http.Request, rule *ratelimit.Rule) (string, *http.Request) {
+	if rule.By == ratelimit.ByUser {
+		sessionState, err := hc.getSessionState(r)
+		r = withSessionState(r, sessionState, err)
+		if err == nil && sessionState.User != nil {
+			return "user:" + strconv.FormatInt(sessionState.User.ID, 10), r
+		}
+		if err != nil && !sessionRejected(err) {
+			slog.WarnContext(r.Context(), "Error getting session state, limiting the request by IP address instead", "method", r.Method, "path", r.URL.Path, "error", err)
+		}
+	}
+	return "ip:" + remoteIP(r), r
+}
+
+// remoteIP returns the IP address of the peer that sent the request. Unlike
+// getClientIP it ignores X-Forwarded-For, which clients can set to anything
+// and so could use to escape their rate limits.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
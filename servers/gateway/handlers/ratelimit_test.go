@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"serverside-final-project/servers/gateway/identity"
+	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/ratelimit"
+	"serverside-final-project/servers/gateway/sessions"
+	"testing"
+	"time"
+)
+
+// brokenLimiter is a Limiter that always fails
+type brokenLimiter struct{}
+
+func (brokenLimiter) Allow(ctx context.Context, rule *ratelimit.Rule, key string) (*ratelimit.Result, error) {
+	return nil, errors.New("connection refused")
+}
+
+// countingSessionStore is a sessions.Store that counts the session states
+// read from it
+type countingSessionStore struct {
+	sessions.Store
+	gets int
+}
+
+func (cs *countingSessionStore) Get(ctx context.Context, sid sessions.SessionID, sessionState interface{}) error {
+	cs.gets++
+	return cs.Store.Get(ctx, sid, sessionState)
+}
+
+func TestRateLimit(t *testing.T) {
+	store := sessions.NewMemStore(time.Hour, time.Hour)
+	hctx := NewContext(testSessionKey, store, users.NewTestUserStore("client"))
+	sid, err := sessions.NewSessionID(testSessionKey)
+	if err != nil {
+		t.Fatalf("error generating session ID: %v", err)
+	}
+	user := &users.User{ID: 1, UserName: "tester"}
+	if err := store.Save(context.Background(), sid, NewSessionState(time.Now(), user)); err != nil {
+		t.Fatalf("error saving session: %v", err)
+	}
+
+	rules := []*ratelimit.Rule{
+		{Route: "/v1/events", Methods: []string{"POST"}, Requests: 1, Period: time.Hour, Burst: 2, By: ratelimit.ByUser},
+		{Route: "/v1/sessions", Methods: []string{"POST"}, Requests: 1, Period: time.Minute, By: ratelimit.ByIP},
+	}
+	handler := hctx.RateLimit(ratelimit.NewMemLimiter(), rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(method string, target string, remoteAddr string, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = remoteAddr
+		if len(authorization) > 0 {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	bearer := "Bearer " + string(sid)
+
+	cases := []struct {
+		name              string
+		method            string
+		target            string
+		remoteAddr        string
+		authorization     string
+		expectedStatus    int
+		expectedRemaining string
+	}{
+		{"User's First Request", "POST", "/v1/events", "10.0.0.1:1000", bearer, http.StatusNoContent, "1"},
+		{"Same User From Another Address", "POST", "/v1/events", "10.0.0.2:1000", bearer, http.StatusNoContent, "0"},
+		{"User Over Limit", "POST", "/v1/events", "10.0.0.3:1000", bearer, http.StatusTooManyRequests, "0"},
+		{"Anonymous Client Limited By IP", "POST", "/v1/events", "10.0.0.1:1000", "", http.StatusNoContent, "1"},
+		{"Unlimited Method", "GET", "/v1/events", "10.0.0.3:1000", bearer, http.StatusNoContent, ""},
+		{"Unlimited Route", "POST", "/v1/users", "10.0.0.3:1000", bearer, http.StatusNoContent, ""},
+		{"IP's First Sign In", "POST", "/v1/sessions", "10.0.0.4:1000", "", http.StatusNoContent, "0"},
+		{"IP Over Limit From Another Port", "POST", "/v1/sessions", "10.0.0.4:2000", bearer, http.StatusTooManyRequests, "0"},
+	}
+
+	for _, c := range cases {
+		resp := request(c.method, c.target, c.remoteAddr, c.authorization)
+		if resp.Code != c.expectedStatus {
+			t.Errorf("case %s: expected status %d, but got %d", c.name, c.expectedStatus, resp.Code)
+		}
+		if remaining := resp.Header().Get(RateLimitRemainingHeader); remaining != c.expectedRemaining {
+			t.Errorf("case %s: expected %s %q, but got %q", c.name, RateLimitRemainingHeader, c.expectedRemaining, remaining)
+		}
+		if resp.Code != http.StatusTooManyRequests {
+			continue
+		}
+		if resp.Header().Get(contentTypeHeader) != contentTypeProblemJSON {
+			t.Errorf("case %s: expected a problem response, but got %s", c.name, resp.Header().Get(contentTypeHeader))
+		}
+		if len(resp.Header().Get("Retry-After")) == 0 {
+			t.Errorf("case %s: expected a Retry-After header", c.name)
+		}
+	}
+
+	// X-Forwarded-For is chosen by the client, so it can't reset the limit
+	req := httptest.NewRequest("POST", "/v1/sessions", nil)
+	req.RemoteAddr = "10.0.0.4:3000"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusTooManyRequests || resp.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected X-Forwarded-For to be ignored with a 60s Retry-After, but got %d and %q", resp.Code, resp.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	hctx := NewContext(testSessionKey, sessions.NewMemStore(time.Hour, time.Hour), users.NewTestUserStore("client"))
+	rules := []*ratelimit.Rule{{Route: "/", Requests: 1, Period: time.Hour, By: ratelimit.ByIP}}
+	handler := hctx.RateLimit(brokenLimiter{}, rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", "/v1/events", nil))
+	if resp.Code != http.StatusNoContent {
+		t.Errorf("expected the request to be let through when the limiter fails, but got %d", resp.Code)
+	}
+}
+
+// Requests limited by user are authenticated with the session state that
+// the limiter read, rather than reading it from the store again
+func TestRateLimitReadsSessionOnce(t *testing.T) {
+	memStore := sessions.NewMemStore(time.Hour, time.Hour)
+	sid, err := sessions.NewSessionID(testSessionKey)
+	if err != nil {
+		t.Fatalf("error generating session ID: %v", err)
+	}
+	if err := memStore.Save(context.Background(), sid, NewSessionState(time.Now(), &users.User{ID: 1, UserName: "tester"})); err != nil {
+		t.Fatalf("error saving session: %v", err)
+	}
+
+	cases := []struct {
+		name           string
+		store          sessions.Store
+		expectedStatus int
+	}{
+		{"Valid Session", memStore, http.StatusNoContent},
+		{"Session Store Unavailable", &unavailableSessionStore{memStore}, http.StatusServiceUnavailable},
+	}
+
+	rules := []*ratelimit.Rule{{Route: "/v1/events", Requests: 10, Period: time.Minute, By: ratelimit.ByUser}}
+	for _, c := range cases {
+		store := &countingSessionStore{Store: c.store}
+		hctx := NewContext(testSessionKey, store, users.NewTestUserStore("client"))
+		next := hctx.Authenticate(identity.NewSigner([]byte(testIdentityKey)), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		handler := hctx.RateLimit(ratelimit.NewMemLimiter(), rules, next)
+
+		req := httptest.NewRequest("POST", "/v1/events", nil)
+		req.Header.Set("Authorization", "Bearer "+string(sid))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		if resp.Code != c.expectedStatus {
+			t.Errorf("case %s: expected status %d, but got %d", c.name, c.expectedStatus, resp.Code)
+		}
+		if store.gets != 1 {
+			t.Errorf("case %s: expected the session state to be read once, but it was read %d times", c.name, store.gets)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Content types written by the gateway handlers
+const (
+	contentTypeHeader      = "Content-Type"
+	contentTypeJSON        = "application/json"
+	contentTypeProblemJSON = "application/problem+json"
+)
+
+// problemTypeDefault is the problem type used when no more specific type
+// applies, as described in RFC 7807 section 4.2
+const problemTypeDefault = "about:blank"
+
+// Problem represents an RFC 7807 problem details object that is returned
+// to clients whenever a request cannot be fulfilled
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// NewProblem constructs a new Problem for the given status code and detail
+// message, using the standard status text as the title
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypeDefault,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// WriteJSON encodes `value` as JSON and writes it to the response with the
+// given status code. The Content-Type header is always set before the status
+// code is written so that clients receive it.
+func WriteJSON(w http.ResponseWriter, status int, value interface{}) {
+	buffer, err := json.Marshal(value)
+	if err != nil {
+		slog.Error("Error encoding JSON response", "error", err)
+		WriteProblem(w, nil, http.StatusInternalServerError, "Error encoding response")
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, contentTypeJSON)
+	w.WriteHeader(status)
+	w.Write(buffer)
+}
+
+// WriteProblem writes an RFC 7807 problem+json response with the given status
+// code and detail message. If `r` is not nil, the request path is reported as
+// the problem instance.
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	problem := NewProblem(status, detail)
+	if r != nil && r.URL != nil {
+		problem.Instance = r.URL.Path
+	}
+	writeProblem(w, problem)
+}
+
+// WriteMethodNotAllowed writes a 405 problem response and sets the Allow
+// header to the methods supported by the resource
+func WriteMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	WriteProblem(w, r, http.StatusMethodNotAllowed, "Method "+r.Method+" is not allowed on this resource")
+}
+
+// writeProblem encodes the given Problem to the response
+func writeProblem(w http.ResponseWriter, problem *Problem) {
+	buffer, err := json.Marshal(problem)
+	if err != nil {
+		// Problem only contains strings and ints so this should never happen
+		slog.Error("Error encoding problem response", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, contentTypeProblemJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	w.Write(buffer)
+}
+
+// isJSONContentType reports whether the request body is declared as JSON
+func isJSONContentType(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get(contentTypeHeader), contentTypeJSON)
+}
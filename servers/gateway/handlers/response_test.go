@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/sessions"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteJSON(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteJSON(rr, http.StatusCreated, &users.User{ID: 7, UserName: "swu"})
+
+	res := rr.Result()
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("wrong status code: got %v want %v", res.StatusCode, http.StatusCreated)
+	}
+	// Check the header sent with the status line, not the live header map
+	if ctype := res.Header.Get("Content-Type"); ctype != contentTypeJSON {
+		t.Errorf("wrong content type: got %v want %v", ctype, contentTypeJSON)
+	}
+	user := &users.User{}
+	if err := json.Unmarshal(rr.Body.Bytes(), user); err != nil {
+		t.Fatalf("error decoding response body: %v", err)
+	}
+	if user.ID != 7 || user.UserName != "swu" {
+		t.Errorf("wrong response body: got %+v", user)
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+	WriteProblem(rr, req, http.StatusNotFound, "user not found")
+
+	res := rr.Result()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong status code: got %v want %v", res.StatusCode, http.StatusNotFound)
+	}
+	if ctype := res.Header.Get("Content-Type"); ctype != contentTypeProblemJSON {
+		t.Errorf("wrong content type: got %v want %v", ctype, contentTypeProblemJSON)
+	}
+	expected := Problem{
+		Type:     problemTypeDefault,
+		Title:    "Not Found",
+		Status:   http.StatusNotFound,
+		Detail:   "user not found",
+		Instance: "/v1/users/me",
+	}
+	problem := Problem{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("error decoding response body: %v", err)
+	}
+	if problem != expected {
+		t.Errorf("wrong problem body: got %+v want %+v", problem, expected)
+	}
+}
+
+// TestHandlerProblemResponses runs through every error path of the gateway
+// handlers and checks that each one responds with a problem+json body whose
+// status matches the response status code
+func TestHandlerProblemResponses(t *testing.T) {
+	const key = "key"
+	sessionStore := sessions.NewMemStore(3*time.Minute, 3*time.Minute)
+
+	// Begin a session directly rather than through UsersHandler so that
+	// authenticated cases don't pay for a bcrypt hash
+	signedIn := httptest.NewRecorder()
+	state := NewSessionState(time.Now(), &users.User{ID: 1, UserName: "swu"})
+	if _, err := sessions.BeginSession(context.Background(), key, sessionStore, state, signedIn); err != nil {
+		t.Fatalf("error beginning session: %v", err)
+	}
+	auth := signedIn.Header().Get("Authorization")
+
+	context := NewContext(key, sessionStore, users.NewTestUserStore("client"))
+
+	cases := []struct {
+		name        string
+		handler     http.HandlerFunc
+		method      string
+		path        string
+		contentType string
+		body        string
+		auth        string
+		status      int
+		allow       string
+	}{
+		{"Users wrong method", context.UsersHandler, http.MethodGet, "/v1/users", "", "", "", http.StatusMethodNotAllowed, "POST"},
+		{"Users wrong content type", context.UsersHandler, http.MethodPost, "/v1/users", "text/plain", "", "", http.StatusUnsupportedMediaType, ""},
+		{"Users malformed JSON", context.UsersHandler, http.MethodPost, "/v1/users", contentTypeJSON, "hello", "", http.StatusBadRequest, ""},
+		{"Users invalid new user", context.UsersHandler, http.MethodPost, "/v1/users", contentTypeJSON, `{"email":"s"}`, "", http.StatusBadRequest, ""},
+		{"SpecificUser not signed in", context.SpecificUserHandler, http.MethodGet, "/v1/users/me", "", "", "", http.StatusUnauthorized, ""},
+		{"SpecificUser wrong method", context.SpecificUserHandler, http.MethodPost, "/v1/users/1", "", "", auth, http.StatusMethodNotAllowed, "GET, PATCH"},
+		{"SpecificUser non-numeric ID", context.SpecificUserHandler, http.MethodGet, "/v1/users/abc", "", "", auth, http.StatusBadRequest, ""},
+		{"SpecificUser unknown ID", context.SpecificUserHandler, http.MethodGet, "/v1/users/2", "", "", auth, http.StatusNotFound, ""},
+		{"SpecificUser patch other user", context.SpecificUserHandler, http.MethodPatch, "/v1/users/2", contentTypeJSON, "{}", auth, http.StatusForbidden, ""},
+		{"SpecificUser patch wrong content type", context.SpecificUserHandler, http.MethodPatch, "/v1/users/me", "text/plain", "", auth, http.StatusUnsupportedMediaType, ""},
+		{"SpecificUser patch malformed JSON", context.SpecificUserHandler, http.MethodPatch, "/v1/users/me", contentTypeJSON, "hello", auth, http.StatusBadRequest, ""},
+		{"SpecificUser patch empty update", context.SpecificUserHandler, http.MethodPatch, "/v1/users/me", contentTypeJSON, "{}", auth, http.StatusBadRequest, ""},
+		{"Sessions wrong method", context.SessionsHandler, http.MethodGet, "/v1/sessions", "", "", "", http.StatusMethodNotAllowed, "POST"},
+		{"Sessions wrong content type", context.SessionsHandler, http.MethodPost, "/v1/sessions", "text/plain", "", "", http.StatusUnsupportedMediaType, ""},
+		{"Sessions malformed JSON", context.SessionsHandler, http.MethodPost, "/v1/sessions", contentTypeJSON, "hello", "", http.StatusBadRequest, ""},
+		{"SpecificSession wrong method", context.SpecificSessionHandler, http.MethodPost, "/v1/sessions/mine", "", "", "", http.StatusMethodNotAllowed, "DELETE"},
+		{"SpecificSession not mine", context.SpecificSessionHandler, http.MethodDelete, "/v1/sessions/notmine", "", "", "", http.StatusForbidden, ""},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		if len(c.contentType) > 0 {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		if len(c.auth) > 0 {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rr := httptest.NewRecorder()
+		c.handler.ServeHTTP(rr, req)
+
+		res := rr.Result()
+		if res.StatusCode != c.status {
+			t.Errorf("case %s: wrong status code: got %v want %v", c.name, res.StatusCode, c.status)
+		}
+		if ctype := res.Header.Get("Content-Type"); ctype != contentTypeProblemJSON {
+			t.Errorf("case %s: wrong content type: got %v want %v", c.name, ctype, contentTypeProblemJSON)
+		}
+		if allow := res.Header.Get("Allow"); allow != c.allow {
+			t.Errorf("case %s: wrong Allow header: got %v want %v", c.name, allow, c.allow)
+		}
+		problem := &Problem{}
+		if err := json.Unmarshal(rr.Body.Bytes(), problem); err != nil {
+			t.Errorf("case %s: response body is not a problem: %v", c.name, err)
+			continue
+		}
+		if problem.Status != c.status || problem.Title != http.StatusText(c.status) {
+			t.Errorf("case %s: wrong problem status or title: got %d %q", c.name, problem.Status, problem.Title)
+		}
+		if problem.Instance != c.path {
+			t.Errorf("case %s: wrong problem instance: got %v want %v", c.name, problem.Instance, c.path)
+		}
+	}
+}
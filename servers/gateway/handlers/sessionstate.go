@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"net/http"
 	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/sessions"
 	"time"
 )
 
@@ -15,3 +18,33 @@ type SessionState struct {
 func NewSessionState(time time.Time, user *users.User) *SessionState {
 	return &SessionState{time, user}
 }
+
+// sessionLookupKey is the key the result of getting the session state of a
+// request is kept under in its context
+type sessionLookupKey struct{}
+
+// sessionLookup is the result of getting the session state of a request
+type sessionLookup struct {
+	state *SessionState
+	err   error
+}
+
+// getSessionState returns the session state of `r`, reusing the result of
+// an earlier lookup kept on the request by withSessionState so that the
+// session store is only asked once per request
+func (hc *Context) getSessionState(r *http.Request) (*SessionState, error) {
+	if lookup, found := r.Context().Value(sessionLookupKey{}).(*sessionLookup); found {
+		return lookup.state, lookup.err
+	}
+	sessionState := &SessionState{}
+	if _, err := sessions.GetState(r, hc.SessionIDKey, hc.SessionStore, sessionState); err != nil {
+		return nil, err
+	}
+	return sessionState, nil
+}
+
+// withSessionState returns a copy of `r` that keeps the result of getting
+// its session state for the handlers that run after it
+func withSessionState(r *http.Request, sessionState *SessionState, err error) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionLookupKey{}, &sessionLookup{sessionState, err}))
+}
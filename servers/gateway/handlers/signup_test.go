@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/sessions"
+	"testing"
+	"time"
+)
+
+// fakeUserStore is a users.Store that can be made to fail on demand. Users
+// are only recorded as committed when the whole signup transaction succeeds.
+type fakeUserStore struct {
+	*users.TestUserStore
+	insertErr error
+	logErr    error
+	committed []*users.User
+}
+
+func newFakeUserStore(insertErr error, logErr error) *fakeUserStore {
+	return &fakeUserStore{TestUserStore: users.NewTestUserStore("fake"), insertErr: insertErr, logErr: logErr}
+}
+
+func (fs *fakeUserStore) Insert(ctx context.Context, user *users.User) (*users.User, error) {
+	if fs.insertErr != nil {
+		return nil, fs.insertErr
+	}
+	user.ID = int64(len(fs.committed) + 1)
+	fs.committed = append(fs.committed, user)
+	return user, nil
+}
+
+func (fs *fakeUserStore) InsertAndLogUser(ctx context.Context, user *users.User, time time.Time, clientIP string) (*users.User, error) {
+	if fs.insertErr != nil {
+		return nil, fs.insertErr
+	}
+	// A failed sign-in log rolls back the insert
+	if fs.logErr != nil {
+		return nil, fs.logErr
+	}
+	return fs.Insert(ctx, user)
+}
+
+// failingSessionStore is a sessions.Store that can never save a session
+type failingSessionStore struct {
+	*sessions.MemStore
+}
+
+func (fs *failingSessionStore) Save(ctx context.Context, sid sessions.SessionID, sessionState interface{}) error {
+	return errors.New("session store unavailable")
+}
+
+func TestUsersHandlerSignupFailures(t *testing.T) {
+	dbErr := errors.New("connection refused")
+
+	cases := []struct {
+		name          string
+		userStore     *fakeUserStore
+		sessionStore  sessions.Store
+		status        int
+		expectSession bool
+		committed     int
+	}{
+		{
+			"Successful signup",
+			newFakeUserStore(nil, nil),
+			sessions.NewMemStore(time.Minute, time.Minute),
+			http.StatusCreated,
+			true,
+			1,
+		},
+		{
+			"Email already in use",
+			newFakeUserStore(users.ErrEmailTaken, nil),
+			sessions.NewMemStore(time.Minute, time.Minute),
+			http.StatusConflict,
+			false,
+			0,
+		},
+		{
+			"User name already taken",
+			newFakeUserStore(users.ErrUserNameTaken, nil),
+			sessions.NewMemStore(time.Minute, time.Minute),
+			http.StatusConflict,
+			false,
+			0,
+		},
+		{
+			"Database error on insert",
+			newFakeUserStore(dbErr, nil),
+			sessions.NewMemStore(time.Minute, time.Minute),
+			http.StatusInternalServerError,
+			false,
+			0,
+		},
+		{
+			"Database error on sign-in log rolls back insert",
+			newFakeUserStore(nil, dbErr),
+			sessions.NewMemStore(time.Minute, time.Minute),
+			http.StatusInternalServerError,
+			false,
+			0,
+		},
+		{
+			"Session store error after commit",
+			newFakeUserStore(nil, nil),
+			&failingSessionStore{sessions.NewMemStore(time.Minute, time.Minute)},
+			http.StatusInternalServerError,
+			false,
+			1,
+		},
+	}
+
+	newUser := &users.NewUser{Email: "hawk@gmail.com", Password: "123456", PasswordConf: "123456", UserName: "hawk", FirstName: "Hawk", LastName: "Ticehurst"}
+	buffer, _ := json.Marshal(newUser)
+
+	for _, c := range cases {
+		context := NewContext("key", c.sessionStore, c.userStore)
+		req := httptest.NewRequest(http.MethodPost, "/v1/users", bytes.NewReader(buffer))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		context.UsersHandler(rr, req)
+
+		if rr.Code != c.status {
+			t.Errorf("case %s: wrong status code: got %v want %v", c.name, rr.Code, c.status)
+		}
+		if hasSession := len(rr.Header().Get("Authorization")) > 0; hasSession != c.expectSession {
+			t.Errorf("case %s: session started: got %v want %v", c.name, hasSession, c.expectSession)
+		}
+		if len(c.userStore.committed) != c.committed {
+			t.Errorf("case %s: wrong number of committed users: got %v want %v", c.name, len(c.userStore.committed), c.committed)
+		}
+		if c.status != http.StatusCreated {
+			if ctype := rr.Header().Get("Content-Type"); ctype != contentTypeProblemJSON {
+				t.Errorf("case %s: wrong content type: got %v want %v", c.name, ctype, contentTypeProblemJSON)
+			}
+			continue
+		}
+
+		bodyUser := &users.User{}
+		if err := json.Unmarshal(rr.Body.Bytes(), bodyUser); err != nil {
+			t.Errorf("case %s: error decoding response body: %v", c.name, err)
+		} else if bodyUser.ID != 1 || bodyUser.UserName != newUser.UserName {
+			t.Errorf("case %s: wrong user in response body: got %+v", c.name, bodyUser)
+		}
+	}
+}
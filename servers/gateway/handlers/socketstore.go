@@ -1,42 +1,387 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"serverside-final-project/servers/gateway/metrics"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// SocketStore represents a map of userIDs to WebSocket connections that is
-// safe for concurrent use
+const (
+	// closeWriteWait is how long closing a connection waits to write its
+	// close frame
+	closeWriteWait = time.Second
+	// sendQueueSize is how many messages may wait to be written to a
+	// connection before its client is considered too slow to keep up
+	sendQueueSize = 64
+	// maxSubscriptions is how many channels a connection may subscribe to
+	maxSubscriptions = 256
+)
+
+// Reasons for reaping connections, as counted by metrics.WebSocketReaped
+const (
+	reapPongTimeout     = "pong_timeout"
+	reapMessageTooLarge = "message_too_large"
+	reapWriteFailed     = "write_failed"
+	reapSlowConsumer    = "slow_consumer"
+)
+
+// WebSocketOptions configures the heartbeat and limits of WebSocket
+// connections
+type WebSocketOptions struct {
+	// PingInterval is how often connections are pinged
+	PingInterval time.Duration
+	// PongWait is how long a connection may go without answering a ping
+	// before it's considered dead and closed. It must be longer than
+	// PingInterval.
+	PongWait time.Duration
+	// WriteWait is how long a message may take to be written
+	WriteWait time.Duration
+	// MaxMessageSize is the largest message in bytes that clients may send.
+	// Connections sending larger messages are closed.
+	MaxMessageSize int64
+}
+
+// DefaultWebSocketOptions returns the options used unless others are
+// configured
+func DefaultWebSocketOptions() WebSocketOptions {
+	return WebSocketOptions{
+		PingInterval:   30 * time.Second,
+		PongWait:       60 * time.Second,
+		WriteWait:      10 * time.Second,
+		MaxMessageSize: 4096,
+	}
+}
+
+var (
+	// ErrSlowConsumer is returned when a message is sent to a connection
+	// whose queue is full. The connection is closed, so its client should
+	// reconnect and catch up.
+	ErrSlowConsumer = errors.New("WebSocket connection is too slow to keep up with its messages")
+	// ErrConnectionClosed is returned when a message is sent to a connection
+	// that has been closed
+	ErrConnectionClosed = errors.New("WebSocket connection is closed")
+	// ErrTooManySubscriptions is returned when a connection subscribes to
+	// more than maxSubscriptions channels
+	ErrTooManySubscriptions = errors.New("WebSocket connection is subscribed to too many channels")
+)
+
+// SocketConn is one WebSocket connection of a user, who may have several open
+// at once from different tabs or devices. Messages sent to the connection
+// are queued and written by a single goroutine, since a WebSocket
+// connection supports only one concurrent writer. The connection is pinged
+// every PingInterval of its options and closed if its client stops
+// answering.
+type SocketConn struct {
+	conn    *websocket.Conn
+	options WebSocketOptions
+	// ID identifies the connection among every connection in its store
+	ID uint64
+	// UserID is the ID of the user who opened the connection
+	UserID int64
+
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	// channels are the IDs of the channels the connection is subscribed to,
+	// guarded by the lock of its store
+	channels map[int64]bool
+}
+
+// newSocketConn constructs a SocketConn whose messages aren't written and
+// that isn't pinged until its writeLoop is started
+func newSocketConn(id uint64, userID int64, conn *websocket.Conn, options WebSocketOptions) *SocketConn {
+	conn.SetReadLimit(options.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(options.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(options.PongWait))
+	})
+	return &SocketConn{
+		conn:     conn,
+		options:  options,
+		ID:       id,
+		UserID:   userID,
+		send:     make(chan []byte, sendQueueSize),
+		done:     make(chan struct{}),
+		channels: map[int64]bool{},
+	}
+}
+
+// Send queues `data` to be written to the connection as a text message
+// without waiting for it to be written. If the queue is full, the
+// connection is closed and ErrSlowConsumer is returned.
+func (c *SocketConn) Send(data []byte) error {
+	select {
+	case <-c.done:
+		return ErrConnectionClosed
+	default:
+	}
+	select {
+	case c.send <- data:
+		return nil
+	default:
+		c.reap(reapSlowConsumer, websocket.CloseTryAgainLater, "Too many pending messages")
+		return ErrSlowConsumer
+	}
+}
+
+// ReadMessage reads the next message from the connection. It must only be
+// called by the one goroutine reading the connection. Connections that
+// fail to read because their client stopped answering pings or sent too
+// large a message are reaped.
+func (c *SocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	messageType, data, err = c.conn.ReadMessage()
+	var netErr net.Error
+	if errors.Is(err, websocket.ErrReadLimit) {
+		// The close frame has already been sent
+		c.reap(reapMessageTooLarge, 0, "")
+	} else if errors.As(err, &netErr) && netErr.Timeout() {
+		c.reap(reapPongTimeout, 0, "")
+	}
+	return messageType, data, err
+}
+
+// Close closes the connection without a close frame, dropping the messages
+// still queued
+func (c *SocketConn) Close() {
+	c.reap("", 0, "")
+}
+
+// CloseWith sends a close frame with the given close code and reason, then
+// closes the connection, dropping the messages still queued
+func (c *SocketConn) CloseWith(code int, reason string) {
+	c.reap("", code, reason)
+}
+
+// reap closes the connection if it's still open, with a close frame unless
+// `code` is 0, and counts it as reaped for `cause` unless that's empty
+func (c *SocketConn) reap(cause string, code int, reason string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if code != 0 {
+			// Unlike other writes, control messages may be written concurrently
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(closeWriteWait))
+		}
+		c.conn.Close()
+		if len(cause) > 0 {
+			slog.Info("Reaped WebSocket connection", "userID", c.UserID, "connectionID", c.ID, "reason", cause)
+			metrics.WebSocketReaped(cause)
+		}
+	})
+}
+
+// writeLoop writes the queued messages to the connection and pings it
+// until it is closed, closing it if a write fails
+func (c *SocketConn) writeLoop() {
+	ticker := time.NewTicker(c.options.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+			if err := c.conn.WriteMessage(TextMessage, data); err != nil {
+				slog.Debug("Error writing message to WebSocket connection", "userID", c.UserID, "connectionID", c.ID, "error", err)
+				c.reap(reapWriteFailed, 0, "")
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+			if err := c.conn.WriteMessage(PingMessage, nil); err != nil {
+				slog.Debug("Error pinging WebSocket connection", "userID", c.UserID, "connectionID", c.ID, "error", err)
+				c.reap(reapWriteFailed, 0, "")
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// SocketStore represents a map of userIDs to the WebSocket connections of
+// each user, by connection ID, and an index of the connections subscribed
+// to each channel, that is safe for concurrent use
 type SocketStore struct {
-	Connections map[int64]*websocket.Conn
+	Connections map[int64]map[uint64]*SocketConn
+	subscribers map[int64]map[*SocketConn]bool
+	nextID      uint64
 	mx          sync.RWMutex
 }
 
 // NewSocketStore constructs a new map of userIDs and WebSocket connections
 func NewSocketStore() *SocketStore {
 	return &SocketStore{
-		Connections: map[int64]*websocket.Conn{},
+		Connections: map[int64]map[uint64]*SocketConn{},
+		subscribers: map[int64]map[*SocketConn]bool{},
 	}
 }
 
-// Set adds a new userID and WebSocket connection to the map
-func (c *SocketStore) Set(userID int64, wsConn *websocket.Conn) {
+// Add adds a new WebSocket connection of the given userID to the map, without
+// replacing the other connections of the user, and starts writing the
+// messages sent to it and pinging it as configured by `options`. It returns
+// the connection with its ID.
+func (c *SocketStore) Add(userID int64, wsConn *websocket.Conn, options WebSocketOptions) *SocketConn {
 	c.mx.Lock()
-	c.Connections[userID] = wsConn
-	c.mx.Unlock()
+	defer c.mx.Unlock()
+	c.nextID++
+	conn := newSocketConn(c.nextID, userID, wsConn, options)
+	if c.Connections[userID] == nil {
+		c.Connections[userID] = map[uint64]*SocketConn{}
+	}
+	c.Connections[userID][conn.ID] = conn
+	go conn.writeLoop()
+	return conn
+}
+
+// Get retrieves the WebSocket connections for a given userID
+func (c *SocketStore) Get(userID int64) []*SocketConn {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	conns := make([]*SocketConn, 0, len(c.Connections[userID]))
+	for _, conn := range c.Connections[userID] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// All returns every connection in the store. The connections can be sent
+// messages without holding the store's lock while others are added or
+// removed.
+func (c *SocketStore) All() []*SocketConn {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	var conns []*SocketConn
+	for _, userConns := range c.Connections {
+		for _, conn := range userConns {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// Len returns the number of connections in the store
+func (c *SocketStore) Len() int {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	n := 0
+	for _, userConns := range c.Connections {
+		n += len(userConns)
+	}
+	return n
+}
+
+// Delete removes the given WebSocket connection and its subscriptions,
+// leaving the other connections of its user in the store
+func (c *SocketStore) Delete(conn *SocketConn) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	delete(c.Connections[conn.UserID], conn.ID)
+	if len(c.Connections[conn.UserID]) == 0 {
+		delete(c.Connections, conn.UserID)
+	}
+	for channelID := range conn.channels {
+		c.unsubscribe(conn, channelID)
+	}
+}
+
+// Subscribe subscribes the given connection to the events of the channel
+// `channelID`. The caller must have checked that its user may see them.
+func (c *SocketStore) Subscribe(conn *SocketConn, channelID int64) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.Connections[conn.UserID][conn.ID] != conn {
+		return ErrConnectionClosed
+	}
+	if !conn.channels[channelID] && len(conn.channels) >= maxSubscriptions {
+		return ErrTooManySubscriptions
+	}
+	conn.channels[channelID] = true
+	if c.subscribers[channelID] == nil {
+		c.subscribers[channelID] = map[*SocketConn]bool{}
+	}
+	c.subscribers[channelID][conn] = true
+	return nil
+}
+
+// Unsubscribe stops the given connection from receiving the events of the
+// channel `channelID`
+func (c *SocketStore) Unsubscribe(conn *SocketConn, channelID int64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.unsubscribe(conn, channelID)
+}
+
+// unsubscribe is Unsubscribe for callers holding the lock
+func (c *SocketStore) unsubscribe(conn *SocketConn, channelID int64) {
+	delete(conn.channels, channelID)
+	delete(c.subscribers[channelID], conn)
+	if len(c.subscribers[channelID]) == 0 {
+		delete(c.subscribers, channelID)
+	}
 }
 
-// Get retrieves the WebSocket connection for a given userID
-func (c *SocketStore) Get(userID int64) *websocket.Conn {
+// UnsubscribeChannel removes every subscription to the channel
+// `channelID`, such as once it has been deleted
+func (c *SocketStore) UnsubscribeChannel(channelID int64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for conn := range c.subscribers[channelID] {
+		delete(conn.channels, channelID)
+	}
+	delete(c.subscribers, channelID)
+}
+
+// Subscribers returns the connections subscribed to the channel
+// `channelID`, which can be sent messages without holding the store's lock
+func (c *SocketStore) Subscribers(channelID int64) []*SocketConn {
 	c.mx.RLock()
 	defer c.mx.RUnlock()
-	return c.Connections[userID]
+	conns := make([]*SocketConn, 0, len(c.subscribers[channelID]))
+	for conn := range c.subscribers[channelID] {
+		conns = append(conns, conn)
+	}
+	return conns
 }
 
-// Delete removes the WebSocket connection for a given userID
-func (c *SocketStore) Delete(userID int64) {
+// CloseAll empties the store, then sends every connection a close frame with
+// the given close code and reason and closes it. The connections are closed
+// concurrently without holding the store's lock, and CloseAll returns the
+// context's error if it's done before they all are. Clients should
+// reconnect on websocket.CloseGoingAway.
+func (c *SocketStore) CloseAll(ctx context.Context, code int, reason string) error {
 	c.mx.Lock()
-	delete(c.Connections, userID)
+	var conns []*SocketConn
+	for _, userConns := range c.Connections {
+		for _, conn := range userConns {
+			conns = append(conns, conn)
+		}
+	}
+	c.Connections = map[int64]map[uint64]*SocketConn{}
+	c.subscribers = map[int64]map[*SocketConn]bool{}
 	c.mx.Unlock()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var wg sync.WaitGroup
+		for _, conn := range conns {
+			wg.Add(1)
+			go func(conn *SocketConn) {
+				defer wg.Done()
+				conn.CloseWith(code, reason)
+			}(conn)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialTestConns opens `n` WebSocket connections to a test server and returns
+// the server's and the client's side of each
+func dialTestConns(t *testing.T, n int) ([]*websocket.Conn, []*websocket.Conn) {
+	upgraded := make(chan *websocket.Conn)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("error upgrading connection: %v", err)
+			return
+		}
+		upgraded <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	var serverConns, clientConns []*websocket.Conn
+	for i := 0; i < n; i++ {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("error dialing server: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		clientConns = append(clientConns, client)
+		serverConns = append(serverConns, <-upgraded)
+	}
+	return serverConns, clientConns
+}
+
+func TestSocketStoreCloseAll(t *testing.T) {
+	store := NewSocketStore()
+	serverConns, clientConns := dialTestConns(t, 3)
+	store.Add(1, serverConns[0], DefaultWebSocketOptions())
+	store.Add(1, serverConns[1], DefaultWebSocketOptions())
+	store.Add(2, serverConns[2], DefaultWebSocketOptions())
+
+	if err := store.CloseAll(context.Background(), websocket.CloseGoingAway, "Server is shutting down"); err != nil {
+		t.Fatalf("unexpected error closing connections: %v", err)
+	}
+
+	for i, client := range clientConns {
+		_, _, err := client.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("expected a going away close frame on connection %d, but got %v", i, err)
+		}
+	}
+	if n := store.Len(); n != 0 {
+		t.Errorf("expected the connections to be removed from the store, but got %d", n)
+	}
+}
+
+func TestSocketStoreCloseAllDone(t *testing.T) {
+	store := NewSocketStore()
+	serverConns, clientConns := dialTestConns(t, 1)
+	store.Add(1, serverConns[0], DefaultWebSocketOptions())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.CloseAll(ctx, websocket.CloseGoingAway, ""); err != nil && !errors.Is(err, context.Canceled) {
+		t.Errorf("expected no error or the context's error, but got %v", err)
+	}
+
+	// The store is emptied even when the context is done, and the
+	// connection is still closed
+	if n := store.Len(); n != 0 {
+		t.Errorf("expected the connection to be removed from the store, but got %d", n)
+	}
+	clientConns[0].SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := clientConns[0].ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close frame, but got %v", err)
+	}
+}
+
+func TestSocketStoreMultipleConnections(t *testing.T) {
+	store := NewSocketStore()
+	serverConns, _ := dialTestConns(t, 3)
+	first := store.Add(1, serverConns[0], DefaultWebSocketOptions())
+	second := store.Add(1, serverConns[1], DefaultWebSocketOptions())
+	other := store.Add(2, serverConns[2], DefaultWebSocketOptions())
+	defer store.CloseAll(context.Background(), websocket.CloseNormalClosure, "")
+	if first.ID == second.ID || first.ID == other.ID || second.ID == other.ID {
+		t.Fatalf("expected every connection to have its own ID, but got %d, %d and %d", first.ID, second.ID, other.ID)
+	}
+	if conns := store.Get(1); len(conns) != 2 {
+		t.Errorf("expected both connections of the user, but got %d", len(conns))
+	}
+	if n := store.Len(); n != 3 {
+		t.Errorf("expected 3 connections, but got %d", n)
+	}
+	if all := store.All(); len(all) != 3 {
+		t.Errorf("expected every connection to be returned, but got %d", len(all))
+	}
+
+	store.Delete(first)
+	conns := store.Get(1)
+	if len(conns) != 1 || conns[0] != second {
+		t.Errorf("expected only the other connection of the user to remain, but got %v", conns)
+	}
+	if n := store.Len(); n != 2 {
+		t.Errorf("expected 2 connections, but got %d", n)
+	}
+
+	store.Delete(second)
+	if _, found := store.Connections[1]; found {
+		t.Error("expected the user to be removed once their last connection is")
+	}
+	if conns := store.Get(2); len(conns) != 1 || conns[0] != other {
+		t.Errorf("expected the other user's connection to remain, but got %v", conns)
+	}
+}
+
+func TestSocketStoreSubscriptions(t *testing.T) {
+	store := NewSocketStore()
+	serverConns, _ := dialTestConns(t, 3)
+	first := store.Add(1, serverConns[0], DefaultWebSocketOptions())
+	second := store.Add(1, serverConns[1], DefaultWebSocketOptions())
+	other := store.Add(2, serverConns[2], DefaultWebSocketOptions())
+	defer store.CloseAll(context.Background(), websocket.CloseNormalClosure, "")
+
+	for _, conn := range []*SocketConn{first, second, other} {
+		if err := store.Subscribe(conn, 10); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	store.Subscribe(first, 20)
+	if subscribers := store.Subscribers(10); len(subscribers) != 3 {
+		t.Errorf("expected 3 subscribers, but got %d", len(subscribers))
+	}
+
+	store.Unsubscribe(second, 10)
+	if subscribers := store.Subscribers(10); len(subscribers) != 2 {
+		t.Errorf("expected the unsubscribed connection to be removed, but got %d subscribers", len(subscribers))
+	}
+	store.Delete(first)
+	if subscribers := store.Subscribers(20); len(subscribers) != 0 {
+		t.Errorf("expected a deleted connection's subscriptions to be removed, but got %d subscribers", len(subscribers))
+	}
+	if err := store.Subscribe(first, 30); err != ErrConnectionClosed {
+		t.Errorf("expected a deleted connection not to subscribe, but got %v", err)
+	}
+	store.UnsubscribeChannel(10)
+	if subscribers := store.Subscribers(10); len(subscribers) != 0 || len(other.channels) != 0 {
+		t.Errorf("expected every subscription to the channel to be removed, but got %d subscribers", len(subscribers))
+	}
+
+	for channelID := int64(1); channelID <= maxSubscriptions; channelID++ {
+		if err := store.Subscribe(other, channelID); err != nil {
+			t.Fatalf("unexpected error subscribing to channel %d: %v", channelID, err)
+		}
+	}
+	if err := store.Subscribe(other, maxSubscriptions+1); err != ErrTooManySubscriptions {
+		t.Errorf("expected too many subscriptions, but got %v", err)
+	}
+	if err := store.Subscribe(other, 1); err != nil {
+		t.Errorf("expected subscribing again to succeed, but got %v", err)
+	}
+}
+
+func TestSocketConnSlowConsumer(t *testing.T) {
+	serverConns, clientConns := dialTestConns(t, 1)
+	// Without its writer, nothing drains the connection's queue
+	conn := newSocketConn(1, 1, serverConns[0], DefaultWebSocketOptions())
+	for i := 0; i < sendQueueSize; i++ {
+		if err := conn.Send([]byte("message")); err != nil {
+			t.Fatalf("expected message %d to be queued, but got %v", i, err)
+		}
+	}
+	if err := conn.Send([]byte("message")); err != ErrSlowConsumer {
+		t.Errorf("expected a full queue to be a slow consumer, but got %v", err)
+	}
+	if err := conn.Send([]byte("message")); err != ErrConnectionClosed {
+		t.Errorf("expected the slow consumer to be closed, but got %v", err)
+	}
+
+	_, _, err := clientConns[0].ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Errorf("expected a try again later close frame, but got %v", err)
+	}
+}
+
+func TestSocketConnHeartbeat(t *testing.T) {
+	options := WebSocketOptions{PingInterval: 20 * time.Millisecond, PongWait: 100 * time.Millisecond, WriteWait: time.Second, MaxMessageSize: 64}
+	store := NewSocketStore()
+	serverConns, clientConns := dialTestConns(t, 2)
+	alive := store.Add(1, serverConns[0], options)
+	dead := store.Add(2, serverConns[1], options)
+	defer store.CloseAll(context.Background(), websocket.CloseNormalClosure, "")
+
+	// Clients answer pings while they read, so only the first one answers
+	go func() {
+		for {
+			if _, _, err := clientConns[0].ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	reaped := make(chan error, 2)
+	for _, conn := range []*SocketConn{alive, dead} {
+		go func(conn *SocketConn) {
+			_, _, err := conn.ReadMessage()
+			reaped <- err
+		}(conn)
+	}
+
+	select {
+	case err := <-reaped:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("expected the connection to time out, but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection that doesn't answer pings to be reaped")
+	}
+	if err := dead.Send([]byte("message")); err != ErrConnectionClosed {
+		t.Errorf("expected the reaped connection to be closed, but got %v", err)
+	}
+
+	// The connection answering pings outlives several pong waits
+	select {
+	case err := <-reaped:
+		t.Errorf("expected the connection answering pings to stay open, but got %v", err)
+	case <-time.After(3 * options.PongWait):
+	}
+	if err := alive.Send([]byte("message")); err != nil {
+		t.Errorf("expected the connection answering pings to stay open, but got %v", err)
+	}
+}
+
+func TestSocketConnMaxMessageSize(t *testing.T) {
+	options := DefaultWebSocketOptions()
+	options.MaxMessageSize = 16
+	serverConns, clientConns := dialTestConns(t, 1)
+	conn := newSocketConn(1, 1, serverConns[0], options)
+
+	clientConns[0].WriteMessage(TextMessage, []byte("short"))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "short" {
+		t.Fatalf("expected a message within the limit to be read, but got %q (%v)", data, err)
+	}
+	clientConns[0].WriteMessage(TextMessage, []byte(strings.Repeat("long", 8)))
+	if _, _, err := conn.ReadMessage(); err != websocket.ErrReadLimit {
+		t.Errorf("expected a message over the limit to fail, but got %v", err)
+	}
+	if err := conn.Send([]byte("message")); err != ErrConnectionClosed {
+		t.Errorf("expected the connection to be closed, but got %v", err)
+	}
+	_, _, err := clientConns[0].ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("expected a message too big close frame, but got %v", err)
+	}
+}
+
+// TestSocketStoreConcurrentFanOut sends messages to every connection while
+// connections are added and removed and clients echo to their own
+// connection, and is meant to be run with the race detector
+func TestSocketStoreConcurrentFanOut(t *testing.T) {
+	const messages = 20
+	store := NewSocketStore()
+	serverConns, clientConns := dialTestConns(t, 6)
+	var conns []*SocketConn
+	for i, serverConn := range serverConns[:4] {
+		conns = append(conns, store.Add(int64(i%2), serverConn, DefaultWebSocketOptions()))
+	}
+
+	received := make([]int, len(conns))
+	var readers sync.WaitGroup
+	for i := range conns {
+		readers.Add(1)
+		go func(i int) {
+			defer readers.Done()
+			for received[i] < 2*messages {
+				if _, _, err := clientConns[i].ReadMessage(); err != nil {
+					t.Errorf("connection %d: error reading message: %v", i, err)
+					return
+				}
+				received[i]++
+			}
+		}(i)
+	}
+
+	var senders sync.WaitGroup
+	for sender := 0; sender < 2; sender++ {
+		senders.Add(1)
+		go func(sender int) {
+			defer senders.Done()
+			for i := 0; i < messages; i++ {
+				for _, conn := range store.All() {
+					conn.Send([]byte(fmt.Sprintf("sender %d message %d", sender, i)))
+				}
+			}
+		}(sender)
+	}
+	// Echo to the same connections while the messages are fanned out
+	for _, conn := range conns {
+		senders.Add(1)
+		go func(conn *SocketConn) {
+			defer senders.Done()
+			conn.Send([]byte("echo"))
+		}(conn)
+	}
+	// Add and remove connections while the messages are fanned out
+	senders.Add(1)
+	go func() {
+		defer senders.Done()
+		for _, serverConn := range serverConns[4:] {
+			conn := store.Add(2, serverConn, DefaultWebSocketOptions())
+			store.Len()
+			store.Delete(conn)
+			conn.Close()
+		}
+	}()
+	senders.Wait()
+	readers.Wait()
+
+	for i, n := range received {
+		if n < 2*messages {
+			t.Errorf("connection %d: expected at least %d messages, but got %d", i, 2*messages, n)
+		}
+	}
+	store.CloseAll(context.Background(), websocket.CloseNormalClosure, "")
+}
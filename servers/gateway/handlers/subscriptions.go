@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"serverside-final-project/servers/gateway/identity"
+	"serverside-final-project/servers/gateway/models/users"
+	"strconv"
+)
+
+// Types of the messages WebSocket clients send to start and stop receiving
+// the events of a channel, and of the responses they get back
+const (
+	SubscribeRequest   = "subscribe"
+	UnsubscribeRequest = "unsubscribe"
+
+	SubscribedResponse   = "subscribed"
+	UnsubscribedResponse = "unsubscribed"
+	ErrorResponse        = "error"
+)
+
+// SubscriptionRequest is a message from a WebSocket client asking to start
+// or stop receiving the events of a channel
+type SubscriptionRequest struct {
+	Type      string `json:"type"`
+	ChannelID int64  `json:"channelID"`
+}
+
+// SubscriptionResponse answers a SubscriptionRequest
+type SubscriptionResponse struct {
+	Type      string `json:"type"`
+	ChannelID int64  `json:"channelID,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ChannelAuthorizer decides which channels users may subscribe to
+type ChannelAuthorizer interface {
+	// CanSubscribe reports whether `user` may receive the events of the
+	// channel `channelID`
+	CanSubscribe(ctx context.Context, user *users.User, channelID int64) (bool, error)
+}
+
+// MessagingAuthorizer authorizes subscriptions by asking the messaging
+// service for the channel on behalf of the user, which it only allows for
+// public channels and members of private ones
+type MessagingAuthorizer struct {
+	transport http.RoundTripper
+	signer    *identity.Signer
+}
+
+// NewMessagingAuthorizer constructs a MessagingAuthorizer that sends its
+// requests through `transport`, such as the messaging service's
+// upstream.Pool, with identities signed by `signer`
+func NewMessagingAuthorizer(transport http.RoundTripper, signer *identity.Signer) *MessagingAuthorizer {
+	return &MessagingAuthorizer{transport: transport, signer: signer}
+}
+
+// CanSubscribe reports whether the messaging service lets `user` see the
+// channel `channelID`. Channels that are forbidden or don't exist can't be
+// subscribed to, and any other failure is returned as an error.
+func (a *MessagingAuthorizer) CanSubscribe(ctx context.Context, user *users.User, channelID int64) (bool, error) {
+	encoded, err := json.Marshal(user)
+	if err != nil {
+		return false, fmt.Errorf("Error encoding user for X-User header: %v", err)
+	}
+	// Express handles HEAD with the channel's GET handler, so the service
+	// still queries the messages, but HEAD keeps them from being sent back
+	r, err := http.NewRequestWithContext(ctx, http.MethodHead, "http://messaging/v1/channels/"+strconv.FormatInt(channelID, 10), nil)
+	if err != nil {
+		return false, err
+	}
+	a.signer.Sign(r, encoded)
+	resp, err := a.transport.RoundTrip(r)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Messaging service responded to channel %d with %s", channelID, resp.Status)
+	}
+}
+
+// handleSubscription handles a SubscriptionRequest in `data` from the
+// connection `conn` of `user`, and returns the response to send back
+func (hc *Context) handleSubscription(ctx context.Context, store *SocketStore, conn *SocketConn, user *users.User, data []byte) *SubscriptionResponse {
+	request := &SubscriptionRequest{}
+	if err := json.Unmarshal(data, request); err != nil || request.ChannelID <= 0 {
+		return &SubscriptionResponse{Type: ErrorResponse, Error: "Messages must be subscribe or unsubscribe requests with a channelID"}
+	}
+
+	switch request.Type {
+	case SubscribeRequest:
+		if hc.Channels == nil {
+			return &SubscriptionResponse{Type: ErrorResponse, ChannelID: request.ChannelID, Error: "Subscriptions are unavailable"}
+		}
+		allowed, err := hc.Channels.CanSubscribe(ctx, user, request.ChannelID)
+		if err != nil {
+			slog.WarnContext(ctx, "Error authorizing channel subscription", "userID", user.ID, "channelID", request.ChannelID, "error", err)
+			return &SubscriptionResponse{Type: ErrorResponse, ChannelID: request.ChannelID, Error: "Access to the channel could not be checked, please try again later"}
+		}
+		if !allowed {
+			return &SubscriptionResponse{Type: ErrorResponse, ChannelID: request.ChannelID, Error: "You are not allowed to subscribe to this channel"}
+		}
+		if err := store.Subscribe(conn, request.ChannelID); err != nil {
+			return &SubscriptionResponse{Type: ErrorResponse, ChannelID: request.ChannelID, Error: err.Error()}
+		}
+		return &SubscriptionResponse{Type: SubscribedResponse, ChannelID: request.ChannelID}
+	case UnsubscribeRequest:
+		store.Unsubscribe(conn, request.ChannelID)
+		return &SubscriptionResponse{Type: UnsubscribedResponse, ChannelID: request.ChannelID}
+	default:
+		return &SubscriptionResponse{Type: ErrorResponse, ChannelID: request.ChannelID, Error: fmt.Sprintf("Unknown message type %q", request.Type)}
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"serverside-final-project/servers/gateway/identity"
+	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/sessions"
+	"serverside-final-project/servers/gateway/upstream"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeChannels allows users to subscribe to the channels listed for them
+type fakeChannels struct {
+	allowed map[int64][]int64
+	err     error
+}
+
+func (f *fakeChannels) CanSubscribe(ctx context.Context, user *users.User, channelID int64) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	return contains(channelID, f.allowed[user.ID]), nil
+}
+
+func TestMessagingAuthorizer(t *testing.T) {
+	verifier := identity.NewVerifier([]byte(testIdentityKey), identity.DefaultMaxAge)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoded, err := verifier.Verify(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		user := &users.User{}
+		json.Unmarshal(encoded, user)
+		switch {
+		case r.Method != http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/v1/channels/1":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v1/channels/2" && user.ID == 7:
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v1/channels/2":
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path == "/v1/channels/3":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	pool, err := upstream.NewPool("messaging", []*url.URL{target}, upstream.Options{})
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	authorizer := NewMessagingAuthorizer(pool, identity.NewSigner([]byte(testIdentityKey)))
+
+	cases := []struct {
+		name      string
+		userID    int64
+		channelID int64
+		allowed   bool
+		err       bool
+	}{
+		{"Public Channel", 1, 1, true, false},
+		{"Private Channel Member", 7, 2, true, false},
+		{"Private Channel Non Member", 1, 2, false, false},
+		{"Missing Channel", 1, 4, false, false},
+		{"Service Error", 1, 3, false, true},
+	}
+	for _, c := range cases {
+		allowed, err := authorizer.CanSubscribe(context.Background(), &users.User{ID: c.userID}, c.channelID)
+		if allowed != c.allowed || (err != nil) != c.err {
+			t.Errorf("case %s: expected %v with error %v, but got %v (%v)", c.name, c.allowed, c.err, allowed, err)
+		}
+	}
+}
+
+func TestHandleSubscription(t *testing.T) {
+	hctx := NewContext(testSessionKey, sessions.NewMemStore(time.Hour, time.Hour), users.NewTestUserStore("client"))
+	hctx.Channels = &fakeChannels{allowed: map[int64][]int64{1: {10}}}
+	store := NewSocketStore()
+	serverConns, _ := dialTestConns(t, 1)
+	user := &users.User{ID: 1}
+	conn := store.Add(user.ID, serverConns[0], DefaultWebSocketOptions())
+	defer store.CloseAll(context.Background(), websocket.CloseNormalClosure, "")
+
+	cases := []struct {
+		name     string
+		request  string
+		response SubscriptionResponse
+	}{
+		{"Subscribe", `{"type":"subscribe","channelID":10}`, SubscriptionResponse{Type: SubscribedResponse, ChannelID: 10}},
+		{"Subscribe Forbidden", `{"type":"subscribe","channelID":20}`, SubscriptionResponse{Type: ErrorResponse, ChannelID: 20, Error: "You are not allowed to subscribe to this channel"}},
+		{"Unsubscribe", `{"type":"unsubscribe","channelID":10}`, SubscriptionResponse{Type: UnsubscribedResponse, ChannelID: 10}},
+		{"Unknown Type", `{"type":"publish","channelID":10}`, SubscriptionResponse{Type: ErrorResponse, ChannelID: 10, Error: `Unknown message type "publish"`}},
+		{"No Channel", `{"type":"subscribe"}`, SubscriptionResponse{Type: ErrorResponse, Error: "Messages must be subscribe or unsubscribe requests with a channelID"}},
+		{"Not JSON", `hello`, SubscriptionResponse{Type: ErrorResponse, Error: "Messages must be subscribe or unsubscribe requests with a channelID"}},
+	}
+	for _, c := range cases {
+		response := hctx.handleSubscription(context.Background(), store, conn, user, []byte(c.request))
+		if *response != c.response {
+			t.Errorf("case %s: expected %+v, but got %+v", c.name, c.response, *response)
+		}
+		subscribed := len(store.Subscribers(10)) == 1
+		if expected := c.name == "Subscribe" || c.name == "Subscribe Forbidden"; subscribed != expected {
+			t.Errorf("case %s: expected subscribed to channel 10 to be %v, but got %v", c.name, expected, subscribed)
+		}
+	}
+
+	hctx.Channels = &fakeChannels{err: errors.New("messaging is down")}
+	response := hctx.handleSubscription(context.Background(), store, conn, user, []byte(`{"type":"subscribe","channelID":10}`))
+	if response.Type != ErrorResponse || !strings.Contains(response.Error, "try again later") || len(store.Subscribers(10)) != 0 {
+		t.Errorf("expected the subscription to fail when access can't be checked, but got %+v", response)
+	}
+	hctx.Channels = nil
+	response = hctx.handleSubscription(context.Background(), store, conn, user, []byte(`{"type":"subscribe","channelID":10}`))
+	if response.Type != ErrorResponse || len(store.Subscribers(10)) != 0 {
+		t.Errorf("expected subscriptions to be refused without an authorizer, but got %+v", response)
+	}
+}
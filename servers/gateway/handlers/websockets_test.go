@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/sessions"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// endOfCase is sent to every connection after each case, so that clients
+// can tell they received nothing, since reads can't be retried after a
+// timeout
+const endOfCase = "end of case"
+
+// nextMessage returns the next message `client` receives before endOfCase,
+// or an empty string if none arrives
+func nextMessage(t *testing.T, client *websocket.Conn) string {
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("error reading message: %v", err)
+	}
+	if string(data) == endOfCase {
+		return ""
+	}
+	if _, end, err := client.ReadMessage(); err != nil || string(end) != endOfCase {
+		t.Fatalf("expected one message, but also got %q (%v)", end, err)
+	}
+	return string(data)
+}
+
+func TestFanOut(t *testing.T) {
+	store := NewSocketStore()
+	serverConns, clients := dialTestConns(t, 4)
+	// User 1 has two connections, and users 2 and 3 one each
+	conns := []*SocketConn{
+		store.Add(1, serverConns[0], DefaultWebSocketOptions()),
+		store.Add(1, serverConns[1], DefaultWebSocketOptions()),
+		store.Add(2, serverConns[2], DefaultWebSocketOptions()),
+		store.Add(3, serverConns[3], DefaultWebSocketOptions()),
+	}
+	defer store.CloseAll(context.Background(), websocket.CloseNormalClosure, "")
+	for _, conn := range conns[:3] {
+		store.Subscribe(conn, 5)
+	}
+
+	cases := []struct {
+		name      string
+		eventType string
+		channelID int64
+		payload   string
+		private   bool
+		userIDs   []int64
+		expected  []bool
+	}{
+		{"Public Message", MessageNewEvent, 5, `{"id":1,"channelID":5,"body":"hi"}`, false, nil, []bool{true, true, true, false}},
+		{"Other Channel", MessageUpdateEvent, 6, `{"id":2,"channelID":6}`, false, nil, []bool{false, false, false, false}},
+		// User 2 was removed from the channel, which made it private
+		{"Private Message", MessageDeleteEvent, 5, `{"id":1}`, true, []int64{1}, []bool{true, true, false, false}},
+		{"Unsubscribed Non Member", MessageNewEvent, 5, `{"id":3,"channelID":5}`, false, nil, []bool{true, true, false, false}},
+		{"New Public Channel", ChannelNewEvent, 7, `{"id":7,"private":false}`, false, nil, []bool{true, true, true, true}},
+		{"New Private Channel", ChannelNewEvent, 8, `{"id":8,"private":true}`, true, []int64{3}, []bool{false, false, false, true}},
+		{"New Private Channel Without Members", ChannelNewEvent, 9, `{"id":9,"private":true}`, true, nil, []bool{false, false, false, false}},
+		{"Deleted Channel", ChannelDeleteEvent, 5, `{"id":5}`, false, nil, []bool{true, true, false, false}},
+		{"After Deletion", MessageNewEvent, 5, `{"id":4,"channelID":5}`, false, nil, []bool{false, false, false, false}},
+	}
+	for i, c := range cases {
+		msg := &Message{
+			Event: Event{
+				Type:      c.eventType,
+				Version:   EventVersion,
+				ChannelID: c.channelID,
+				Payload:   json.RawMessage(c.payload),
+				EventID:   fmt.Sprintf("event-%d", i),
+				TS:        time.Now(),
+			},
+			Private: c.private,
+			UserIDs: c.userIDs,
+		}
+		if err := msg.Validate(); err != nil {
+			t.Fatalf("case %s: invalid message: %v", c.name, err)
+		}
+		sent := 0
+		for _, expected := range c.expected {
+			if expected {
+				sent++
+			}
+		}
+		if recipients := fanOut(context.Background(), store, msg); recipients != sent {
+			t.Errorf("case %s: expected %d recipients, but got %d", c.name, sent, recipients)
+		}
+		for _, conn := range conns {
+			conn.Send([]byte(endOfCase))
+		}
+		for i, client := range clients {
+			received := nextMessage(t, client)
+			if !c.expected[i] {
+				if len(received) > 0 {
+					t.Errorf("case %s: expected connection %d to receive nothing, but got %q", c.name, i, received)
+				}
+				continue
+			}
+			event := &Event{}
+			if err := json.Unmarshal([]byte(received), event); err != nil || event.EventID != msg.EventID || string(event.Payload) != c.payload {
+				t.Errorf("case %s: expected connection %d to receive the event, but got %q", c.name, i, received)
+			}
+			if strings.Contains(received, "userIDs") {
+				t.Errorf("case %s: expected the members not to be sent to connection %d, but got %q", c.name, i, received)
+			}
+		}
+	}
+}
+
+// A session ID that is validly signed but whose state is gone, such as an
+// expired or revoked session, must not be upgraded
+func TestWebSocketConnectionHandlerNoState(t *testing.T) {
+	hctx := NewContext("key", sessions.NewMemStore(3*time.Minute, 3*time.Minute), users.NewTestUserStore("client"))
+	sid, err := sessions.NewSessionID("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/ws", nil)
+	req.Header.Set("Authorization", "Bearer "+sid.String())
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	rr := httptest.NewRecorder()
+	before := ActiveWebSockets()
+	hctx.WebSocketConnectionHandler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if n := ActiveWebSockets(); n != before {
+		t.Errorf("expected no connection to be added, but got %d instead of %d", n, before)
+	}
+}
+
+// A session store outage isn't a reason for the client to sign in again
+func TestWebSocketConnectionHandlerStoreUnavailable(t *testing.T) {
+	hctx := NewContext("key", &unavailableSessionStore{sessions.NewMemStore(3*time.Minute, 3*time.Minute)}, users.NewTestUserStore("client"))
+	sid, err := sessions.NewSessionID("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/ws", nil)
+	req.Header.Set("Authorization", "Bearer "+sid.String())
+	rr := httptest.NewRecorder()
+	hctx.WebSocketConnectionHandler(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if strings.Contains(rr.Body.String(), "10.0.0.7") {
+		t.Errorf("expected the store's error to be hidden, but got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,158 @@
+package identity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testUser = `{"id":1,"userName":"tester"}`
+
+// signedRequest returns a request to `target` signed for testUser at `issuedAt`
+func signedRequest(key string, method string, target string, issuedAt time.Time) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	signer := NewSigner([]byte(key))
+	signer.now = func() time.Time { return issuedAt }
+	signer.Sign(r, []byte(testUser))
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	key := "0123456789abcdef"
+
+	cases := []struct {
+		name        string
+		request     func() *http.Request
+		expectedErr error
+		expectUser  bool
+	}{
+		{
+			"Valid",
+			func() *http.Request { return signedRequest(key, "GET", "/v1/events?id=1", now) },
+			nil,
+			true,
+		},
+		{
+			"Anonymous",
+			func() *http.Request { return httptest.NewRequest("GET", "/v1/events", nil) },
+			nil,
+			false,
+		},
+		{
+			"Unsigned",
+			func() *http.Request {
+				r := httptest.NewRequest("GET", "/v1/events", nil)
+				r.Header.Set(Header, testUser)
+				return r
+			},
+			ErrMissingSignature,
+			false,
+		},
+		{
+			"Forged User",
+			func() *http.Request {
+				r := signedRequest(key, "GET", "/v1/events", now)
+				r.Header.Set(Header, `{"id":2,"userName":"admin"}`)
+				return r
+			},
+			ErrInvalidSignature,
+			false,
+		},
+		{
+			"Wrong Key",
+			func() *http.Request { return signedRequest("fedcba9876543210", "GET", "/v1/events", now) },
+			ErrInvalidSignature,
+			false,
+		},
+		{
+			"Replayed To Another Path",
+			func() *http.Request {
+				r := signedRequest(key, "GET", "/v1/events", now)
+				replayed := httptest.NewRequest("GET", "/v1/events/1", nil)
+				replayed.Header = r.Header
+				return replayed
+			},
+			ErrInvalidSignature,
+			false,
+		},
+		{
+			"Replayed With Another Method",
+			func() *http.Request {
+				r := signedRequest(key, "GET", "/v1/events/1", now)
+				replayed := httptest.NewRequest("DELETE", "/v1/events/1", nil)
+				replayed.Header = r.Header
+				return replayed
+			},
+			ErrInvalidSignature,
+			false,
+		},
+		{
+			"Expired",
+			func() *http.Request { return signedRequest(key, "GET", "/v1/events", now.Add(-10*time.Minute)) },
+			ErrExpired,
+			false,
+		},
+		{
+			"Issued In The Future",
+			func() *http.Request { return signedRequest(key, "GET", "/v1/events", now.Add(10*time.Minute)) },
+			ErrExpired,
+			false,
+		},
+		{
+			"Malformed",
+			func() *http.Request {
+				r := signedRequest(key, "GET", "/v1/events", now)
+				r.Header.Set(SignatureHeader, "v1,s=abc")
+				return r
+			},
+			ErrMalformed,
+			false,
+		},
+	}
+
+	verifier := NewVerifier([]byte(key), DefaultMaxAge)
+	verifier.now = func() time.Time { return now }
+	for _, c := range cases {
+		user, err := verifier.Verify(c.request())
+		if err != c.expectedErr {
+			t.Errorf("case %s: expected error %v, but got %v", c.name, c.expectedErr, err)
+		}
+		if c.expectUser && string(user) != testUser {
+			t.Errorf("case %s: expected user %s, but got %s", c.name, testUser, user)
+		}
+		if !c.expectUser && user != nil {
+			t.Errorf("case %s: expected no user, but got %s", c.name, user)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	r := signedRequest("0123456789abcdef", "GET", "/v1/events", time.Now())
+	Strip(r.Header)
+	if len(r.Header.Get(Header)) > 0 || len(r.Header.Get(SignatureHeader)) > 0 {
+		t.Errorf("expected identity headers to be removed, but got %v", r.Header)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	key := "0123456789abcdef"
+	handler := NewVerifier([]byte(key), DefaultMaxAge).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, signedRequest(key, "GET", "/v1/events", time.Now()))
+	if resp.Code != http.StatusNoContent {
+		t.Errorf("expected a signed request to be passed on, but got status %d", resp.Code)
+	}
+
+	forged := httptest.NewRequest("GET", "/v1/events", nil)
+	forged.Header.Set(Header, testUser)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, forged)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for an unsigned identity, but got %d", http.StatusUnauthorized, resp.Code)
+	}
+}
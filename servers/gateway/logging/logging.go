@@ -0,0 +1,109 @@
+// Package logging sets up the gateway's structured JSON logs. Every record
+// logged with a request's context carries the ID of the request and of its
+// trace, so that it can be correlated with the logs of the services the
+// request went through, and secrets are redacted before they are written.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Redacted replaces the value of every attribute that holds a secret
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are the lower case attribute keys and header names whose
+// values are never written to the logs
+var sensitiveKeys = map[string]bool{
+	"authorization":    true,
+	"cookie":           true,
+	"set-cookie":       true,
+	"x-user-signature": true,
+	"password":         true,
+	"passwordconf":     true,
+	"passhash":         true,
+	"sessionkey":       true,
+	"identitykey":      true,
+	"dsn":              true,
+}
+
+// New returns a logger that writes JSON records of at least `level` to `w`
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(&contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})})
+}
+
+// contextHandler adds the IDs of the request and trace in the context of
+// each record to it
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the IDs found in `ctx` to the record and writes it
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); len(id) > 0 {
+		record.AddAttrs(slog.String("requestID", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("traceID", span.TraceID().String()), slog.String("spanID", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs returns a handler that adds `attrs` to every record
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a handler that nests the attributes of every record in
+// the group `name`
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+// redact replaces the values of sensitive attributes, headers and URL
+// passwords before they are written
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, Redacted)
+	}
+	if attr.Value.Kind() != slog.KindAny {
+		return attr
+	}
+	switch value := attr.Value.Any().(type) {
+	case http.Header:
+		attr.Value = redactHeader(value)
+	case *url.URL:
+		attr.Value = slog.StringValue(value.Redacted())
+	}
+	return attr
+}
+
+// redactHeader returns `header` as a group with the values of sensitive
+// headers redacted
+func redactHeader(header http.Header) slog.Value {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]slog.Attr, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if sensitiveKeys[strings.ToLower(name)] {
+			value = Redacted
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.GroupValue(attrs...)
+}
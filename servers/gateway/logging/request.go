@@ -0,0 +1,171 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// RequestIDHeader carries the ID of a request from the client to the
+// gateway, from the gateway to upstream services, and back in the response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs taken from clients
+const maxRequestIDLength = 128
+
+// contextKey is the type of the keys of values the package adds to contexts
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	accessKey
+)
+
+// RequestID returns the ID of the request being handled with `ctx`, or an
+// empty string if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithRequestID returns a copy of `ctx` in which records are logged with
+// the request ID `id`
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// access holds what handlers learn about a request that its access log
+// should record
+type access struct {
+	userID atomic.Int64
+}
+
+// SetUserID records that the request being handled with `ctx` was made by
+// the user `id`, so that its access log includes them
+func SetUserID(ctx context.Context, id int64) {
+	if access, ok := ctx.Value(accessKey).(*access); ok {
+		access.userID.Store(id)
+	}
+}
+
+// Middleware returns middleware that gives each request to `next` an ID and
+// logs it once it has been handled. The ID is taken from the X-Request-ID
+// header if the client sent a valid one, or else generated, and is sent on
+// to upstream services and back to the client in the same header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+
+		access := &access{}
+		ctx := context.WithValue(WithRequestID(r.Context(), id), accessKey, access)
+
+		start := time.Now()
+		recorder := NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.Status),
+			slog.Int("bytes", recorder.Bytes),
+			slog.Float64("durationMs", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remoteAddr", r.RemoteAddr),
+			slog.String("userAgent", r.UserAgent()),
+		}
+		if userID := access.userID.Load(); userID != 0 {
+			attrs = append(attrs, slog.Int64("userID", userID))
+		}
+		slog.LogAttrs(ctx, slog.LevelInfo, "request", attrs...)
+	})
+}
+
+// validRequestID reports whether a client's request ID can be used. IDs
+// must be short and made of characters that can't forge log lines or
+// headers.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ResponseRecorder is a ResponseWriter that remembers the status code and
+// the size of the body written to it. It can still be hijacked and flushed,
+// so that WebSocket upgrades and streamed responses keep working.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	// Status is the status code written, 200 until one is
+	Status int
+	// Bytes is the number of body bytes written
+	Bytes       int
+	wroteHeader bool
+}
+
+// NewResponseRecorder constructs a ResponseRecorder writing to `w`
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+// WriteHeader records the status code and writes it
+func (rr *ResponseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.Status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the body, implying a 200 status if none was written
+func (rr *ResponseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.Bytes += n
+	return n, err
+}
+
+// Flush sends any buffered data to the client
+func (rr *ResponseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack takes over the connection, which is recorded as switching protocols
+func (rr *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && !rr.wroteHeader {
+		rr.Status = http.StatusSwitchingProtocols
+		rr.wroteHeader = true
+	}
+	return conn, rw, err
+}
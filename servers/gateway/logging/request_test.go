@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureDefault logs records of the default logger to the returned buffer
+// for the rest of the test
+func captureDefault(t *testing.T) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(New(buffer, slog.LevelInfo))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return buffer
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	captureDefault(t)
+	var forwarded, logged string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(RequestIDHeader)
+		logged = RequestID(r.Context())
+	}))
+
+	cases := []struct {
+		name     string
+		sent     string
+		expected string
+	}{
+		{"Valid ID", "client-request:42", "client-request:42"},
+		{"No ID", "", ""},
+		{"Invalid Characters", "id\nforged log line", ""},
+		{"Too Long", strings.Repeat("a", maxRequestIDLength+1), ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/v1/users", nil)
+		if len(c.sent) > 0 {
+			req.Header.Set(RequestIDHeader, c.sent)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		echoed := resp.Header().Get(RequestIDHeader)
+		if len(c.expected) > 0 && echoed != c.expected {
+			t.Errorf("%s: expected the client's ID %q to be used, but got %q", c.name, c.expected, echoed)
+		}
+		if len(c.expected) == 0 && (echoed == c.sent || len(echoed) != 32) {
+			t.Errorf("%s: expected a new ID to be generated, but got %q", c.name, echoed)
+		}
+		if forwarded != echoed || logged != echoed {
+			t.Errorf("%s: expected ID %q to be forwarded and logged, but got %q and %q", c.name, echoed, forwarded, logged)
+		}
+	}
+}
+
+func TestMiddlewareAccessLog(t *testing.T) {
+	buffer := captureDefault(t)
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUserID(r.Context(), 7)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/channels/1?secret=query", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	req.Header.Set("Authorization", "Bearer session")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := decodeRecords(t, buffer)
+	if len(records) != 1 {
+		t.Fatalf("expected one access log record, but got %v", records)
+	}
+	record := records[0]
+	expected := map[string]interface{}{
+		"msg":       "request",
+		"method":    "POST",
+		"path":      "/v1/channels/1",
+		"status":    float64(201),
+		"bytes":     float64(7),
+		"userID":    float64(7),
+		"requestID": "abc",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("expected %s to be %v, but got %v", key, value, record[key])
+		}
+	}
+	if _, found := record["durationMs"]; !found {
+		t.Errorf("expected the duration to be logged, but got %v", record)
+	}
+	if strings.Contains(buffer.String(), "secret") || strings.Contains(buffer.String(), "session") {
+		t.Errorf("expected the query and headers not to be logged, but got %s", buffer.String())
+	}
+}
+
+func TestMiddlewareWithoutUser(t *testing.T) {
+	buffer := captureDefault(t)
+	Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	record := decodeRecords(t, buffer)[0]
+	if _, found := record["userID"]; found || record["status"] != float64(404) {
+		t.Errorf("expected a 404 without a user, but got %v", record)
+	}
+}
+
+func TestResponseRecorderHijack(t *testing.T) {
+	var recorder *ResponseRecorder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder = NewResponseRecorder(w)
+		conn, _, err := recorder.Hijack()
+		if err != nil {
+			t.Errorf("expected the connection to be hijackable through the recorder: %v", err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+		conn.Close()
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if recorder.Status != http.StatusSwitchingProtocols {
+		t.Errorf("expected the hijacked response to be recorded as 101, but got %d", recorder.Status)
+	}
+
+	if _, _, err := NewResponseRecorder(httptest.NewRecorder()).Hijack(); err != http.ErrNotSupported {
+		t.Errorf("expected %v from a writer that can't be hijacked, but got %v", http.ErrNotSupported, err)
+	}
+}
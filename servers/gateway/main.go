@@ -1,142 +1,307 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
+	"os/signal"
+	"serverside-final-project/servers/gateway/certs"
 	"serverside-final-project/servers/gateway/handlers"
+	"serverside-final-project/servers/gateway/identity"
+	"serverside-final-project/servers/gateway/logging"
+	"serverside-final-project/servers/gateway/metrics"
+	"serverside-final-project/servers/gateway/migrations"
 	"serverside-final-project/servers/gateway/models/users"
+	"serverside-final-project/servers/gateway/ratelimit"
 	"serverside-final-project/servers/gateway/sessions"
-	"strings"
-	"sync/atomic"
+	"serverside-final-project/servers/gateway/tracing"
+	"serverside-final-project/servers/gateway/upstream"
+	"syscall"
 	"time"
 
 	"github.com/go-redis/redis"
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// commandUsage lists the subcommands of the gateway binary
+const commandUsage = `Usage: gateway [command] [flags]
+
+Without a command, the gateway server is started. Commands:
+  migrate up|down [steps]|status  apply, revert, or list schema migrations
+  create-user                     create a user, reading the password from stdin
+  reset-password -id ID           set a user's password and revoke their sessions
+  suspend-user -id ID [-lift]     suspend a user and revoke their sessions
+  list-sessions [-user ID]        list sessions in redis
+  revoke-sessions -user ID|-sid PREFIX|-all
+  rotate-session-key              generate a new SESSIONKEY and revoke all sessions
+  print-config                    print the effective configuration, with secrets redacted
+
+Run "gateway <command> -h" for the flags of a command.`
+
+// commands maps each subcommand of the gateway binary to the function that
+// runs it with the remaining arguments and returns the process exit code
+var commands = map[string]func(args []string) int{
+	"migrate":            runMigrate,
+	"create-user":        runCreateUser,
+	"reset-password":     runResetPassword,
+	"suspend-user":       runSuspendUser,
+	"list-sessions":      runListSessions,
+	"revoke-sessions":    runRevokeSessions,
+	"rotate-session-key": runRotateSessionKey,
+	"print-config":       runPrintConfig,
+}
+
 // main is the main entry point for the server
 func main() {
-	addr := os.Getenv("ADDR")
-	if len(addr) == 0 {
-		addr = ":443"
+	if len(os.Args) > 1 {
+		command, found := commands[os.Args[1]]
+		if !found {
+			fmt.Println(commandUsage)
+			os.Exit(2)
+		}
+		os.Exit(command(os.Args[2:]))
 	}
 
-	tlsKeyPath := os.Getenv("TLSKEY")
-	tlsCertPath := os.Getenv("TLSCERT")
-	if len(tlsKeyPath) == 0 || len(tlsCertPath) == 0 {
-		err := fmt.Errorf("Environment variables TLSKEY and TLSCERT should be set.\nTLSKEY: %s\nTLSCERT: %s", tlsKeyPath, tlsCertPath)
+	cfg, err := readConfig()
+	if err == nil {
+		err = validateConfig(cfg)
+	}
+	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
-	sessionKey := os.Getenv("SESSIONKEY")
-	reddisAddr := os.Getenv("REDISADDR")
-	dsn := os.Getenv("DSN")
+	// Everything logged through the log package is also written as JSON
+	slog.SetDefault(logging.New(os.Stdout, cfg.Level()))
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     reddisAddr,
-		Password: "",
-		DB:       0,
+	stopTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Endpoint:    cfg.TracingEndpoint,
+		SampleRatio: cfg.TracingSampleRatio,
 	})
-
-	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
-	defer db.Close()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: "",
+		DB:       0,
+	})
 
 	redisStore := sessions.NewRedisStore(redisClient, time.Hour*100000)
-	sqlStore := users.NewMySQLStore(db)
+	redisStore.OperationTimeout = cfg.RedisTimeout
 
-	hctx := handlers.NewContext(sessionKey, redisStore, sqlStore)
+	health := handlers.NewHealthChecker(cfg.HealthCheckTimeout)
+	health.Add("redis", redisStore.Ping)
 
-	mux := http.NewServeMux()
-	wrappedMux := handlers.NewCORSHeader(mux)
+	// Users are only kept in memory when asked to, so that the gateway can
+	// be run locally without MySQL. They are lost when the gateway stops.
+	var userStore users.Store
+	var db *sql.DB
+	if cfg.InMemoryUsers {
+		slog.Warn("Using an in-memory user store, users will be lost when the gateway stops")
+		userStore = users.NewMemStore()
+	} else {
+		db, err = sql.Open("mysql", cfg.DSN)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 
-	stringMessageAddr := strings.Split(os.Getenv("MESSAGESADDR"), ",")
-	stringMeetupAddr := strings.Split(os.Getenv("MEETUPADDR"), ",")
-	if len(stringMessageAddr) == 0 || len(stringMeetupAddr) == 0 {
-		err := fmt.Errorf("Environment variables MESSAGESADDR, and MEETUPADDR should be set.\nMESSAGESADDR: %s\nMEETUPADDR: %s", stringMessageAddr, stringMeetupAddr)
-		fmt.Println(err.Error())
-		os.Exit(1)
-	}
+		sqlStore := users.NewMySQLStore(db)
+		sqlStore.OperationTimeout = cfg.MySQLTimeout
+		userStore = sqlStore
 
-	lenMessage := len(stringMessageAddr)
-	urlMessageAddr := make([]*url.URL, lenMessage)
-	for i, stringURL := range stringMessageAddr {
-		urlAddr, _ := url.Parse(stringURL)
-		urlMessageAddr[i] = urlAddr
+		all, err := migrations.Load()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		health.Add("mysql", db.PingContext)
+		health.Add("migrations", migrations.NewMigrator(db, all).Check)
 	}
 
-	lenMeetup := len(stringMeetupAddr)
-	urlMeetupAddr := make([]*url.URL, lenMeetup)
-	for i, stringURL := range stringMeetupAddr {
-		urlAddr, _ := url.Parse(stringURL)
-		urlMeetupAddr[i] = urlAddr
-	}
+	hctx := handlers.NewContext(cfg.SessionKey, tracing.InstrumentStore(metrics.InstrumentStore(redisStore)), userStore)
+	hctx.WebSocketOrigins = cfg.WebSocketOrigins
+	hctx.WebSocket = webSocketOptions(cfg)
 
-	messageDirector := CustomDirector(urlMessageAddr, sessionKey, redisStore)
-	meetupDirector := CustomDirector(urlMeetupAddr, sessionKey, redisStore)
+	// Rate limits are kept in redis so that they hold across replicas, and
+	// in memory while redis can't be reached
+	limiter := &ratelimit.Fallback{Primary: ratelimit.NewRedisLimiter(redisClient), Secondary: ratelimit.NewMemLimiter()}
 
-	messagingProxy := &httputil.ReverseProxy{Director: messageDirector}
-	meetupProxy := &httputil.ReverseProxy{Director: meetupDirector}
+	mux := http.NewServeMux()
+	cors := handlers.NewCORSHeader(mux, corsPolicy(cfg.CORS, nil), hctx.RateLimit(limiter, rateLimitRules(cfg.RateLimits), mux))
+	cors.Overrides = corsOverrides(cfg.CORS)
+	wrappedMux := tracing.Middleware(mux, logging.Middleware(metrics.Instrument(mux, cors)))
+
+	// Upstream pools probe their backends, and certificate files are watched,
+	// until the gateway shuts down
+	probeCtx, stopProbes := context.WithCancel(context.Background())
+	defer stopProbes()
+	signer := identity.NewSigner([]byte(cfg.IdentityKey))
+	pools := []*upstream.Pool{}
+	for name, service := range cfg.Upstreams {
+		// Validation has already checked that every upstream can build a pool
+		pool, _ := newPool(name, service)
+		pool.Observe = metrics.ObserveUpstream(name)
+		pool.Transport = tracing.Transport(pool.Transport)
+		pool.Start(probeCtx)
+		pools = append(pools, pool)
+		health.Add("upstream:"+name, pool.Check)
+
+		// The messaging service owns the channels WebSocket clients subscribe to
+		if name == "messaging" {
+			hctx.Channels = handlers.NewMessagingAuthorizer(pool, signer)
+		}
 
-	mux.Handle("/v1/channels", messagingProxy)
-	mux.Handle("/v1/channels/", messagingProxy)
-	mux.Handle("/v1/messages/", messagingProxy)
-	mux.Handle("/v1/events", meetupProxy)
-	mux.Handle("/v1/events/", meetupProxy)
+		proxy := hctx.Authenticate(signer, handlers.NewProxy(pool))
+		for _, route := range service.Routes {
+			mux.Handle(route, proxy)
+		}
+	}
 
 	mux.HandleFunc("/v1/users", hctx.UsersHandler)
 	mux.HandleFunc("/v1/users/", hctx.SpecificUserHandler)
+	mux.HandleFunc("/v1/users/availability", hctx.UserAvailabilityHandler)
 	mux.HandleFunc("/v1/sessions", hctx.SessionsHandler)
 	mux.HandleFunc("/v1/sessions/", hctx.SpecificSessionHandler)
 
-	handlers.ReadIncomingMessagesFromRabbit()
+	consumer, err := handlers.ReadIncomingMessagesFromRabbit(cfg.RabbitMQURL, cfg.RabbitMQQueue)
+	if err != nil {
+		slog.Error("Error starting RabbitMQ consumer", "error", err)
+		os.Exit(1)
+	}
 	mux.HandleFunc("/v1/ws", hctx.WebSocketConnectionHandler)
+	metrics.WebSocketConnections(handlers.ActiveWebSockets)
 
-	log.Printf("Server is listening at %s...", addr)
-	log.Fatal(http.ListenAndServeTLS(addr, tlsCertPath, tlsKeyPath, wrappedMux))
-}
-
-// Director represents a director function
-type Director func(r *http.Request)
+	health.Add("rabbitmq", consumer.Check)
+	mux.HandleFunc("/healthz", health.LivenessHandler)
+	mux.HandleFunc("/readyz", health.ReadinessHandler)
 
-// CustomDirector returns a director function that will be executed in a reverse proxy call
-func CustomDirector(targets []*url.URL, sessionKey string, redisstore *sessions.RedisStore) Director {
-	var counter int32
-	counter = 0
-	return func(r *http.Request) {
-		_, err := sessions.GetSessionID(r, sessionKey)
+	// Certificates are obtained from the ACME CA when domains are given, and
+	// are otherwise read from files that are reloaded when they are renewed
+	var source certs.Source
+	redirect := certs.Redirect(cfg.Addr)
+	if len(cfg.ACMEDomains) > 0 {
+		manager, err := certs.NewManager(acmeOptions(cfg))
 		if err != nil {
-			r.Header["X-User"] = nil
-		} else {
-			sessionState := &handlers.SessionState{}
-			sessions.GetState(r, sessionKey, redisstore, sessionState)
-			user := sessionState.User
-			bytes, _ := json.Marshal(user)
-			r.Header.Add("X-User", string(bytes[:]))
+			fmt.Println(err.Error())
+			os.Exit(1)
 		}
+		source = manager
+		// Plain HTTP requests are redirected unless they answer a challenge
+		redirect = manager.HTTPHandler(redirect)
+	} else {
+		reloader, err := certs.NewReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		go reloader.Watch(probeCtx, cfg.TLSReloadInterval)
+		source = reloader
+	}
+
+	server := &http.Server{
+		Addr:      cfg.Addr,
+		Handler:   wrappedMux,
+		TLSConfig: certs.TLSConfig(source, tlsVersion(cfg), cipherSuites(cfg)),
+	}
+
+	serverErrors := make(chan error, 3)
+	go func() {
+		slog.Info("Server is listening", "addr", cfg.Addr)
+		serverErrors <- server.ListenAndServeTLS("", "")
+	}()
+	servers := []*http.Server{server}
+
+	if len(cfg.HTTPAddr) > 0 {
+		httpServer := &http.Server{Addr: cfg.HTTPAddr, Handler: redirect}
+		servers = append(servers, httpServer)
+		go func() {
+			slog.Info("HTTP redirect server is listening", "addr", cfg.HTTPAddr)
+			serverErrors <- httpServer.ListenAndServe()
+		}()
+	}
 
-		targ := targets[counter%int32(len(targets))]
-		atomic.AddInt32(&counter, 1) // note, to be extra safe, we’ll need to use mutexes
-		if len(targ.Host) > 0 {
-			r.Host = targ.Host
-			r.URL.Host = targ.Host
-		} else {
-			r.Host = targ.String()
-			r.URL.Host = targ.String()
+	// Operational endpoints are served on a separate internal listener so
+	// that they are never exposed to clients
+	if len(cfg.AdminAddr) > 0 {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/upstreams", &handlers.UpstreamsHandler{Pools: pools})
+		adminMux.Handle("/metrics", metrics.Handler())
+		adminServer := &http.Server{Addr: cfg.AdminAddr, Handler: adminMux}
+		servers = append(servers, adminServer)
+		go func() {
+			slog.Info("Admin server is listening", "addr", cfg.AdminAddr)
+			serverErrors <- adminServer.ListenAndServe()
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serverErrors:
+		slog.Error("Server stopped unexpectedly", "error", err)
+		os.Exit(1)
+	case sig := <-signals:
+		slog.Info("Shutting down", "signal", sig.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	shutdown(ctx, servers, consumer, redisClient, db)
+	if err := stopTracing(ctx); err != nil {
+		slog.Error("Error exporting remaining trace spans", "error", err)
+	}
+}
+
+// consumerCancelTimeout bounds how long the RabbitMQ consumer is given to
+// fan out the messages it has already received when shutting down
+const consumerCancelTimeout = 5 * time.Second
+
+// shutdown stops the servers in dependency order: the gateway stops
+// consuming from RabbitMQ, then the servers stop accepting connections and
+// wait for in-flight requests while WebSocket connections are closed, until
+// `ctx` is done, and finally the connections to RabbitMQ, redis and MySQL
+// that requests may have been using are closed
+func shutdown(ctx context.Context, servers []*http.Server, consumer *handlers.RabbitConsumer, redisClient *redis.Client, db *sql.DB) {
+	// Messages must not be consumed once WebSocket connections are closed,
+	// or they would be acknowledged without anyone to send them to. The
+	// consumer has its own deadline, so that it's given time whatever the
+	// servers take.
+	cancelCtx, cancel := context.WithTimeout(context.Background(), consumerCancelTimeout)
+	if err := consumer.Cancel(cancelCtx); err != nil {
+		slog.Error("Error cancelling RabbitMQ consumer", "error", err)
+	}
+	cancel()
+
+	// WebSocket connections are hijacked, so Shutdown doesn't wait for them
+	// and they must be told to go away separately
+	webSockets := make(chan error, 1)
+	go func() {
+		webSockets <- handlers.ShutdownWebSockets(ctx)
+	}()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Error waiting for in-flight requests", "addr", server.Addr, "error", err)
 		}
-		if len(targ.Scheme) > 0 {
-			r.URL.Scheme = targ.Scheme
-		} else {
-			r.URL.Scheme = "http"
+	}
+	if err := <-webSockets; err != nil {
+		slog.Error("Error closing WebSocket connections", "error", err)
+	}
+	if err := consumer.Close(); err != nil {
+		slog.Error("Error closing RabbitMQ connection", "error", err)
+	}
+	if err := redisClient.Close(); err != nil {
+		slog.Error("Error closing redis client", "error", err)
+	}
+	if db != nil {
+		if err := db.Close(); err != nil {
+			slog.Error("Error closing MySQL pool", "error", err)
 		}
 	}
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"net/http"
+	"serverside-final-project/servers/gateway/logging"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute is the route label of requests that match no route, so that
+// arbitrary paths can't create new series
+const unmatchedRoute = "unmatched"
+
+// otherMethod is the method label of requests with a nonstandard method, so
+// that arbitrary methods can't create new series
+const otherMethod = "other"
+
+// standardMethods are the request methods that are labelled as themselves
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Instrument returns middleware that counts and times the requests handled
+// by `next`, labelled with the pattern of the route in `routes` that each
+// request matches
+func Instrument(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := unmatchedRoute
+		if _, pattern := routes.Handler(r); len(pattern) > 0 {
+			route = pattern
+		}
+
+		start := time.Now()
+		recorder := logging.NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		code := strconv.Itoa(recorder.Status)
+		method := methodLabel(r.Method)
+		requests.WithLabelValues(route, method, code).Inc()
+		requestDuration.WithLabelValues(route, method, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel returns the method label of a `method` request
+func methodLabel(method string) string {
+	if standardMethods[method] {
+		return method
+	}
+	return otherMethod
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrument(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/channels/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	handler := Instrument(mux, mux)
+
+	cases := []struct {
+		method string
+		path   string
+		route  string
+		code   string
+	}{
+		{"POST", "/v1/channels/1", "/v1/channels/", "201"},
+		{"POST", "/v1/channels/2", "/v1/channels/", "201"},
+		{"GET", "/v1/users", "/v1/users", "200"},
+		{"GET", "/v1/unknown/12345", unmatchedRoute, "404"},
+	}
+	before := make([]float64, len(cases))
+	for i, c := range cases {
+		before[i] = testutil.ToFloat64(requests.WithLabelValues(c.route, c.method, c.code))
+	}
+	for _, c := range cases {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(c.method, c.path, nil))
+	}
+
+	expected := []float64{2, 2, 1, 1}
+	for i, c := range cases {
+		if delta := testutil.ToFloat64(requests.WithLabelValues(c.route, c.method, c.code)) - before[i]; delta != expected[i] {
+			t.Errorf("%s %s: expected %v requests labelled %s %s, but got %v", c.method, c.path, expected[i], c.route, c.code, delta)
+		}
+	}
+	if count := testutil.CollectAndCount(requestDuration); count == 0 {
+		t.Error("expected request durations to be observed")
+	}
+}
+
+func TestInstrumentMethods(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	handler := Instrument(mux, mux)
+
+	before := testutil.ToFloat64(requests.WithLabelValues("/v1/users", otherMethod, "200"))
+	for _, method := range []string{"FOOBAR", "PROPFIND"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/v1/users", nil))
+	}
+	if delta := testutil.ToFloat64(requests.WithLabelValues("/v1/users", otherMethod, "200")) - before; delta != 2 {
+		t.Errorf("expected 2 requests labelled %s, but got %v", otherMethod, delta)
+	}
+	if requests.DeleteLabelValues("/v1/users", "FOOBAR", "200") {
+		t.Error("expected a nonstandard method not to be used as a label")
+	}
+}
+
+func TestInstrumentHijack(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("expected the connection to be hijackable through the middleware: %v", err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+		conn.Close()
+	})
+	server := httptest.NewServer(Instrument(mux, mux))
+	defer server.Close()
+
+	before := testutil.ToFloat64(requests.WithLabelValues("/v1/ws", "GET", "101"))
+	resp, err := http.Get(server.URL + "/v1/ws")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	// The middleware records the request once the handler returns
+	server.Close()
+	if delta := testutil.ToFloat64(requests.WithLabelValues("/v1/ws", "GET", "101")) - before; delta != 1 {
+		t.Errorf("expected the hijacked request to be recorded as 101, but got %v", delta)
+	}
+}
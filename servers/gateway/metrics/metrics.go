@@ -0,0 +1,142 @@
+// Package metrics collects the gateway's Prometheus metrics and serves them
+// for scraping. Metrics are kept in their own Registry rather than the
+// global one, so that only the gateway's own metrics and the Go runtime and
+// process metrics are exposed.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the name of every metric
+const namespace = "gateway"
+
+// Registry holds every metric the gateway exposes
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests handled, by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle requests, by route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time taken by backends to respond to proxied requests, by upstream, backend and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream", "backend", "code"})
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Proxied requests that failed to reach a backend or got a 502, 503 or 504, by upstream and backend.",
+	}, []string{"upstream", "backend"})
+
+	sessionStoreDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "session_store_duration_seconds",
+		Help:      "Time taken by session store operations, by operation and result.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "result"})
+
+	messagesConsumed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_messages_consumed_total",
+		Help:      "Messages consumed from RabbitMQ.",
+	})
+	messagesFannedOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_messages_fanned_out_total",
+		Help:      "Consumed messages queued for WebSocket connections, by result.",
+	}, []string{"result"})
+
+	webSocketsReaped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections_reaped_total",
+		Help:      "WebSocket connections closed by the gateway because they were dead, too slow or misbehaving, by reason.",
+	}, []string{"reason"})
+
+	signIns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sign_ins_total",
+		Help:      "Sign in attempts, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		requestDuration,
+		upstreamDuration,
+		upstreamErrors,
+		sessionStoreDuration,
+		messagesConsumed,
+		messagesFannedOut,
+		webSocketsReaped,
+		signIns,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// WebSocketConnections registers a gauge of the active WebSocket
+// connections, as counted by `count` each time metrics are scraped
+func WebSocketConnections(count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Active WebSocket connections.",
+	}, func() float64 {
+		return float64(count())
+	}))
+}
+
+// MessageConsumed counts a message consumed from RabbitMQ
+func MessageConsumed() {
+	messagesConsumed.Inc()
+}
+
+// MessageFannedOut counts a consumed message queued for a WebSocket
+// connection, which failed if `err` is not nil
+func MessageFannedOut(err error) {
+	messagesFannedOut.WithLabelValues(result(err)).Inc()
+}
+
+// WebSocketReaped counts a WebSocket connection closed by the gateway for
+// `reason`, such as a client that stopped answering pings
+func WebSocketReaped(reason string) {
+	webSocketsReaped.WithLabelValues(reason).Inc()
+}
+
+// SignIn counts a sign in attempt, which succeeded if `success` is true
+func SignIn(success bool) {
+	if success {
+		signIns.WithLabelValues("success").Inc()
+	} else {
+		signIns.WithLabelValues("failure").Inc()
+	}
+}
+
+// result returns the result label of an operation that returned `err`
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCounters(t *testing.T) {
+	successes := testutil.ToFloat64(signIns.WithLabelValues("success"))
+	failures := testutil.ToFloat64(signIns.WithLabelValues("failure"))
+	SignIn(true)
+	SignIn(false)
+	SignIn(false)
+	if delta := testutil.ToFloat64(signIns.WithLabelValues("success")) - successes; delta != 1 {
+		t.Errorf("expected 1 successful sign in, but got %v", delta)
+	}
+	if delta := testutil.ToFloat64(signIns.WithLabelValues("failure")) - failures; delta != 2 {
+		t.Errorf("expected 2 failed sign ins, but got %v", delta)
+	}
+
+	consumed := testutil.ToFloat64(messagesConsumed)
+	written := testutil.ToFloat64(messagesFannedOut.WithLabelValues("ok"))
+	failed := testutil.ToFloat64(messagesFannedOut.WithLabelValues("error"))
+	MessageConsumed()
+	MessageFannedOut(nil)
+	MessageFannedOut(nil)
+	MessageFannedOut(errors.New("broken pipe"))
+	if delta := testutil.ToFloat64(messagesConsumed) - consumed; delta != 1 {
+		t.Errorf("expected 1 consumed message, but got %v", delta)
+	}
+	if delta := testutil.ToFloat64(messagesFannedOut.WithLabelValues("ok")) - written; delta != 2 {
+		t.Errorf("expected 2 messages fanned out, but got %v", delta)
+	}
+	if delta := testutil.ToFloat64(messagesFannedOut.WithLabelValues("error")) - failed; delta != 1 {
+		t.Errorf("expected 1 failed fan out, but got %v", delta)
+	}
+
+	reaped := testutil.ToFloat64(webSocketsReaped.WithLabelValues("pong_timeout"))
+	WebSocketReaped("pong_timeout")
+	if delta := testutil.ToFloat64(webSocketsReaped.WithLabelValues("pong_timeout")) - reaped; delta != 1 {
+		t.Errorf("expected 1 reaped connection, but got %v", delta)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	connections := 3
+	WebSocketConnections(func() int { return connections })
+	SignIn(true)
+
+	resp := httptest.NewRecorder()
+	Handler().ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	for _, expected := range []string{
+		"gateway_websocket_connections 3",
+		`gateway_sign_ins_total{result="success"}`,
+		"go_goroutines",
+		"process_start_time_seconds",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %q in the metrics:\n%s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"serverside-final-project/servers/gateway/sessions"
+	"time"
+)
+
+// instrumentedStore is a sessions.Store that times the operations of the
+// Store it wraps
+type instrumentedStore struct {
+	store sessions.Store
+}
+
+// InstrumentStore returns a sessions.Store that times every operation of
+// `store` before returning its result
+func InstrumentStore(store sessions.Store) sessions.Store {
+	return &instrumentedStore{store}
+}
+
+// Save saves the session state to the wrapped store
+func (is *instrumentedStore) Save(ctx context.Context, sid sessions.SessionID, sessionState interface{}) error {
+	start := time.Now()
+	err := is.store.Save(ctx, sid, sessionState)
+	observeStore("save", start, err)
+	return err
+}
+
+// Get gets the session state from the wrapped store
+func (is *instrumentedStore) Get(ctx context.Context, sid sessions.SessionID, sessionState interface{}) error {
+	start := time.Now()
+	err := is.store.Get(ctx, sid, sessionState)
+	observeStore("get", start, err)
+	return err
+}
+
+// Delete deletes the session state from the wrapped store
+func (is *instrumentedStore) Delete(ctx context.Context, sid sessions.SessionID) error {
+	start := time.Now()
+	err := is.store.Delete(ctx, sid)
+	observeStore("delete", start, err)
+	return err
+}
+
+// observeStore records how long an `operation` that started at `start` and
+// returned `err` took. Sessions that aren't found are not errors of the store.
+func observeStore(operation string, start time.Time, err error) {
+	label := result(err)
+	if err == sessions.ErrStateNotFound {
+		label = "not_found"
+	}
+	sessionStoreDuration.WithLabelValues(operation, label).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"context"
+	"serverside-final-project/servers/gateway/sessions"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentStore(t *testing.T) {
+	store := InstrumentStore(sessions.NewMemStore(time.Hour, time.Hour))
+	ctx := context.Background()
+	sid, err := sessions.NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating session ID: %v", err)
+	}
+
+	state := ""
+	if err := store.Get(ctx, sid, &state); err != sessions.ErrStateNotFound {
+		t.Errorf("expected %v from the wrapped store, but got %v", sessions.ErrStateNotFound, err)
+	}
+	if err := store.Save(ctx, sid, "state"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Get(ctx, sid, &state); err != nil || state != "state" {
+		t.Errorf("expected the saved state from the wrapped store, but got %q (%v)", state, err)
+	}
+	store.Delete(ctx, sid)
+
+	// One series for each of get not_found, save ok, get ok and delete ok
+	if count := testutil.CollectAndCount(sessionStoreDuration); count != 4 {
+		t.Errorf("expected 4 session store latency series, but got %d", count)
+	}
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http"
+	"serverside-final-project/servers/gateway/upstream"
+	"strconv"
+	"time"
+)
+
+// ObserveUpstream returns an observer for the Pool of the upstream named
+// `name` that times the requests sent to each backend and counts those that
+// fail. Failures are counted as the pool counts them towards ejecting a
+// backend: errors reaching it, and 502, 503 and 504 responses.
+func ObserveUpstream(name string) func(backend *upstream.Backend, took time.Duration, resp *http.Response, err error) {
+	return func(backend *upstream.Backend, took time.Duration, resp *http.Response, err error) {
+		host := backend.URL.Host
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				upstreamErrors.WithLabelValues(name, host).Inc()
+			}
+		} else {
+			upstreamErrors.WithLabelValues(name, host).Inc()
+		}
+		upstreamDuration.WithLabelValues(name, host, code).Observe(took.Seconds())
+	}
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"serverside-final-project/servers/gateway/upstream"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveUpstream(t *testing.T) {
+	target, _ := url.Parse("http://meetup1:80")
+	pool, err := upstream.NewPool("meetup", []*url.URL{target}, upstream.Options{})
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	backend := pool.Backends[0]
+	observe := ObserveUpstream("meetup")
+
+	observe(backend, 10*time.Millisecond, &http.Response{StatusCode: http.StatusOK}, nil)
+	observe(backend, 10*time.Millisecond, &http.Response{StatusCode: http.StatusNotFound}, nil)
+	observe(backend, time.Second, &http.Response{StatusCode: http.StatusServiceUnavailable}, nil)
+	observe(backend, 5*time.Second, nil, errors.New("connection refused"))
+
+	if errs := testutil.ToFloat64(upstreamErrors.WithLabelValues("meetup", "meetup1:80")); errs != 2 {
+		t.Errorf("expected the 503 and the connection error to be counted, but got %v errors", errs)
+	}
+	// One series for each of 200, 404, 503 and error
+	if count := testutil.CollectAndCount(upstreamDuration); count != 4 {
+		t.Errorf("expected 4 latency series, but got %d", count)
+	}
+}
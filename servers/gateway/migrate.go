@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"serverside-final-project/servers/gateway/migrations"
+	"strconv"
+)
+
+// migrateUsage describes the arguments accepted by the migrate subcommand
+const migrateUsage = "Usage: gateway migrate up|down [steps]|status"
+
+// runMigrate runs the migrate subcommand against the configured database
+// and returns the exit code for the process
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		return 2
+	}
+
+	cfg, err := readConfig()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if len(cfg.DSN) == 0 {
+		fmt.Println("DSN should be set to migrate the database")
+		return 1
+	}
+	db, err := sql.Open("mysql", cfg.DSN)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer db.Close()
+
+	all, err := migrations.Load()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	migrator := migrations.NewMigrator(db, all)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Println(migrateUsage)
+				return 2
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05") + " UTC"
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Migration.Version, status.Migration.Name, applied)
+		}
+	default:
+		fmt.Println(migrateUsage)
+		return 2
+	}
+	return 0
+}
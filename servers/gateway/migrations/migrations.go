@@ -0,0 +1,368 @@
+// Package migrations manages versioned changes to the MySQL schema. Each
+// migration is a pair of SQL files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql that are embedded in the gateway binary. Applied
+// versions are recorded in the schema_migrations table, and a MySQL advisory
+// lock ensures only one gateway replica migrates the database at a time.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// DefaultLockName is the name of the MySQL advisory lock held while migrating
+const DefaultLockName = "gateway_schema_migrations"
+
+// DefaultLockTimeout is how long to wait for another replica to finish migrating
+const DefaultLockTimeout = time.Minute
+
+// DefaultBaselineTable is a table created by the first migration that also
+// exists in databases built by servers/db/schema.sql before migrations were
+// introduced
+const DefaultBaselineTable = "Users"
+
+// createTableStatement creates the table used to record applied migrations
+const createTableStatement = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    applied_at DATETIME NOT NULL,
+    PRIMARY KEY (version)
+)`
+
+// fileNamePattern matches migration file names such as 0001_initial_schema.up.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrLockTimeout is returned when the advisory lock could not be acquired in time
+var ErrLockTimeout = errors.New("timed out waiting for another migration to finish")
+
+// Migration represents a single versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Status reports whether a migration has been applied, and when
+type Status struct {
+	Migration *Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load returns the migrations embedded in the gateway binary, ordered by version
+func Load() ([]*Migration, error) {
+	return Parse(embedded, "sql")
+}
+
+// Parse reads the migration files in `dir` of `fsys` and returns the
+// migrations ordered by version. Every migration must have both an up
+// and a down file.
+func Parse(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading migrations: %v", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("Invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		name, direction := match[2], match[3]
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("Migration %d has two names: %s and %s", version, migration.Name, name)
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading migration %s: %v", entry.Name(), err)
+		}
+		if direction == "up" {
+			migration.Up = splitStatements(string(contents))
+		} else {
+			migration.Down = splitStatements(string(contents))
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			return nil, fmt.Errorf("Migration %d_%s must have non-empty up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// splitStatements splits the contents of a migration file into individual
+// statements, since the MySQL driver only runs one statement per call. A
+// statement ends at a line ending in a semicolon, and "--" comment lines are
+// dropped.
+func splitStatements(contents string) []string {
+	statements := []string{}
+	current := []string{}
+	for _, line := range strings.Split(contents, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		if strings.HasSuffix(trimmed, ";") {
+			current = append(current, strings.TrimSuffix(strings.TrimRight(line, " \t\r"), ";"))
+			statements = append(statements, strings.Join(current, "\n"))
+			current = []string{}
+		} else {
+			current = append(current, strings.TrimRight(line, "\r"))
+		}
+	}
+	if len(current) > 0 {
+		statements = append(statements, strings.Join(current, "\n"))
+	}
+	return statements
+}
+
+// Migrator applies and reverts migrations against a MySQL database
+type Migrator struct {
+	DB          *sql.DB
+	Migrations  []*Migration
+	LockName    string
+	LockTimeout time.Duration
+	// BaselineTable is a table whose presence in a database without any
+	// recorded migrations means the first migration's schema is already in
+	// place, or empty to always run the first migration
+	BaselineTable string
+}
+
+// NewMigrator constructs a new Migrator for the given migrations
+func NewMigrator(db *sql.DB, migrations []*Migration) *Migrator {
+	return &Migrator{db, migrations, DefaultLockName, DefaultLockTimeout, DefaultBaselineTable}
+}
+
+// Up applies every pending migration in version order and returns the
+// migrations that were applied. When no migration has been recorded but
+// the BaselineTable exists, the first migration is recorded as applied
+// without being run, so that databases built before migrations were
+// introduced are adopted.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	applied := []*Migration{}
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		baseline, err := m.needsBaseline(ctx, conn, statuses)
+		if err != nil {
+			return err
+		}
+		for i, status := range statuses {
+			if status.Applied {
+				continue
+			}
+			migration := status.Migration
+			// A baselined database already has the first migration's schema
+			if i > 0 || !baseline {
+				if err := execAll(ctx, conn, migration.Up); err != nil {
+					return fmt.Errorf("Error applying migration %d_%s: %v", migration.Version, migration.Name, err)
+				}
+			}
+			_, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, ?, UTC_TIMESTAMP())",
+				migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("Error recording migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the `steps` most recently applied migrations, newest first,
+// and returns the migrations that were reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	reverted := []*Migration{}
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+			if !statuses[i].Applied {
+				continue
+			}
+			migration := statuses[i].Migration
+			if err := execAll(ctx, conn, migration.Down); err != nil {
+				return fmt.Errorf("Error reverting migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version); err != nil {
+				return fmt.Errorf("Error recording revert of migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status reports whether each known migration has been applied, in version order
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to database: %v", err)
+	}
+	defer conn.Close()
+	return m.status(ctx, conn)
+}
+
+// Pending returns the migrations that have not been applied yet
+func (m *Migrator) Pending(ctx context.Context) ([]*Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := []*Migration{}
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Check returns an error if any migration is pending, so that a gateway
+// isn't sent traffic before the schema it expects is in place
+func (m *Migrator) Check(ctx context.Context) error {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		names := make([]string, len(pending))
+		for i, migration := range pending {
+			names[i] = fmt.Sprintf("%04d_%s", migration.Version, migration.Name)
+		}
+		return fmt.Errorf("%d migrations pending: %s", len(pending), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// status reads the applied versions using `conn` and matches them against
+// the known migrations. Applied versions that are not known are an error,
+// since they mean the database is newer than this binary.
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]*Status, error) {
+	if _, err := conn.ExecContext(ctx, createTableStatement); err != nil {
+		return nil, fmt.Errorf("Error creating schema_migrations table: %v", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, DATE_FORMAT(applied_at, '%Y-%m-%d %H:%i:%s') FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("Error selecting applied migrations: %v", err)
+	}
+	defer rows.Close()
+
+	appliedAt := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var timestamp string
+		if err := rows.Scan(&version, &timestamp); err != nil {
+			return nil, fmt.Errorf("Error scanning applied migration: %v", err)
+		}
+		appliedAt[version], _ = time.Parse("2006-01-02 15:04:05", timestamp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error fetching applied migrations: %v", err)
+	}
+
+	statuses := make([]*Status, len(m.Migrations))
+	for i, migration := range m.Migrations {
+		at, applied := appliedAt[migration.Version]
+		statuses[i] = &Status{migration, applied, at}
+		delete(appliedAt, migration.Version)
+	}
+	for version := range appliedAt {
+		return nil, fmt.Errorf("Database has unknown migration %d applied, is this gateway out of date?", version)
+	}
+	return statuses, nil
+}
+
+// needsBaseline reports whether the first migration should be recorded
+// without being run, because no migration has been recorded yet but the
+// BaselineTable already exists
+func (m *Migrator) needsBaseline(ctx context.Context, conn *sql.Conn, statuses []*Status) (bool, error) {
+	if len(m.BaselineTable) == 0 {
+		return false, nil
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			return false, nil
+		}
+	}
+
+	var tables int
+	err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?",
+		m.BaselineTable).Scan(&tables)
+	if err != nil {
+		return false, fmt.Errorf("Error checking for existing %s table: %v", m.BaselineTable, err)
+	}
+	return tables > 0, nil
+}
+
+// withLock runs `fn` on a single connection while holding the advisory lock.
+// MySQL advisory locks belong to a connection, so everything that must be
+// serialized across replicas has to run on `conn`.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("Error connecting to database: %v", err)
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	timeout := int64(m.LockTimeout / time.Second)
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", m.LockName, timeout).Scan(&acquired); err != nil {
+		return fmt.Errorf("Error acquiring migration lock: %v", err)
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return ErrLockTimeout
+	}
+	// Release with a fresh context so the lock is freed even if ctx was cancelled
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", m.LockName)
+
+	return fn(conn)
+}
+
+// execAll runs each statement in order, stopping at the first error
+func execAll(ctx context.Context, conn *sql.Conn, statements []string) error {
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package migrations
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Unexpected error loading embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 || migrations[0].Name != "initial_schema" {
+		t.Fatalf("Expected the first migration to be 0001_initial_schema, but got %+v", migrations)
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Errorf("Expected migrations in version order, but %d follows %d", migrations[i].Version, migrations[i-1].Version)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name        string
+		files       fstest.MapFS
+		expected    []*Migration
+		expectError bool
+	}{
+		{
+			"Ordered By Version",
+			fstest.MapFS{
+				"sql/0010_later.up.sql":   {Data: []byte("CREATE TABLE B (ID INT);")},
+				"sql/0010_later.down.sql": {Data: []byte("DROP TABLE B;")},
+				"sql/0002_first.up.sql":   {Data: []byte("CREATE TABLE A (ID INT);")},
+				"sql/0002_first.down.sql": {Data: []byte("DROP TABLE A;")},
+			},
+			[]*Migration{
+				{2, "first", []string{"CREATE TABLE A (ID INT)"}, []string{"DROP TABLE A"}},
+				{10, "later", []string{"CREATE TABLE B (ID INT)"}, []string{"DROP TABLE B"}},
+			},
+			false,
+		},
+		{
+			"Invalid File Name",
+			fstest.MapFS{"sql/first.sql": {Data: []byte("SELECT 1;")}},
+			nil,
+			true,
+		},
+		{
+			"Missing Down File",
+			fstest.MapFS{"sql/0001_first.up.sql": {Data: []byte("CREATE TABLE A (ID INT);")}},
+			nil,
+			true,
+		},
+		{
+			"Conflicting Names",
+			fstest.MapFS{
+				"sql/0001_first.up.sql":   {Data: []byte("CREATE TABLE A (ID INT);")},
+				"sql/0001_other.down.sql": {Data: []byte("DROP TABLE A;")},
+			},
+			nil,
+			true,
+		},
+	}
+
+	for _, c := range cases {
+		migrations, err := Parse(c.files, "sql")
+		if c.expectError {
+			if err == nil {
+				t.Errorf("case %s: Expected error but got none", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %s: Unexpected error: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(migrations, c.expected) {
+			t.Errorf("case %s: Expected %+v, but got %+v", c.name, c.expected, migrations)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	contents := `-- Creates the first table
+CREATE TABLE A (
+    ID INT NOT NULL
+);
+
+INSERT INTO A (ID) VALUES (1);
+-- trailing comment
+SELECT 1`
+	expected := []string{
+		"CREATE TABLE A (\n    ID INT NOT NULL\n)",
+		"INSERT INTO A (ID) VALUES (1)",
+		"SELECT 1",
+	}
+	if statements := splitStatements(contents); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected %q, but got %q", expected, statements)
+	}
+}
+
+// testMigrations returns two single-statement migrations for the Migrator tests
+func testMigrations() []*Migration {
+	return []*Migration{
+		{1, "first", []string{"CREATE TABLE A (ID INT)"}, []string{"DROP TABLE A"}},
+		{2, "second", []string{"CREATE TABLE B (ID INT)"}, []string{"DROP TABLE B"}},
+	}
+}
+
+// expectStatus sets up the expectations for reading the applied versions
+func expectStatus(mock sqlmock.Sqlmock, appliedVersions ...int64) {
+	mock.ExpectExec(regexp.QuoteMeta(createTableStatement)).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, version := range appliedVersions {
+		rows.AddRow(version, "2020-06-01 12:30:00")
+	}
+	mock.ExpectQuery("SELECT version").WillReturnRows(rows)
+}
+
+// expectBaselineTable sets up the expectation for checking whether the
+// baseline table exists
+func expectBaselineTable(mock sqlmock.Sqlmock, exists bool) {
+	count := 0
+	if exists {
+		count = 1
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM information_schema.tables")).
+		WithArgs(DefaultBaselineTable).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
+// expectLock sets up the expectation for acquiring the advisory lock
+func expectLock(mock sqlmock.Sqlmock, acquired int) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT GET_LOCK(?, ?)")).
+		WithArgs(DefaultLockName, 60).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(acquired))
+}
+
+func TestUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("There was a problem opening a database connection: [%v]", err)
+	}
+	defer db.Close()
+
+	expectLock(mock, 1)
+	expectStatus(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE B (ID INT)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations")).
+		WithArgs(2, "second").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT RELEASE_LOCK(?)")).
+		WithArgs(DefaultLockName).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := NewMigrator(db, testMigrations()).Up(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(applied) != 1 || applied[0].Version != 2 {
+		t.Errorf("Expected only migration 2 to be applied, but got %+v", applied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+// A database built before migrations were introduced has the first
+// migration's tables but no recorded migrations, so the first migration is
+// recorded without being run
+func TestUpBaseline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("There was a problem opening a database connection: [%v]", err)
+	}
+	defer db.Close()
+
+	expectLock(mock, 1)
+	expectStatus(mock)
+	expectBaselineTable(mock, true)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations")).
+		WithArgs(1, "first").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE B (ID INT)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations")).
+		WithArgs(2, "second").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT RELEASE_LOCK(?)")).
+		WithArgs(DefaultLockName).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := NewMigrator(db, testMigrations()).Up(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("Expected both migrations to be recorded, but got %+v", applied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("There was a problem opening a database connection: [%v]", err)
+	}
+	defer db.Close()
+
+	expectLock(mock, 1)
+	expectStatus(mock)
+	expectBaselineTable(mock, false)
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE A (ID INT)")).WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectExec(regexp.QuoteMeta("SELECT RELEASE_LOCK(?)")).
+		WithArgs(DefaultLockName).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := NewMigrator(db, testMigrations()).Up(context.Background())
+	if err == nil {
+		t.Error("Expected error applying a failing migration but got none")
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations to be applied, but got %+v", applied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpLockTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("There was a problem opening a database connection: [%v]", err)
+	}
+	defer db.Close()
+
+	// GET_LOCK returns 0 when another connection held the lock until the timeout
+	expectLock(mock, 0)
+
+	if _, err := NewMigrator(db, testMigrations()).Up(context.Background()); err != ErrLockTimeout {
+		t.Errorf("Expected %v, but got %v instead", ErrLockTimeout, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("There was a problem opening a database connection: [%v]", err)
+	}
+	defer db.Close()
+
+	expectLock(mock, 1)
+	expectStatus(mock, 1, 2)
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE B")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations WHERE version = ?")).
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT RELEASE_LOCK(?)")).
+		WithArgs(DefaultLockName).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reverted, err := NewMigrator(db, testMigrations()).Down(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != 2 {
+		t.Errorf("Expected only migration 2 to be reverted, but got %+v", reverted)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("There was a problem opening a database connection: [%v]", err)
+	}
+	defer db.Close()
+
+	expectStatus(mock, 1)
+	statuses, err := NewMigrator(db, testMigrations()).Status(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	appliedAt := time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC)
+	if len(statuses) != 2 || !statuses[0].Applied || !statuses[0].AppliedAt.Equal(appliedAt) || statuses[1].Applied {
+		t.Errorf("Expected migration 1 applied and 2 pending, but got %+v, %+v", statuses[0], statuses[1])
+	}
+
+	// A version this binary doesn't know about means the database is newer
+	expectStatus(mock, 1, 3)
+	if _, err := NewMigrator(db, testMigrations()).Pending(context.Background()); err == nil {
+		t.Error("Expected error for an unknown applied migration but got none")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("There was a problem opening a database connection: [%v]", err)
+	}
+	defer db.Close()
+	migrator := NewMigrator(db, testMigrations())
+
+	expectStatus(mock, 1)
+	if err := migrator.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "0002_second") {
+		t.Errorf("Expected error naming the pending migration, but got %v", err)
+	}
+
+	expectStatus(mock, 1, 2)
+	if err := migrator.Check(context.Background()); err != nil {
+		t.Errorf("Unexpected error when every migration is applied: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,7 @@
+DROP TABLE UsersJoinEvents;
+DROP TABLE Messages;
+DROP TABLE ChannelsJoinMembers;
+DROP TABLE Events;
+DROP TABLE Channels;
+DROP TABLE UserSignInLog;
+DROP TABLE Users;
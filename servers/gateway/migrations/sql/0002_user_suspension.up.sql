@@ -0,0 +1,2 @@
+-- Suspended users can't sign in. Suspension is managed with `gateway suspend-user`.
+ALTER TABLE Users ADD COLUMN Suspended BOOLEAN NOT NULL DEFAULT FALSE;
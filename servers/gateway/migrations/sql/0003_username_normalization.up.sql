@@ -0,0 +1,9 @@
+-- User names are also stored as users.NormalizeUserName computes them, lower
+-- case with letters of other scripts that look like Latin ones folded, so
+-- that names that only differ by them can't both be registered. The column is
+-- added as nullable, filled in for existing users, then made required and
+-- unique. This fails if existing users have names that normalize alike.
+ALTER TABLE Users ADD COLUMN NormalizedUserName VARCHAR(255) NULL AFTER UserName;
+UPDATE Users SET NormalizedUserName = REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(LOWER(TRIM(UserName)), 'ı', 'i'), 'а', 'a'), 'с', 'c'), 'е', 'e'), 'һ', 'h'), 'і', 'i'), 'ј', 'j'), 'к', 'k'), 'м', 'm'), 'о', 'o'), 'р', 'p'), 'ѕ', 's'), 'т', 't'), 'ԝ', 'w'), 'х', 'x'), 'у', 'y'), 'α', 'a'), 'ε', 'e'), 'ι', 'i'), 'κ', 'k'), 'ν', 'v'), 'ο', 'o'), 'ρ', 'p'), 'τ', 't'), 'υ', 'u'), 'χ', 'x');
+ALTER TABLE Users MODIFY COLUMN NormalizedUserName VARCHAR(255) NOT NULL;
+ALTER TABLE Users ADD UNIQUE INDEX NormalizedUserName (NormalizedUserName);
@@ -0,0 +1,203 @@
+package users
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SignIn represents a successful sign-in recorded by LogUser
+type SignIn struct {
+	UserID   int64
+	Time     time.Time
+	ClientIP string
+}
+
+// MemStore represents a users.Store held in process memory that is safe for
+// concurrent use. It enforces the same email and user name uniqueness rules
+// as MySQLStore, so it can stand in for MySQL in tests and local development.
+type MemStore struct {
+	mx          sync.RWMutex
+	nextID      int64
+	users       map[int64]*User
+	emailIDs    map[string]int64
+	userNameIDs map[string]int64
+	signIns     []SignIn
+}
+
+// NewMemStore constructs and returns a new, empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{
+		nextID:      1,
+		users:       map[int64]*User{},
+		emailIDs:    map[string]int64{},
+		userNameIDs: map[string]int64{},
+	}
+}
+
+// GetByID returns the User with the given ID
+func (ms *MemStore) GetByID(ctx context.Context, id int64) (*User, error) {
+	ms.mx.RLock()
+	defer ms.mx.RUnlock()
+	return ms.get(id)
+}
+
+// GetByEmail returns the User with the given email
+func (ms *MemStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ms.mx.RLock()
+	defer ms.mx.RUnlock()
+	id, found := ms.emailIDs[NormalizeEmail(email)]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	return ms.get(id)
+}
+
+// GetByUserName returns the User whose Username normalizes
+// to the same value as the given Username
+func (ms *MemStore) GetByUserName(ctx context.Context, username string) (*User, error) {
+	ms.mx.RLock()
+	defer ms.mx.RUnlock()
+	id, found := ms.userNameIDs[NormalizeUserName(username)]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	return ms.get(id)
+}
+
+// Insert inserts the user into the store, and returns
+// the newly-inserted User, complete with the assigned ID
+func (ms *MemStore) Insert(ctx context.Context, user *User) (*User, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	return ms.insert(user)
+}
+
+// InsertAndLogUser inserts the user and logs their first sign-in
+// atomically, so that either both are saved or neither is
+func (ms *MemStore) InsertAndLogUser(ctx context.Context, user *User, time time.Time, clientIP string) (*User, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	insertedUser, err := ms.insert(user)
+	if err != nil {
+		return user, err
+	}
+	ms.signIns = append(ms.signIns, SignIn{insertedUser.ID, time, clientIP})
+	return insertedUser, nil
+}
+
+// Update applies UserUpdates to the given user ID and returns the newly-updated user
+func (ms *MemStore) Update(ctx context.Context, id int64, updates *Updates) (*User, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	user, found := ms.users[id]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	// Like MySQLStore, both names are overwritten even if empty
+	user.FirstName = updates.FirstName
+	user.LastName = updates.LastName
+	return copyUser(user), nil
+}
+
+// Delete deletes the user with the given ID. Deleting a user that
+// does not exist is not an error.
+func (ms *MemStore) Delete(ctx context.Context, id int64) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	user, found := ms.users[id]
+	if !found {
+		return nil
+	}
+	delete(ms.emailIDs, NormalizeEmail(user.Email))
+	delete(ms.userNameIDs, NormalizeUserName(user.UserName))
+	delete(ms.users, id)
+	return nil
+}
+
+// SetPassHash replaces the password hash of the user with the given ID
+func (ms *MemStore) SetPassHash(ctx context.Context, id int64, passHash []byte) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	user, found := ms.users[id]
+	if !found {
+		return ErrUserNotFound
+	}
+	user.PassHash = append([]byte(nil), passHash...)
+	return nil
+}
+
+// SetSuspended suspends the user with the given ID, or lifts their suspension
+func (ms *MemStore) SetSuspended(ctx context.Context, id int64, suspended bool) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	user, found := ms.users[id]
+	if !found {
+		return ErrUserNotFound
+	}
+	user.Suspended = suspended
+	return nil
+}
+
+// LogUser logs a successful sign-in by a user with the user ID, curent time,
+// and user IP address
+func (ms *MemStore) LogUser(ctx context.Context, id int64, time time.Time, clientIP string) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	if _, found := ms.users[id]; !found {
+		return ErrUserNotFound
+	}
+	ms.signIns = append(ms.signIns, SignIn{id, time, clientIP})
+	return nil
+}
+
+// SignIns returns every sign-in recorded for the given user ID, oldest first
+func (ms *MemStore) SignIns(id int64) []SignIn {
+	ms.mx.RLock()
+	defer ms.mx.RUnlock()
+	signIns := []SignIn{}
+	for _, signIn := range ms.signIns {
+		if signIn.UserID == id {
+			signIns = append(signIns, signIn)
+		}
+	}
+	return signIns
+}
+
+// get returns a copy of the user with the given ID. The caller must hold the lock.
+func (ms *MemStore) get(id int64) (*User, error) {
+	user, found := ms.users[id]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+// insert checks the user against the uniqueness rules and stores a copy of it,
+// setting the assigned ID and normalized Email on `user`. The caller must hold
+// the write lock.
+func (ms *MemStore) insert(user *User) (*User, error) {
+	email := NormalizeEmail(user.Email)
+	userName := NormalizeUserName(user.UserName)
+	if _, found := ms.emailIDs[email]; found {
+		return user, ErrEmailTaken
+	}
+	if _, found := ms.userNameIDs[userName]; found {
+		return user, ErrUserNameTaken
+	}
+
+	user.Email = email
+	user.ID = ms.nextID
+	ms.nextID++
+	ms.users[user.ID] = copyUser(user)
+	ms.emailIDs[email] = user.ID
+	ms.userNameIDs[userName] = user.ID
+	return user, nil
+}
+
+// copyUser returns a deep copy of the user so callers can't modify stored state
+func copyUser(user *User) *User {
+	userCopy := *user
+	userCopy.PassHash = append([]byte(nil), user.PassHash...)
+	return &userCopy
+}
@@ -1,82 +1,156 @@
 package users
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // baseSelectStatement is SQL select statement that retrieves all user data from the Users table
 // This base select statement is reused many times in this file thus justifying it's existence
 // as a global constant
-const baseSelectStatement = "SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL FROM Users "
+const baseSelectStatement = "SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL, Suspended FROM Users "
+
+// insertStatement is the SQL insert statement that adds a new user to the Users table
+const insertStatement = "INSERT INTO Users(Email, PassHash, UserName, NormalizedUserName, FirstName, LastName, PhotoURL) VALUES(?,?,?,?,?,?,?)"
+
+// logUserStatement is the SQL insert statement that records a sign-in in the UserSignInLog table
+const logUserStatement = "INSERT INTO UserSignInLog(UserID, SignInTime, ClientIP) VALUES(?,?,?)"
+
+// errDuplicateEntry is the MySQL error number for a unique constraint violation
+const errDuplicateEntry = 1062
+
+// errNoReferencedRow is the MySQL error number for a foreign key constraint
+// violation, such as a sign-in of a user that doesn't exist
+const errNoReferencedRow = 1452
+
+// tracer creates a span for every statement a MySQLStore executes
+var tracer = otel.Tracer("serverside-final-project/servers/gateway/models/users")
+
+// DefaultOperationTimeout is the default upper bound on how long a single
+// MySQLStore operation may take, in addition to any deadline on its context
+const DefaultOperationTimeout = 5 * time.Second
 
 // MySQLStore represents a users.Store backed by MySQL.
 type MySQLStore struct {
 	Client *sql.DB
+	// OperationTimeout bounds each operation. Zero means operations are
+	// only bounded by the context they are given.
+	OperationTimeout time.Duration
 }
 
 // NewMySQLStore constructs a new MySQLStore
 func NewMySQLStore(client *sql.DB) *MySQLStore {
-	return &MySQLStore{client}
+	return &MySQLStore{client, DefaultOperationTimeout}
+}
+
+// withTimeout derives a context bounded by the store's operation timeout
+func (ms *MySQLStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ms.OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, ms.OperationTimeout)
 }
 
 // GetByID returns the User with the given ID
-func (ms *MySQLStore) GetByID(id int64) (*User, error) {
+func (ms *MySQLStore) GetByID(ctx context.Context, id int64) (*User, error) {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
 	selectQuery := baseSelectStatement + "WHERE ID = ?"
-	return getUser(ms.Client, selectQuery, id)
+	return getUser(ctx, ms.Client, selectQuery, id)
 }
 
-// GetByEmail returns the User with the given email
-func (ms *MySQLStore) GetByEmail(email string) (*User, error) {
+// GetByEmail returns the User whose Email normalizes
+// to the same value as the given email
+func (ms *MySQLStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
 	selectQuery := baseSelectStatement + "WHERE Email = ?"
-	return getUser(ms.Client, selectQuery, email)
+	return getUser(ctx, ms.Client, selectQuery, NormalizeEmail(email))
 }
 
-// GetByUserName returns the User with the given Username
-func (ms *MySQLStore) GetByUserName(username string) (*User, error) {
-	selectQuery := baseSelectStatement + "WHERE UserName = ?"
-	return getUser(ms.Client, selectQuery, username)
+// GetByUserName returns the User whose Username normalizes
+// to the same value as the given Username
+func (ms *MySQLStore) GetByUserName(ctx context.Context, username string) (*User, error) {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
+	selectQuery := baseSelectStatement + "WHERE NormalizedUserName = ?"
+	return getUser(ctx, ms.Client, selectQuery, NormalizeUserName(username))
 }
 
 // Insert inserts the user into the database, and returns
 // the newly-inserted User, complete with the DBMS-assigned ID
-func (ms *MySQLStore) Insert(user *User) (*User, error) {
-	insertQuery := "INSERT INTO Users(Email, PassHash, UserName, FirstName, LastName, PhotoURL) VALUES(?,?,?,?,?,?)"
+func (ms *MySQLStore) Insert(ctx context.Context, user *User) (*User, error) {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
+	id, err := insertUser(ctx, ms.Client, user)
+	if err != nil {
+		return user, err
+	}
 
-	result, err := ms.Client.Exec(insertQuery, user.Email, user.PassHash, user.UserName,
-		user.FirstName, user.LastName, user.PhotoURL)	
+	user.ID = id
+	return user, nil
+}
+
+// InsertAndLogUser inserts the user and logs their first sign-in within a
+// single transaction, so that either both are saved or neither is
+func (ms *MySQLStore) InsertAndLogUser(ctx context.Context, user *User, time time.Time, clientIP string) (*User, error) {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
+	tx, err := ms.Client.BeginTx(ctx, nil)
 	if err != nil {
-		return user, fmt.Errorf("Error inserting new user: %v", err)
+		return user, fmt.Errorf("Error beginning transaction: %v", err)
 	}
 
-	id, err := result.LastInsertId()
+	id, err := insertUser(ctx, tx, user)
 	if err != nil {
-		return user, fmt.Errorf("Error getting new user ID: %v", err)
+		tx.Rollback()
+		return user, err
 	}
 
-	user.ID = id	
+	if _, err := execContext(ctx, tx, logUserStatement, id, time, clientIP); err != nil {
+		tx.Rollback()
+		return user, fmt.Errorf("Error logging user sign in: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return user, fmt.Errorf("Error committing new user: %v", err)
+	}
+
+	user.ID = id
 	return user, nil
 }
 
 // Update applies UserUpdates to the given user ID and returns the newly-updated user
-func (ms *MySQLStore) Update(id int64, updates *Updates) (*User, error) {
+func (ms *MySQLStore) Update(ctx context.Context, id int64, updates *Updates) (*User, error) {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
 	updateQuery := "UPDATE Users SET FirstName = ?, LastName = ? WHERE ID = ?"
 
-	_, err := ms.Client.Exec(updateQuery, updates.FirstName, updates.LastName, id)
+	_, err := execContext(ctx, ms.Client, updateQuery, updates.FirstName, updates.LastName, id)
 	if err != nil {
 		return nil, fmt.Errorf("Error updating user: %v", err)
 	}
 
 	selectQuery := baseSelectStatement + "WHERE ID = ?"
-	return getUser(ms.Client, selectQuery, id)
+	return getUser(ctx, ms.Client, selectQuery, id)
 }
 
 // Delete deletes the user with the given ID
-func (ms *MySQLStore) Delete(id int64) error {
+func (ms *MySQLStore) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
 	deletionQuery := "DELETE FROM Users WHERE ID = ?"
 
-	_, err := ms.Client.Exec(deletionQuery, id)
+	_, err := execContext(ctx, ms.Client, deletionQuery, id)
 	if err != nil {
 		return fmt.Errorf("Error deleting user: %v", err)
 	}
@@ -84,34 +158,121 @@ func (ms *MySQLStore) Delete(id int64) error {
 	return nil
 }
 
+// SetPassHash replaces the password hash of the user with the given ID
+func (ms *MySQLStore) SetPassHash(ctx context.Context, id int64, passHash []byte) error {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
+	err := ms.updateUser(ctx, id, "UPDATE Users SET PassHash = ? WHERE ID = ?", passHash, id)
+	if err == ErrUserNotFound {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("Error updating password: %v", err)
+	}
+
+	return nil
+}
+
+// SetSuspended suspends the user with the given ID, or lifts their suspension
+func (ms *MySQLStore) SetSuspended(ctx context.Context, id int64, suspended bool) error {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
+	err := ms.updateUser(ctx, id, "UPDATE Users SET Suspended = ? WHERE ID = ?", suspended, id)
+	if err == ErrUserNotFound {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("Error updating suspension: %v", err)
+	}
+
+	return nil
+}
+
 // LogUser logs a successful sign-in by a user with the user ID, curent time,
 // and user IP address
-func (ms *MySQLStore) LogUser(id int64, time time.Time, clientIP string) error {
-	insertQuery := "INSERT INTO UserSignInLog(UserID, SignInTime, ClientIP) VALUES(?,?,?)"
+func (ms *MySQLStore) LogUser(ctx context.Context, id int64, time time.Time, clientIP string) error {
+	ctx, cancel := ms.withTimeout(ctx)
+	defer cancel()
+	_, err := execContext(ctx, ms.Client, logUserStatement, id, time, clientIP)
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == errNoReferencedRow {
+		return ErrUserNotFound
+	} else if err != nil {
+		return fmt.Errorf("Error logging user sign in: %v", err)
+	}
 
-	_, err := ms.Client.Exec(insertQuery, id, time, clientIP)
+	return nil
+}
+
+// updateUser executes `statement`, an UPDATE of the user with the given ID,
+// and returns ErrUserNotFound if there's no such user. MySQL only counts the
+// rows an UPDATE changed, so when none were, the user is looked up to tell a
+// missing user from one whose values were already set.
+func (ms *MySQLStore) updateUser(ctx context.Context, id int64, statement string, args ...interface{}) (err error) {
+	result, err := execContext(ctx, ms.Client, statement, args...)
 	if err != nil {
-		return fmt.Errorf("Error logging user sign in: %v", err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected > 0 {
+		return err
 	}
 
+	countQuery := "SELECT COUNT(*) FROM Users WHERE ID = ?"
+	ctx, span := startSpan(ctx, countQuery)
+	defer func() { endSpan(span, err) }()
+	var count int
+	if err := ms.Client.QueryRowContext(ctx, countQuery, id).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
 	return nil
 }
 
+// execer is implemented by both *sql.DB and *sql.Tx, allowing statements to be
+// shared between transactional and non-transactional code paths
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertUser is a helper function that inserts the user using the given execer and
+// returns the DBMS-assigned ID. The user's Email is normalized before it's stored.
+// Unique constraint violations are reported as ErrEmailTaken or ErrUserNameTaken.
+func insertUser(ctx context.Context, db execer, user *User) (int64, error) {
+	user.Email = NormalizeEmail(user.Email)
+	result, err := execContext(ctx, db, insertStatement, user.Email, user.PassHash, user.UserName,
+		NormalizeUserName(user.UserName), user.FirstName, user.LastName, user.PhotoURL)
+	if err != nil {
+		if dupErr := duplicateEntryError(err); dupErr != nil {
+			return 0, dupErr
+		}
+		return 0, fmt.Errorf("Error inserting new user: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("Error getting new user ID: %v", err)
+	}
+	return id, nil
+}
+
 // getUser is a helper function for getting a specific user based on a given SQL select statement
 // and select parameter.
 // Note: selectParam has the type: interface{}, meaning a variable with any type can be passed
 // into the function, thus allowing both int64 (id) and string (email, username) to be passed to
 // the same helper function.
-func getUser(db *sql.DB, selectQuery string, selectParam interface{}) (*User, error) {
-	user := &User{}
+func getUser(ctx context.Context, db *sql.DB, selectQuery string, selectParam interface{}) (user *User, err error) {
+	ctx, span := startSpan(ctx, selectQuery)
+	defer func() { endSpan(span, err) }()
+	user = &User{}
 
-	rows, err := db.Query(selectQuery, selectParam)
+	rows, err := db.QueryContext(ctx, selectQuery, selectParam)
 	if err != nil {
 		return user, fmt.Errorf("Error selecting user: %v", err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		err := rows.Scan(&user.ID, &user.Email, &user.PassHash, &user.UserName, &user.FirstName, &user.LastName, &user.PhotoURL)
+		err := rows.Scan(&user.ID, &user.Email, &user.PassHash, &user.UserName, &user.FirstName, &user.LastName, &user.PhotoURL, &user.Suspended)
 		if err != nil {
 			return user, fmt.Errorf("Error scanning selected user: %v", err)
 		}
@@ -121,10 +282,52 @@ func getUser(db *sql.DB, selectQuery string, selectParam interface{}) (*User, er
 		return user, fmt.Errorf("Error fetching selected user: %v", err)
 	}
 
-	defer rows.Close()
-
 	if user.UserName == "" {
-		return user, fmt.Errorf("User not found")
+		return user, ErrUserNotFound
 	}
 	return user, nil
 }
+
+// execContext executes `statement` with `args` on `db` within a span
+func execContext(ctx context.Context, db execer, statement string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startSpan(ctx, statement)
+	result, err := db.ExecContext(ctx, statement, args...)
+	endSpan(span, err)
+	return result, err
+}
+
+// startSpan starts a span for executing `statement`, named after the kind of
+// statement. The statement is recorded without its arguments, which may be
+// personal data such as emails and password hashes.
+func startSpan(ctx context.Context, statement string) (context.Context, trace.Span) {
+	operation := strings.Fields(statement)[0]
+	return tracer.Start(ctx, operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameMySQL,
+		semconv.DBOperationName(operation),
+		semconv.DBQueryText(statement),
+	))
+}
+
+// endSpan records `err` on `span` and ends it. Users that aren't found are
+// not errors of the database.
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != ErrUserNotFound {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// duplicateEntryError maps a MySQL unique constraint violation on the Users table
+// to ErrEmailTaken or ErrUserNameTaken. It returns nil for any other error.
+func duplicateEntryError(err error) error {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok || mysqlErr.Number != errDuplicateEntry {
+		return nil
+	}
+	// The message ends with the violated key, e.g. "Duplicate entry 'x' for key 'Users.Email'"
+	if strings.HasSuffix(mysqlErr.Message, "Email'") {
+		return ErrEmailTaken
+	}
+	return ErrUserNameTaken
+}
@@ -1,15 +1,22 @@
 package users
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os/exec"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -25,24 +32,24 @@ func TestGetByID(t *testing.T) {
 		t.Fatalf("An error '%s' was not expected when generating the test user struct", err)
 	}
 
-	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL"}
+	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL", "Suspended"}
 	mySQLStore := NewMySQLStore(db)
 
-	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL FROM Users").
+	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL, Suspended FROM Users").
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows(columns).AddRow(user.ID, user.Email, user.PassHash,
-			user.UserName, user.FirstName, user.LastName, user.PhotoURL))
+			user.UserName, user.FirstName, user.LastName, user.PhotoURL, user.Suspended))
 
-	_, funcErr := mySQLStore.GetByID(user.ID)
+	_, funcErr := mySQLStore.GetByID(context.Background(), user.ID)
 	if funcErr != nil {
 		t.Errorf("Expected no error, but got %v instead", err)
 	}
 
-	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL FROM Users").
+	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL, Suspended FROM Users").
 		WithArgs(3).
 		WillReturnError(fmt.Errorf("Error selecting user"))
 
-	_, funcErr2 := mySQLStore.GetByID(3)
+	_, funcErr2 := mySQLStore.GetByID(context.Background(), 3)
 	if funcErr2 == nil {
 		t.Error("Expected error, but got none")
 	}
@@ -52,6 +59,76 @@ func TestGetByID(t *testing.T) {
 	}
 }
 
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mySQLStore := NewMySQLStore(db)
+	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL", "Suspended"}
+
+	mock.ExpectQuery("SELECT (.+) FROM Users").WithArgs(3).WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectExec("DELETE FROM Users").WithArgs(3).WillReturnError(fmt.Errorf("connection lost"))
+
+	if _, err := mySQLStore.GetByID(context.Background(), 3); err != ErrUserNotFound {
+		t.Errorf("Expected %v, but got %v instead", ErrUserNotFound, err)
+	}
+	if err := mySQLStore.Delete(context.Background(), 3); err == nil {
+		t.Error("Expected error, but got none")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected a span for each statement, but got %d spans", len(spans))
+	}
+	if spans[0].Name() != "SELECT" || spans[0].Status().Code != codes.Unset {
+		t.Errorf("Expected an unset SELECT span for a missing user, but got %s with status %v", spans[0].Name(), spans[0].Status().Code)
+	}
+	if spans[1].Name() != "DELETE" || spans[1].Status().Code != codes.Error {
+		t.Errorf("Expected a failed DELETE span, but got %s with status %v", spans[1].Name(), spans[1].Status().Code)
+	}
+	for _, attr := range spans[1].Attributes() {
+		if attr.Key == semconv.DBQueryTextKey && attr.Value.AsString() != "DELETE FROM Users WHERE ID = ?" {
+			t.Errorf("Expected the statement without its arguments, but got %q", attr.Value.AsString())
+		}
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL", "Suspended"}
+	mySQLStore := NewMySQLStore(db)
+	mySQLStore.OperationTimeout = 10 * time.Millisecond
+
+	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL, Suspended FROM Users").
+		WithArgs(1).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	if _, funcErr := mySQLStore.GetByID(context.Background(), 1); funcErr == nil {
+		t.Error("Expected timeout error, but got none")
+	}
+
+	// A cancelled request context stops the query even without a store timeout
+	mySQLStore.OperationTimeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, funcErr := mySQLStore.GetByID(ctx, 1); funcErr == nil {
+		t.Error("Expected cancellation error, but got none")
+	}
+}
+
 func TestGetByEmail(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -64,15 +141,15 @@ func TestGetByEmail(t *testing.T) {
 		t.Fatalf("An error '%s' was not expected when generating the test user struct", err)
 	}
 
-	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL"}
+	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL", "Suspended"}
 	mySQLStore := NewMySQLStore(db)
 
-	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL FROM Users").
+	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL, Suspended FROM Users").
 		WithArgs("hawkticehurst@gmail.com").
 		WillReturnRows(sqlmock.NewRows(columns).AddRow(user.ID, user.Email, user.PassHash,
-			user.UserName, user.FirstName, user.LastName, user.PhotoURL))
+			user.UserName, user.FirstName, user.LastName, user.PhotoURL, user.Suspended))
 
-	_, funcErr := mySQLStore.GetByEmail(user.Email)
+	_, funcErr := mySQLStore.GetByEmail(context.Background(), user.Email)
 	if funcErr != nil {
 		t.Errorf("Expected no error, but got %v instead", err)
 	}
@@ -94,15 +171,15 @@ func TestGetByUserName(t *testing.T) {
 		t.Fatalf("An error '%s' was not expected when generating the test user struct", err)
 	}
 
-	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL"}
+	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL", "Suspended"}
 	mySQLStore := NewMySQLStore(db)
 
-	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL FROM Users").
+	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL, Suspended FROM Users").
 		WithArgs("hawkticehurst").
 		WillReturnRows(sqlmock.NewRows(columns).AddRow(user.ID, user.Email, user.PassHash,
-			user.UserName, user.FirstName, user.LastName, user.PhotoURL))
+			user.UserName, user.FirstName, user.LastName, user.PhotoURL, user.Suspended))
 
-	_, funcErr := mySQLStore.GetByUserName(user.UserName)
+	_, funcErr := mySQLStore.GetByUserName(context.Background(), user.UserName)
 	if funcErr != nil {
 		t.Errorf("Expected no error, but got %v instead", err)
 	}
@@ -127,10 +204,11 @@ func TestInsert(t *testing.T) {
 	mySQLStore := NewMySQLStore(db)
 
 	mock.ExpectExec("INSERT INTO Users").
-		WithArgs(user.Email, user.PassHash, user.UserName, user.FirstName, user.LastName, user.PhotoURL).
+		WithArgs(user.Email, user.PassHash, user.UserName, NormalizeUserName(user.UserName), user.FirstName,
+			user.LastName, user.PhotoURL).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	_, funcErr := mySQLStore.Insert(user)
+	_, funcErr := mySQLStore.Insert(context.Background(), user)
 	if funcErr != nil {
 		t.Errorf("Expected no error, but got %v instead", err)
 	}
@@ -141,11 +219,11 @@ func TestInsert(t *testing.T) {
 	}
 
 	mock.ExpectExec("INSERT INTO Users").
-		WithArgs(emptyUser.Email, emptyUser.PassHash, emptyUser.UserName, emptyUser.FirstName,
+		WithArgs(emptyUser.Email, emptyUser.PassHash, emptyUser.UserName, "", emptyUser.FirstName,
 			emptyUser.LastName, emptyUser.PhotoURL).
 		WillReturnError(fmt.Errorf("Error inserting new user"))
 
-	_, funcErr2 := mySQLStore.Insert(emptyUser)
+	_, funcErr2 := mySQLStore.Insert(context.Background(), emptyUser)
 	if funcErr2 == nil {
 		t.Error("Expected error, but got none")
 	}
@@ -155,6 +233,99 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestInsertDuplicate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	user, err := generateBasicUser()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when generating the test user struct", err)
+	}
+
+	mySQLStore := NewMySQLStore(db)
+
+	cases := []struct {
+		name     string
+		dbErr    error
+		expected error
+	}{
+		{"Duplicate email", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'Users.Email'"}, ErrEmailTaken},
+		{"Duplicate user name", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'Users.NormalizedUserName'"}, ErrUserNameTaken},
+	}
+
+	for _, c := range cases {
+		mock.ExpectExec("INSERT INTO Users").WillReturnError(c.dbErr)
+		if _, err := mySQLStore.Insert(context.Background(), user); err != c.expected {
+			t.Errorf("case %s: expected %v, but got %v instead", c.name, c.expected, err)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestInsertAndLogUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	user, err := generateBasicUser()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when generating the test user struct", err)
+	}
+
+	mySQLStore := NewMySQLStore(db)
+	signInTime := time.Now()
+
+	// Both statements succeed and the transaction is committed
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO Users").
+		WithArgs(user.Email, user.PassHash, user.UserName, NormalizeUserName(user.UserName), user.FirstName,
+			user.LastName, user.PhotoURL).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec("INSERT INTO UserSignInLog").
+		WithArgs(5, signInTime, "127.0.0.1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	insertedUser, funcErr := mySQLStore.InsertAndLogUser(context.Background(), user, signInTime, "127.0.0.1")
+	if funcErr != nil {
+		t.Errorf("Expected no error, but got %v instead", funcErr)
+	} else if insertedUser.ID != 5 {
+		t.Errorf("Expected user ID 5, but got %d instead", insertedUser.ID)
+	}
+
+	// The sign-in log fails so the insert is rolled back
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO Users").WillReturnResult(sqlmock.NewResult(6, 1))
+	mock.ExpectExec("INSERT INTO UserSignInLog").WillReturnError(fmt.Errorf("Error logging user sign in"))
+	mock.ExpectRollback()
+
+	if _, funcErr2 := mySQLStore.InsertAndLogUser(context.Background(), user, signInTime, "127.0.0.1"); funcErr2 == nil {
+		t.Error("Expected error, but got none")
+	}
+
+	// The insert conflicts with an existing user so nothing is logged
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO Users").
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'Users.Email'"})
+	mock.ExpectRollback()
+
+	if _, funcErr3 := mySQLStore.InsertAndLogUser(context.Background(), user, signInTime, "127.0.0.1"); funcErr3 != ErrEmailTaken {
+		t.Errorf("Expected %v, but got %v instead", ErrEmailTaken, funcErr3)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -172,19 +343,19 @@ func TestUpdate(t *testing.T) {
 		t.Fatalf("An error '%s' was not expected when generating the user update struct", err)
 	}
 
-	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL"}
+	columns := []string{"ID", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL", "Suspended"}
 	mySQLStore := NewMySQLStore(db)
 
 	mock.ExpectExec("UPDATE Users SET FirstName").
 		WithArgs(updates.FirstName, updates.LastName, user.ID).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL FROM Users").
+	mock.ExpectQuery("SELECT ID, Email, PassHash, UserName, FirstName, LastName, PhotoURL, Suspended FROM Users").
 		WithArgs(user.ID).
 		WillReturnRows(sqlmock.NewRows(columns).AddRow(user.ID, user.Email, user.PassHash,
-			user.UserName, user.FirstName, user.LastName, user.PhotoURL))
+			user.UserName, user.FirstName, user.LastName, user.PhotoURL, user.Suspended))
 
-	_, funcErr := mySQLStore.Update(user.ID, updates)
+	_, funcErr := mySQLStore.Update(context.Background(), user.ID, updates)
 	if funcErr != nil {
 		t.Errorf("Expected no error, but got %v instead", err)
 	}
@@ -193,7 +364,7 @@ func TestUpdate(t *testing.T) {
 		WithArgs(updates.FirstName, updates.LastName, 3).
 		WillReturnError(fmt.Errorf("Error updating user"))
 
-	_, funcErr2 := mySQLStore.Update(3, updates)
+	_, funcErr2 := mySQLStore.Update(context.Background(), 3, updates)
 	if funcErr2 == nil {
 		t.Error("Expected error, but got none")
 	}
@@ -221,7 +392,7 @@ func TestDelete(t *testing.T) {
 		WithArgs(user.ID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	funcErr := mySQLStore.Delete(user.ID)
+	funcErr := mySQLStore.Delete(context.Background(), user.ID)
 	if funcErr != nil {
 		t.Errorf("Expected no error, but got %v instead", err)
 	}
@@ -230,7 +401,7 @@ func TestDelete(t *testing.T) {
 		WithArgs(3).
 		WillReturnError(fmt.Errorf("Error deleting user"))
 
-	funcErr2 := mySQLStore.Delete(3)
+	funcErr2 := mySQLStore.Delete(context.Background(), 3)
 	if funcErr2 == nil {
 		t.Error("Expected error, but got none")
 	}
@@ -240,6 +411,131 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestSetPassHash(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mySQLStore := NewMySQLStore(db)
+	passHash := []byte("$2a$04$not.a.real.hash")
+
+	mock.ExpectExec("UPDATE Users SET PassHash").
+		WithArgs(passHash, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := mySQLStore.SetPassHash(context.Background(), 1, passHash); err != nil {
+		t.Errorf("Expected no error, but got %v instead", err)
+	}
+
+	mock.ExpectExec("UPDATE Users SET PassHash").
+		WithArgs(passHash, 3).
+		WillReturnError(fmt.Errorf("Error updating password"))
+
+	if err := mySQLStore.SetPassHash(context.Background(), 3, passHash); err == nil {
+		t.Error("Expected error, but got none")
+	}
+
+	// No row is changed when the user doesn't exist
+	mock.ExpectExec("UPDATE Users SET PassHash").
+		WithArgs(passHash, 4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM Users WHERE ID = ?")).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	if err := mySQLStore.SetPassHash(context.Background(), 4, passHash); err != ErrUserNotFound {
+		t.Errorf("Expected %v, but got %v instead", ErrUserNotFound, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetSuspended(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mySQLStore := NewMySQLStore(db)
+
+	mock.ExpectExec("UPDATE Users SET Suspended").
+		WithArgs(true, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := mySQLStore.SetSuspended(context.Background(), 1, true); err != nil {
+		t.Errorf("Expected no error, but got %v instead", err)
+	}
+
+	mock.ExpectExec("UPDATE Users SET Suspended").
+		WithArgs(false, 3).
+		WillReturnError(fmt.Errorf("Error updating suspension"))
+
+	if err := mySQLStore.SetSuspended(context.Background(), 3, false); err == nil {
+		t.Error("Expected error, but got none")
+	}
+
+	// No row is changed when the user is already suspended
+	mock.ExpectExec("UPDATE Users SET Suspended").
+		WithArgs(true, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM Users WHERE ID = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	if err := mySQLStore.SetSuspended(context.Background(), 1, true); err != nil {
+		t.Errorf("Expected no error, but got %v instead", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLogUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mySQLStore := NewMySQLStore(db)
+	signInTime := time.Now()
+
+	mock.ExpectExec("INSERT INTO UserSignInLog").
+		WithArgs(1, signInTime, "127.0.0.1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := mySQLStore.LogUser(context.Background(), 1, signInTime, "127.0.0.1"); err != nil {
+		t.Errorf("Expected no error, but got %v instead", err)
+	}
+
+	// The foreign key on UserID is violated when the user doesn't exist
+	mock.ExpectExec("INSERT INTO UserSignInLog").
+		WithArgs(3, signInTime, "127.0.0.1").
+		WillReturnError(&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails"})
+
+	if err := mySQLStore.LogUser(context.Background(), 3, signInTime, "127.0.0.1"); err != ErrUserNotFound {
+		t.Errorf("Expected %v, but got %v instead", ErrUserNotFound, err)
+	}
+
+	mock.ExpectExec("INSERT INTO UserSignInLog").
+		WithArgs(4, signInTime, "127.0.0.1").
+		WillReturnError(fmt.Errorf("connection lost"))
+
+	if err := mySQLStore.LogUser(context.Background(), 4, signInTime, "127.0.0.1"); err == nil || err == ErrUserNotFound {
+		t.Errorf("Expected a database error, but got %v instead", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
 // generateBasicUser Helper function for generating a basic user struct to be used in testing
 func generateBasicUser() (*User, error) {
 	// Generate a password hash
@@ -257,6 +553,7 @@ func generateBasicUser() (*User, error) {
 		"Hawk",
 		"Ticehurst",
 		"photo.com",
+		false,
 	}, nil
 }
 
@@ -271,6 +568,7 @@ func generateEmptyUser() (*User, error) {
 		"",
 		"",
 		"",
+		false,
 	}, nil
 }
 
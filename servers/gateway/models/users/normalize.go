@@ -0,0 +1,89 @@
+package users
+
+import (
+	"strings"
+)
+
+// confusables maps letters of other scripts that are commonly mistaken for
+// Latin letters to those letters, so that user names which look the same on
+// screen (e.g. "hawk" and "hаwk" with a Cyrillic "а") normalize to the same
+// value. This is a small subset of the Unicode confusables table. Digits and
+// sequences of Latin letters such as "rn" aren't folded, since names like
+// "user1" and "userl" are ordinary distinct names. It must be changed along
+// with the migration that recomputes the NormalizedUserName column.
+var confusables = map[rune]rune{
+	'ı': 'i', // Latin dotless i
+	'а': 'a', // Cyrillic
+	'с': 'c',
+	'е': 'e',
+	'һ': 'h',
+	'і': 'i',
+	'ј': 'j',
+	'к': 'k',
+	'м': 'm',
+	'о': 'o',
+	'р': 'p',
+	'ѕ': 's',
+	'т': 't',
+	'ԝ': 'w',
+	'х': 'x',
+	'у': 'y',
+	'α': 'a', // Greek
+	'ε': 'e',
+	'ι': 'i',
+	'κ': 'k',
+	'ν': 'v',
+	'ο': 'o',
+	'ρ': 'p',
+	'τ': 't',
+	'υ': 'u',
+	'χ': 'x',
+}
+
+// reservedUserNames is the set of normalized user names that cannot be
+// registered because they could be used to impersonate the site or its staff
+var reservedUserNames = map[string]bool{
+	"admin":          true,
+	"administrator":  true,
+	"root":           true,
+	"system":         true,
+	"support":        true,
+	"help":           true,
+	"moderator":      true,
+	"mod":            true,
+	"staff":          true,
+	"official":       true,
+	"musicianmeetup": true,
+	"meetup":         true,
+	"api":            true,
+	"me":             true,
+	"mine":           true,
+	"null":           true,
+	"undefined":      true,
+}
+
+// NormalizeUserName returns the canonical form of a user name that is used to
+// decide whether two user names are the same. The name is case-folded and
+// confusable characters are mapped to their Latin lookalikes, so "Admin",
+// "ADMIN" and "аdmin" (with a Cyrillic "а") all normalize to "admin".
+func NormalizeUserName(userName string) string {
+	folded := strings.ToLower(strings.TrimSpace(userName))
+	return strings.Map(func(r rune) rune {
+		if canonical, found := confusables[r]; found {
+			return canonical
+		}
+		return r
+	}, folded)
+}
+
+// NormalizeEmail returns the canonical form of an email address, which is
+// how every Store keeps and looks up emails
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// IsReservedUserName reports whether the user name, once normalized, is on the
+// reserved names list
+func IsReservedUserName(userName string) bool {
+	return reservedUserNames[NormalizeUserName(userName)]
+}
@@ -0,0 +1,66 @@
+package users
+
+import "testing"
+
+func TestNormalizeUserName(t *testing.T) {
+	cases := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"stanley", "stanley"},
+		{"Stanley", "stanley"},
+		{"  STANLEY ", "stanley"},
+		{"stan1ey", "stan1ey"},
+		{"hawk0", "hawk0"},
+		{"ѕtаnlеу", "stanley"},
+		{"οfficial", "official"},
+		{"rnusician", "rnusician"},
+		{"vvu", "vvu"},
+	}
+
+	for _, c := range cases {
+		if output := NormalizeUserName(c.input); output != c.expectedOutput {
+			t.Errorf("incorrect output for `%s`: expected `%s` but got `%s`", c.input, c.expectedOutput, output)
+		}
+	}
+}
+
+func TestNormalizeUserNameDistinctNames(t *testing.T) {
+	cases := [][2]string{
+		{"user1", "userl"},
+		{"bob10", "boblo"},
+		{"corn", "com"},
+	}
+	for _, c := range cases {
+		if NormalizeUserName(c[0]) == NormalizeUserName(c[1]) {
+			t.Errorf("expected `%s` and `%s` to be distinct user names", c[0], c[1])
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	if output := NormalizeEmail(" Stanley@Gmail.COM "); output != "stanley@gmail.com" {
+		t.Errorf("incorrect output: expected `stanley@gmail.com` but got `%s`", output)
+	}
+}
+
+func TestIsReservedUserName(t *testing.T) {
+	cases := []struct {
+		input          string
+		expectedOutput bool
+	}{
+		{"admin", true},
+		{"ADMIN", true},
+		{"аdmin", true},
+		{"ѕupport", true},
+		{"supp0rt", false},
+		{"stanley", false},
+		{"admins", false},
+	}
+
+	for _, c := range cases {
+		if output := IsReservedUserName(c.input); output != c.expectedOutput {
+			t.Errorf("incorrect output for `%s`: expected `%v` but got `%v`", c.input, c.expectedOutput, output)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package users
 
 import (
+	"context"
 	"errors"
 	"time"
 )
@@ -8,29 +9,53 @@ import (
 // ErrUserNotFound is returned when the user can't be found
 var ErrUserNotFound = errors.New("user not found")
 
-// Store represents a store for Users
+// ErrEmailTaken is returned by Insert when another user already has the email
+var ErrEmailTaken = errors.New("email is already in use")
+
+// ErrUserNameTaken is returned by Insert when another user already has a user
+// name that normalizes to the same value
+var ErrUserNameTaken = errors.New("user name is already taken")
+
+// Store represents a store for Users. Every operation takes a context so that
+// it is abandoned when the request it serves is cancelled or times out.
 type Store interface {
 	// GetByID returns the User with the given ID
-	GetByID(id int64) (*User, error)
+	GetByID(ctx context.Context, id int64) (*User, error)
 
 	// GetByEmail returns the User with the given email
-	GetByEmail(email string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
 
-	// GetByUserName returns the User with the given Username
-	GetByUserName(username string) (*User, error)
+	// GetByUserName returns the User whose Username normalizes
+	// to the same value as the given Username
+	GetByUserName(ctx context.Context, username string) (*User, error)
 
 	// Insert inserts the user into the database, and returns
-	// the newly-inserted User, complete with the DBMS-assigned ID
-	Insert(user *User) (*User, error)
+	// the newly-inserted User, complete with the DBMS-assigned ID.
+	// ErrEmailTaken or ErrUserNameTaken is returned if the user
+	// conflicts with an existing user
+	Insert(ctx context.Context, user *User) (*User, error)
+
+	// InsertAndLogUser inserts the user and logs their first sign-in
+	// within a single transaction, so that either both are saved or
+	// neither is. It returns the newly-inserted User, complete with
+	// the DBMS-assigned ID
+	InsertAndLogUser(ctx context.Context, user *User, time time.Time, clientIP string) (*User, error)
 
 	// Update applies UserUpdates to the given user ID
 	// and returns the newly-updated user
-	Update(id int64, updates *Updates) (*User, error)
+	Update(ctx context.Context, id int64, updates *Updates) (*User, error)
 
 	// Delete deletes the user with the given ID
-	Delete(id int64) error
+	Delete(ctx context.Context, id int64) error
+
+	// SetPassHash replaces the password hash of the user with the given ID
+	SetPassHash(ctx context.Context, id int64, passHash []byte) error
+
+	// SetSuspended suspends the user with the given ID, or lifts their
+	// suspension. Suspended users can't begin new sessions.
+	SetSuspended(ctx context.Context, id int64, suspended bool) error
 
 	// LogUser logs a successful sign-in by a user with the user ID, curent time,
 	// and user IP address
-	LogUser(id int64, time time.Time, clientIP string) error
+	LogUser(ctx context.Context, id int64, time time.Time, clientIP string) error
 }
@@ -0,0 +1,315 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeFactory returns an empty Store along with a function that counts the
+// sign-ins recorded for a user ID, which the Store interface does not expose
+type storeFactory func(t *testing.T) (Store, func(id int64) int)
+
+// TestMemStoreConformance runs the Store conformance suite against MemStore
+func TestMemStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) (Store, func(id int64) int) {
+		store := NewMemStore()
+		return store, func(id int64) int {
+			return len(store.SignIns(id))
+		}
+	})
+}
+
+/*
+TestMySQLStoreConformance runs the Store conformance suite against MySQLStore.
+Because this needs a real database whose schema was built by
+`gateway migrate up`, it only runs when the MYSQL_TEST_DSN environment
+variable is set, for example:
+
+	MYSQL_TEST_DSN="root:testpwd@tcp(127.0.0.1:3306)/infodb?parseTime=true"
+
+Every user and sign-in in that database is deleted before each test case.
+*/
+func TestMySQLStoreConformance(t *testing.T) {
+	dsn := os.Getenv("MYSQL_TEST_DSN")
+	if len(dsn) == 0 {
+		t.Skip("MYSQL_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	testStoreConformance(t, func(t *testing.T) (Store, func(id int64) int) {
+		for _, query := range []string{"DELETE FROM UserSignInLog", "DELETE FROM Users"} {
+			if _, err := db.Exec(query); err != nil {
+				t.Fatalf("Error clearing database: %v", err)
+			}
+		}
+		return NewMySQLStore(db), func(id int64) int {
+			count := 0
+			if err := db.QueryRow("SELECT COUNT(*) FROM UserSignInLog WHERE UserID = ?", id).Scan(&count); err != nil {
+				t.Fatalf("Error counting sign-ins: %v", err)
+			}
+			return count
+		}
+	})
+}
+
+// testStoreConformance checks the behavior every Store implementation must share
+func testStoreConformance(t *testing.T, newStore storeFactory) {
+	ctx := context.Background()
+
+	t.Run("Insert assigns distinct IDs", func(t *testing.T) {
+		store, _ := newStore(t)
+		first, err := store.Insert(ctx, conformanceUser("hawk@gmail.com", "hawk"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		second, err := store.Insert(ctx, conformanceUser("stanley@gmail.com", "stanley"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if first.ID <= 0 || second.ID <= 0 || first.ID == second.ID {
+			t.Errorf("Expected distinct positive IDs, but got %d and %d", first.ID, second.ID)
+		}
+	})
+
+	t.Run("Inserted users can be fetched", func(t *testing.T) {
+		store, _ := newStore(t)
+		user := conformanceUser("hawk@gmail.com", "hawk")
+		inserted, err := store.Insert(ctx, user)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+
+		byID, err := store.GetByID(ctx, inserted.ID)
+		if err != nil || !sameUser(byID, inserted) {
+			t.Errorf("GetByID: expected %+v, but got %+v (%v)", inserted, byID, err)
+		}
+		byEmail, err := store.GetByEmail(ctx, "Hawk@Gmail.com")
+		if err != nil || !sameUser(byEmail, inserted) {
+			t.Errorf("GetByEmail: expected %+v, but got %+v (%v)", inserted, byEmail, err)
+		}
+		byUserName, err := store.GetByUserName(ctx, "HAWK")
+		if err != nil || !sameUser(byUserName, inserted) {
+			t.Errorf("GetByUserName: expected %+v, but got %+v (%v)", inserted, byUserName, err)
+		}
+	})
+
+	t.Run("Emails are stored normalized", func(t *testing.T) {
+		store, _ := newStore(t)
+		inserted, err := store.Insert(ctx, conformanceUser(" Owl@Gmail.com", "owl"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if inserted.Email != "owl@gmail.com" {
+			t.Errorf("Expected the inserted email to be normalized, but got %q", inserted.Email)
+		}
+		byEmail, err := store.GetByEmail(ctx, "OWL@gmail.com ")
+		if err != nil || !sameUser(byEmail, inserted) {
+			t.Errorf("GetByEmail: expected %+v, but got %+v (%v)", inserted, byEmail, err)
+		}
+	})
+
+	t.Run("Missing users are not found", func(t *testing.T) {
+		store, _ := newStore(t)
+		if _, err := store.GetByID(ctx, 12345); err != ErrUserNotFound {
+			t.Errorf("GetByID: expected %v, but got %v instead", ErrUserNotFound, err)
+		}
+		if _, err := store.GetByEmail(ctx, "nobody@gmail.com"); err != ErrUserNotFound {
+			t.Errorf("GetByEmail: expected %v, but got %v instead", ErrUserNotFound, err)
+		}
+		if _, err := store.GetByUserName(ctx, "nobody"); err != ErrUserNotFound {
+			t.Errorf("GetByUserName: expected %v, but got %v instead", ErrUserNotFound, err)
+		}
+	})
+
+	t.Run("Duplicates are rejected", func(t *testing.T) {
+		store, _ := newStore(t)
+		if _, err := store.Insert(ctx, conformanceUser("hawk@gmail.com", "hawk")); err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if _, err := store.Insert(ctx, conformanceUser("HAWK@gmail.com", "someoneelse")); err != ErrEmailTaken {
+			t.Errorf("Duplicate email: expected %v, but got %v instead", ErrEmailTaken, err)
+		}
+		if _, err := store.Insert(ctx, conformanceUser("other@gmail.com", "Hаwk")); err != ErrUserNameTaken {
+			t.Errorf("Duplicate user name: expected %v, but got %v instead", ErrUserNameTaken, err)
+		}
+	})
+
+	t.Run("InsertAndLogUser records a sign-in", func(t *testing.T) {
+		store, signIns := newStore(t)
+		inserted, err := store.InsertAndLogUser(ctx, conformanceUser("hawk@gmail.com", "hawk"), time.Now(), "127.0.0.1")
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if count := signIns(inserted.ID); count != 1 {
+			t.Errorf("Expected 1 sign-in, but got %d", count)
+		}
+
+		// A conflicting user is neither inserted nor logged
+		if _, err := store.InsertAndLogUser(ctx, conformanceUser("hawk@gmail.com", "other"), time.Now(), "127.0.0.1"); err != ErrEmailTaken {
+			t.Errorf("Expected %v, but got %v instead", ErrEmailTaken, err)
+		}
+		if _, err := store.GetByUserName(ctx, "other"); err != ErrUserNotFound {
+			t.Errorf("Expected conflicting user not to be inserted, but got %v", err)
+		}
+	})
+
+	t.Run("LogUser records sign-ins", func(t *testing.T) {
+		store, signIns := newStore(t)
+		inserted, err := store.Insert(ctx, conformanceUser("hawk@gmail.com", "hawk"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := store.LogUser(ctx, inserted.ID, time.Now(), "127.0.0.1"); err != nil {
+				t.Errorf("Expected no error, but got %v instead", err)
+			}
+		}
+		if count := signIns(inserted.ID); count != 2 {
+			t.Errorf("Expected 2 sign-ins, but got %d", count)
+		}
+		if err := store.LogUser(ctx, inserted.ID+1000, time.Now(), "127.0.0.1"); err != ErrUserNotFound {
+			t.Errorf("Expected %v logging a sign-in for a missing user, but got %v instead", ErrUserNotFound, err)
+		}
+	})
+
+	t.Run("Update changes names", func(t *testing.T) {
+		store, _ := newStore(t)
+		inserted, err := store.Insert(ctx, conformanceUser("hawk@gmail.com", "hawk"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		updated, err := store.Update(ctx, inserted.ID, &Updates{"Stanley", "Wu"})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if updated.FirstName != "Stanley" || updated.LastName != "Wu" || updated.UserName != "hawk" {
+			t.Errorf("Expected updated names, but got %+v", updated)
+		}
+		fetched, _ := store.GetByID(ctx, inserted.ID)
+		if !sameUser(fetched, updated) {
+			t.Errorf("Expected update to be saved, but got %+v", fetched)
+		}
+		if _, err := store.Update(ctx, inserted.ID+1000, &Updates{"Stanley", "Wu"}); err != ErrUserNotFound {
+			t.Errorf("Expected %v, but got %v instead", ErrUserNotFound, err)
+		}
+	})
+
+	t.Run("Password and suspension can be changed", func(t *testing.T) {
+		store, _ := newStore(t)
+		inserted, err := store.Insert(ctx, conformanceUser("hawk@gmail.com", "hawk"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if inserted.Suspended {
+			t.Error("Expected new users not to be suspended")
+		}
+		if err := store.SetPassHash(ctx, inserted.ID, []byte("$2a$04$another.fake.hash")); err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if err := store.SetSuspended(ctx, inserted.ID, true); err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		fetched, _ := store.GetByID(ctx, inserted.ID)
+		if fetched == nil || string(fetched.PassHash) != "$2a$04$another.fake.hash" || !fetched.Suspended {
+			t.Errorf("Expected new password hash and suspension to be saved, but got %+v", fetched)
+		}
+		if err := store.SetSuspended(ctx, inserted.ID, false); err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if fetched, _ := store.GetByID(ctx, inserted.ID); fetched == nil || fetched.Suspended {
+			t.Errorf("Expected suspension to be lifted, but got %+v", fetched)
+		}
+		// Setting a value the user already has isn't mistaken for a missing user
+		if err := store.SetSuspended(ctx, inserted.ID, false); err != nil {
+			t.Errorf("Expected no error, but got %v instead", err)
+		}
+		if err := store.SetPassHash(ctx, inserted.ID+1000, []byte("$2a$04$another.fake.hash")); err != ErrUserNotFound {
+			t.Errorf("SetPassHash: expected %v, but got %v instead", ErrUserNotFound, err)
+		}
+		if err := store.SetSuspended(ctx, inserted.ID+1000, true); err != ErrUserNotFound {
+			t.Errorf("SetSuspended: expected %v, but got %v instead", ErrUserNotFound, err)
+		}
+	})
+
+	t.Run("Delete frees email and user name", func(t *testing.T) {
+		store, _ := newStore(t)
+		inserted, err := store.Insert(ctx, conformanceUser("hawk@gmail.com", "hawk"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if err := store.Delete(ctx, inserted.ID); err != nil {
+			t.Fatalf("Expected no error, but got %v instead", err)
+		}
+		if _, err := store.GetByID(ctx, inserted.ID); err != ErrUserNotFound {
+			t.Errorf("Expected %v, but got %v instead", ErrUserNotFound, err)
+		}
+		if _, err := store.Insert(ctx, conformanceUser("hawk@gmail.com", "hawk")); err != nil {
+			t.Errorf("Expected email and user name to be reusable, but got %v", err)
+		}
+		if err := store.Delete(ctx, inserted.ID); err != nil {
+			t.Errorf("Expected deleting a missing user not to fail, but got %v", err)
+		}
+	})
+
+	t.Run("Concurrent inserts of the same user name", func(t *testing.T) {
+		store, _ := newStore(t)
+		const attempts = 10
+		var wg sync.WaitGroup
+		errs := make(chan error, attempts)
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				email := string(rune('a'+i)) + "@gmail.com"
+				_, err := store.Insert(ctx, conformanceUser(email, "hawk"))
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		succeeded := 0
+		for err := range errs {
+			if err == nil {
+				succeeded++
+			} else if err != ErrUserNameTaken {
+				t.Errorf("Expected %v, but got %v instead", ErrUserNameTaken, err)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("Expected exactly 1 insert to succeed, but %d did", succeeded)
+		}
+	})
+}
+
+// conformanceUser returns a user that is ready to be inserted. A fixed
+// password hash is used to avoid the cost of bcrypt.
+func conformanceUser(email string, userName string) *User {
+	return &User{
+		Email:     email,
+		PassHash:  []byte("$2a$04$not.a.real.hash"),
+		UserName:  userName,
+		FirstName: "Hawk",
+		LastName:  "Ticehurst",
+		PhotoURL:  "photo.com",
+	}
+}
+
+// sameUser compares the fields of two users that every store round-trips
+func sameUser(a *User, b *User) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ID == b.ID && a.Email == b.Email && string(a.PassHash) == string(b.PassHash) &&
+		a.UserName == b.UserName && a.FirstName == b.FirstName && a.LastName == b.LastName &&
+		a.PhotoURL == b.PhotoURL && a.Suspended == b.Suspended
+}
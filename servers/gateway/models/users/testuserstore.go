@@ -1,7 +1,7 @@
 package users
 
 import (
-	"fmt"
+	"context"
 	"time"
 )
 
@@ -16,18 +16,18 @@ func NewTestUserStore(client string) *TestUserStore {
 }
 
 // GetByID returns the User with the given ID
-func (client *TestUserStore) GetByID(id int64) (*User, error) {
+func (client *TestUserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 	if id == 1 {
 		newUser := &NewUser{Email: "stanley@gmail.com", Password: "123456", PasswordConf: "123456", UserName: "swu", FirstName: "Stanley", LastName: "Wu"}
 		user, _ := newUser.ToUser()
 		user.ID = 1
 		return user, nil
 	}
-	return nil, fmt.Errorf("Error fetching selected user")
+	return nil, ErrUserNotFound
 }
 
 // GetByEmail returns the User with the given email
-func (client *TestUserStore) GetByEmail(email string) (*User, error) {
+func (client *TestUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	if email == "stanley@gmail.com" {
 		newUser := &NewUser{Email: "stanley@gmail.com", Password: "123456", PasswordConf: "123456", UserName: "swu", FirstName: "Stanley", LastName: "Wu"}
 		user, _ := newUser.ToUser()
@@ -36,32 +36,54 @@ func (client *TestUserStore) GetByEmail(email string) (*User, error) {
 	return nil, ErrUserNotFound
 }
 
-// GetByUserName returns the User with the given Username
-func (client *TestUserStore) GetByUserName(username string) (*User, error) {
-	return nil, nil
+// GetByUserName returns the User whose Username normalizes
+// to the same value as the given Username
+func (client *TestUserStore) GetByUserName(ctx context.Context, username string) (*User, error) {
+	if NormalizeUserName(username) == "swu" {
+		newUser := &NewUser{Email: "stanley@gmail.com", Password: "123456", PasswordConf: "123456", UserName: "swu", FirstName: "Stanley", LastName: "Wu"}
+		user, _ := newUser.ToUser()
+		return user, nil
+	}
+	return nil, ErrUserNotFound
 }
 
 // Insert inserts the user into the database, and returns
 // the newly-inserted User, complete with the DBMS-assigned ID
-func (client *TestUserStore) Insert(user *User) (*User, error) {
+func (client *TestUserStore) Insert(ctx context.Context, user *User) (*User, error) {
 	newUser := &NewUser{Email: "stanley@gmail.com", Password: "123456", PasswordConf: "123456", UserName: "swu", FirstName: "Stanley", LastName: "Wu"}
 	createdUser, _ := newUser.ToUser()
 	createdUser.ID = 1
 	return createdUser, nil
 }
 
+// InsertAndLogUser inserts the user and logs their first sign-in, and returns
+// the newly-inserted User, complete with the DBMS-assigned ID
+func (client *TestUserStore) InsertAndLogUser(ctx context.Context, user *User, time time.Time, clientIP string) (*User, error) {
+	return client.Insert(ctx, user)
+}
+
 // Update applies UserUpdates to the given user ID and returns the newly-updated user
-func (client *TestUserStore) Update(id int64, updates *Updates) (*User, error) {
+func (client *TestUserStore) Update(ctx context.Context, id int64, updates *Updates) (*User, error) {
 	return nil, nil
 }
 
 // Delete deletes the user with the given ID
-func (client *TestUserStore) Delete(id int64) error {
+func (client *TestUserStore) Delete(ctx context.Context, id int64) error {
+	return nil
+}
+
+// SetPassHash replaces the password hash of the user with the given ID
+func (client *TestUserStore) SetPassHash(ctx context.Context, id int64, passHash []byte) error {
+	return nil
+}
+
+// SetSuspended suspends the user with the given ID, or lifts their suspension
+func (client *TestUserStore) SetSuspended(ctx context.Context, id int64, suspended bool) error {
 	return nil
 }
 
 // LogUser logs a successful sign-in by a user with the user ID, curent time,
 // and user IP address
-func (client *TestUserStore) LogUser(id int64, time time.Time, clientIP string) error {
+func (client *TestUserStore) LogUser(ctx context.Context, id int64, time time.Time, clientIP string) error {
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"net/mail"
 	"strings"
 
@@ -26,6 +27,7 @@ type User struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 	PhotoURL  string `json:"photoURL"`
+	Suspended bool   `json:"-"` //never JSON encoded/decoded
 }
 
 // Credentials represents user sign-in credentials
@@ -66,6 +68,9 @@ func (nu *NewUser) Validate() error {
 	if len(nu.UserName) == 0 || strings.Contains(nu.UserName, " ") {
 		return fmt.Errorf("Username must be greater than 0 length and cannot contain spaces")
 	}
+	if IsReservedUserName(nu.UserName) {
+		return fmt.Errorf("Username is reserved")
+	}
 
 	return nil
 }
@@ -118,7 +123,7 @@ func (u *User) FullName() string {
 func (u *User) SetPassword(password string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), 13)
 	if err != nil {
-		fmt.Printf("error generating bcrypt hash: %v\n", err)
+		slog.Error("Error generating bcrypt hash", "error", err)
 		return err
 	}
 
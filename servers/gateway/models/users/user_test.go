@@ -19,6 +19,8 @@ func TestValidate(t *testing.T) {
 		{NewUser{"123@gmail.com", "123456", "1234567", "stanley", "Stanley", "Wu"}, "Password and password confirmation do not match"},
 		{NewUser{"123@gmail.com", "123456", "123456", "", "Stanley", "Wu"}, "Username must be greater than 0 length and cannot contain spaces"},
 		{NewUser{"123@gmail.com", "123456", "123456", "stanley ", "Stanley", "Wu"}, "Username must be greater than 0 length and cannot contain spaces"},
+		{NewUser{"123@gmail.com", "123456", "123456", "Admin", "Stanley", "Wu"}, "Username is reserved"},
+		{NewUser{"123@gmail.com", "123456", "123456", "rооt", "Stanley", "Wu"}, "Username is reserved"},
 	}
 
 	for _, c := range cases {
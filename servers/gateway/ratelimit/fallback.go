@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Fallback is a Limiter that takes tokens from Primary, and from Secondary
+// while Primary is failing. Pairing a RedisLimiter with a MemLimiter keeps
+// requests limited, per gateway, while redis can't be reached.
+type Fallback struct {
+	Primary   Limiter
+	Secondary Limiter
+	// failing is 1 while Primary is failing
+	failing int32
+}
+
+// Allow takes a token from the bucket of `rule` identified by `key`
+func (f *Fallback) Allow(ctx context.Context, rule *Rule, key string) (*Result, error) {
+	result, err := f.Primary.Allow(ctx, rule, key)
+	if err == nil {
+		if atomic.CompareAndSwapInt32(&f.failing, 1, 0) {
+			slog.InfoContext(ctx, "Rate limiter recovered")
+		}
+		return result, nil
+	}
+	// The client going away is not a failure of the limiter
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if atomic.CompareAndSwapInt32(&f.failing, 0, 1) {
+		slog.WarnContext(ctx, "Error limiting rate, falling back to memory", "error", err)
+	}
+	return f.Secondary.Allow(ctx, rule, key)
+}
@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingLimiter is a Limiter whose bucket store can't be reached
+type failingLimiter struct {
+	calls int
+}
+
+func (fl *failingLimiter) Allow(ctx context.Context, rule *Rule, key string) (*Result, error) {
+	fl.calls++
+	return nil, errors.New("connection refused")
+}
+
+func TestFallback(t *testing.T) {
+	rule := &Rule{Route: "/", Requests: 1, Period: time.Hour, By: ByIP}
+	primary := &failingLimiter{}
+	limiter := &Fallback{Primary: primary, Secondary: NewMemLimiter()}
+
+	result, err := limiter.Allow(context.Background(), rule, "ip:10.0.0.1")
+	if err != nil || !result.Allowed {
+		t.Fatalf("expected the secondary limiter to allow the request, but got %+v (%v)", result, err)
+	}
+	result, err = limiter.Allow(context.Background(), rule, "ip:10.0.0.1")
+	if err != nil || result.Allowed {
+		t.Errorf("expected the secondary limiter to keep limiting, but got %+v (%v)", result, err)
+	}
+	if primary.calls != 2 {
+		t.Errorf("expected the primary limiter to be tried every time, but got %d calls", primary.calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.Allow(ctx, rule, "ip:10.0.0.1"); err != context.Canceled {
+		t.Errorf("expected %v when the request is cancelled, but got %v", context.Canceled, err)
+	}
+}
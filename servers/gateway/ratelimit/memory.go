@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often a MemLimiter forgets buckets that have refilled
+const sweepInterval = time.Minute
+
+// roundingError is ignored when rounding waits up to whole milliseconds, so
+// that a wait of 1999.9999999 or 2000.0000001 milliseconds is 2 seconds
+const roundingError = 1e-6
+
+// bucket is the state of a token bucket at a point in time
+type bucket struct {
+	rule   *Rule
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket up to `now` and takes a token if there is one
+func (b *bucket) take(now time.Time) *Result {
+	rule := b.rule
+	rate := rule.perMillisecond()
+	elapsed := float64(now.Sub(b.last).Milliseconds())
+	if elapsed > 0 {
+		b.tokens = math.Min(rule.capacity(), b.tokens+elapsed*rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return &Result{Allowed: true, Remaining: int(b.tokens)}
+	}
+	wait := math.Ceil((1-b.tokens)/rate - roundingError)
+	return &Result{Allowed: false, RetryAfter: time.Duration(wait) * time.Millisecond}
+}
+
+// MemLimiter is a Limiter that keeps its buckets in process memory.
+// This should be used only for testing and running a single gateway;
+// replicas each using their own MemLimiter multiply the allowed rate.
+type MemLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// now returns the current time and is overridden in tests
+	now func() time.Time
+}
+
+// NewMemLimiter constructs a new MemLimiter
+func NewMemLimiter() *MemLimiter {
+	return &MemLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow takes a token from the bucket of `rule` identified by `key`
+func (ml *MemLimiter) Allow(ctx context.Context, rule *Rule, key string) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	now := ml.now()
+	ml.sweep(now)
+
+	id := rule.ID() + ":" + key
+	b, found := ml.buckets[id]
+	if !found {
+		b = &bucket{rule: rule, tokens: rule.capacity(), last: now}
+		ml.buckets[id] = b
+	}
+	return b.take(now), nil
+}
+
+// sweep removes buckets that have refilled completely since they were last
+// used, since they are the same as new buckets
+func (ml *MemLimiter) sweep(now time.Time) {
+	if now.Sub(ml.lastSweep) < sweepInterval {
+		return
+	}
+	ml.lastSweep = now
+	for id, b := range ml.buckets {
+		if b.tokens+float64(now.Sub(b.last).Milliseconds())*b.rule.perMillisecond() >= b.rule.capacity() {
+			delete(ml.buckets, id)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemLimiter(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewMemLimiter()
+	limiter.now = func() time.Time { return now }
+	ctx := context.Background()
+	// One token every 6 seconds, in bursts of up to 3
+	rule := &Rule{Route: "/v1/events", Requests: 10, Period: time.Minute, Burst: 3, By: ByUser}
+
+	for i := 2; i >= 0; i-- {
+		result, err := limiter.Allow(ctx, rule, "user:1")
+		if err != nil || !result.Allowed || result.Remaining != i {
+			t.Fatalf("expected request %d of the burst to be allowed with %d remaining, but got %+v (%v)", 3-i, i, result, err)
+		}
+	}
+
+	result, _ := limiter.Allow(ctx, rule, "user:1")
+	if result.Allowed || result.RetryAfter != 6*time.Second {
+		t.Errorf("expected request after the burst to wait 6s, but got %+v", result)
+	}
+	if result, _ := limiter.Allow(ctx, rule, "user:2"); !result.Allowed {
+		t.Errorf("expected another user to have their own bucket, but got %+v", result)
+	}
+
+	now = now.Add(4 * time.Second)
+	result, _ = limiter.Allow(ctx, rule, "user:1")
+	if result.Allowed || result.RetryAfter != 2*time.Second {
+		t.Errorf("expected a partly refilled bucket to wait 2s, but got %+v", result)
+	}
+	now = now.Add(2 * time.Second)
+	if result, _ := limiter.Allow(ctx, rule, "user:1"); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected a refilled token to be allowed, but got %+v", result)
+	}
+
+	// Buckets never hold more than their burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		limiter.Allow(ctx, rule, "user:1")
+	}
+	if result, _ := limiter.Allow(ctx, rule, "user:1"); result.Allowed {
+		t.Errorf("expected a bucket to hold at most 3 tokens, but got %+v", result)
+	}
+}
+
+func TestMemLimiterSweep(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewMemLimiter()
+	limiter.now = func() time.Time { return now }
+	ctx := context.Background()
+	rule := &Rule{Route: "/v1/events", Requests: 1, Period: time.Hour, By: ByIP}
+
+	limiter.Allow(ctx, rule, "ip:10.0.0.1")
+	now = now.Add(2 * sweepInterval)
+	limiter.Allow(ctx, rule, "ip:10.0.0.2")
+
+	// The first bucket is still refilling, so forgetting it would let the
+	// client make another request straight away
+	if len(limiter.buckets) != 2 {
+		t.Errorf("expected both buckets to be kept, but got %d", len(limiter.buckets))
+	}
+
+	now = now.Add(2 * time.Hour)
+	limiter.Allow(ctx, rule, "ip:10.0.0.3")
+	if _, found := limiter.buckets[rule.ID()+":ip:10.0.0.1"]; found || len(limiter.buckets) != 1 {
+		t.Errorf("expected refilled buckets to be forgotten, but got %d buckets", len(limiter.buckets))
+	}
+}
+
+func TestMemLimiterContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rule := &Rule{Route: "/", Requests: 1, Period: time.Second, By: ByIP}
+	if _, err := NewMemLimiter().Allow(ctx, rule, "ip:10.0.0.1"); err != context.Canceled {
+		t.Errorf("expected %v, but got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,126 @@
+// Package ratelimit limits how often clients may make requests using token
+// buckets. Each bucket holds up to Burst tokens and refills at Requests per
+// Period; every request takes a token and is rejected when none are left.
+// Buckets are kept in redis so that limits hold across gateway replicas, or
+// in memory for tests and local runs.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	// Allowed reports whether the request may proceed
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+	// RetryAfter is how long until a token is available, when the request
+	// is not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from the buckets of a rule
+type Limiter interface {
+	// Allow takes a token from the bucket of `rule` identified by `key`
+	Allow(ctx context.Context, rule *Rule, key string) (*Result, error)
+}
+
+// Subjects that requests can be limited by
+const (
+	// ByUser limits each signed in user, and each client IP address for
+	// requests without a session
+	ByUser = "user"
+	// ByIP limits each client IP address
+	ByIP = "ip"
+)
+
+// Rule limits requests to a route
+type Rule struct {
+	// Route is the request path pattern the rule applies to, as understood
+	// by http.ServeMux: patterns ending in a slash match every path below them
+	Route string
+	// Methods are the request methods the rule applies to. Empty means every method.
+	Methods []string
+	// Requests per Period is the rate at which tokens are added to a bucket.
+	// Period must be at least a millisecond.
+	Requests int
+	Period   time.Duration
+	// Burst is the size of a bucket, and defaults to Requests
+	Burst int
+	// By is the subject each bucket belongs to: ByUser or ByIP
+	By string
+}
+
+// Match returns the first of `rules` that applies to a `method` request for
+// `path`, or nil if none does
+func Match(rules []*Rule, method string, path string) *Rule {
+	for _, rule := range rules {
+		if rule.matches(method, path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the rule can't be applied
+func (rule *Rule) Validate() error {
+	if !strings.HasPrefix(rule.Route, "/") {
+		return fmt.Errorf("route %q must start with /", rule.Route)
+	}
+	if rule.Requests < 1 {
+		return fmt.Errorf("requests must be positive")
+	}
+	// Buckets are refilled per millisecond
+	if rule.Period < time.Millisecond {
+		return fmt.Errorf("period must be at least 1ms")
+	}
+	if rule.Burst < 0 {
+		return fmt.Errorf("burst can't be negative")
+	}
+	if rule.By != ByUser && rule.By != ByIP {
+		return fmt.Errorf("by must be %q or %q", ByUser, ByIP)
+	}
+	return nil
+}
+
+// ID identifies the rule within the keys of its buckets
+func (rule *Rule) ID() string {
+	return strings.Join(rule.Methods, ",") + " " + rule.Route
+}
+
+// capacity returns the size of the rule's buckets
+func (rule *Rule) capacity() float64 {
+	if rule.Burst > 0 {
+		return float64(rule.Burst)
+	}
+	return float64(rule.Requests)
+}
+
+// perMillisecond returns the rate at which tokens are added to the rule's buckets
+func (rule *Rule) perMillisecond() float64 {
+	return float64(rule.Requests) / float64(rule.Period.Milliseconds())
+}
+
+// matches reports whether the rule applies to a `method` request for `path`
+func (rule *Rule) matches(method string, path string) bool {
+	if strings.HasSuffix(rule.Route, "/") {
+		if !strings.HasPrefix(path, rule.Route) {
+			return false
+		}
+	} else if path != rule.Route {
+		return false
+	}
+	if len(rule.Methods) == 0 {
+		return true
+	}
+	for _, allowed := range rule.Methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	messages := &Rule{Route: "/v1/channels/", Methods: []string{"POST"}, Requests: 60, Period: time.Minute, By: ByUser}
+	events := &Rule{Route: "/v1/events", Methods: []string{"POST", "PATCH"}, Requests: 10, Period: time.Minute, By: ByUser}
+	everything := &Rule{Route: "/", Requests: 100, Period: time.Second, By: ByIP}
+	rules := []*Rule{messages, events, everything}
+
+	cases := []struct {
+		method   string
+		path     string
+		expected *Rule
+	}{
+		{"POST", "/v1/channels/1", messages},
+		{"post", "/v1/channels/1", messages},
+		{"GET", "/v1/channels/1", everything},
+		{"POST", "/v1/channels", everything},
+		{"PATCH", "/v1/events", events},
+		{"POST", "/v1/events/1", everything},
+		{"GET", "/healthz", everything},
+	}
+	for _, c := range cases {
+		if rule := Match(rules, c.method, c.path); rule != c.expected {
+			t.Errorf("%s %s: expected rule %+v, but got %+v", c.method, c.path, c.expected, rule)
+		}
+	}
+
+	if rule := Match(rules[:2], "GET", "/healthz"); rule != nil {
+		t.Errorf("expected no rule to match, but got %+v", rule)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		rule  *Rule
+		valid bool
+	}{
+		{"Valid", &Rule{Route: "/v1/events", Requests: 10, Period: time.Minute, By: ByUser}, true},
+		{"Relative Route", &Rule{Route: "v1/events", Requests: 10, Period: time.Minute, By: ByUser}, false},
+		{"No Requests", &Rule{Route: "/v1/events", Period: time.Minute, By: ByUser}, false},
+		{"No Period", &Rule{Route: "/v1/events", Requests: 10, By: ByUser}, false},
+		{"Period Under A Millisecond", &Rule{Route: "/v1/events", Requests: 10, Period: 500 * time.Microsecond, By: ByUser}, false},
+		{"Period Of A Millisecond", &Rule{Route: "/v1/events", Requests: 10, Period: time.Millisecond, By: ByUser}, true},
+		{"Negative Period", &Rule{Route: "/v1/events", Requests: 10, Period: -time.Minute, By: ByUser}, false},
+		{"Negative Burst", &Rule{Route: "/v1/events", Requests: 10, Period: time.Minute, Burst: -1, By: ByIP}, false},
+		{"No Subject", &Rule{Route: "/v1/events", Requests: 10, Period: time.Minute}, false},
+	}
+	for _, c := range cases {
+		if err := c.rule.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %s: expected valid to be %v, but got error %v", c.name, c.valid, err)
+		}
+	}
+}
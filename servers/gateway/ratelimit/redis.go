@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// DefaultOperationTimeout is the default upper bound on how long taking a
+// token from a RedisLimiter bucket may take
+const DefaultOperationTimeout = 500 * time.Millisecond
+
+// redisKeyPrefix is prepended to the ID of a bucket to form its redis key
+const redisKeyPrefix = "ratelimit:"
+
+// takeScript refills and takes a token from the bucket at KEYS[1] in one
+// atomic step, so that concurrent requests through different gateways can't
+// both take the last token. ARGV holds the rate in tokens per millisecond,
+// the capacity of the bucket and the current time in milliseconds. It returns
+// whether a token was taken, the whole tokens remaining, and the milliseconds
+// until a token is available, rounded as by MemLimiter. Buckets expire once
+// they would have refilled.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil or last == nil then
+	tokens = capacity
+	last = now
+end
+if now > last then
+	tokens = math.min(capacity, tokens + (now - last) * rate)
+	last = now
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate - 1e-6)
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", last)
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+return {allowed, math.floor(tokens), wait}
+`)
+
+// RedisLimiter is a Limiter that keeps its buckets in redis, so that every
+// gateway sharing the redis server enforces the same limits
+type RedisLimiter struct {
+	Client *redis.Client
+	// OperationTimeout bounds each operation. Zero means operations are
+	// only bounded by the context they are given.
+	OperationTimeout time.Duration
+	// now returns the current time and is overridden in tests
+	now func() time.Time
+}
+
+// NewRedisLimiter constructs a new RedisLimiter
+func NewRedisLimiter(client *redis.Client) *RedisLimiter {
+	return &RedisLimiter{Client: client, OperationTimeout: DefaultOperationTimeout, now: time.Now}
+}
+
+// Allow takes a token from the bucket of `rule` identified by `key`
+func (rl *RedisLimiter) Allow(ctx context.Context, rule *Rule, key string) (*Result, error) {
+	if rl.OperationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rl.OperationTimeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{
+		rule.perMillisecond(),
+		rule.capacity(),
+		rl.now().UnixNano() / int64(time.Millisecond),
+	}
+	type reply struct {
+		values interface{}
+		err    error
+	}
+	done := make(chan reply, 1)
+	go func() {
+		values, err := takeScript.Run(rl.Client.WithContext(ctx), []string{redisKeyPrefix + rule.ID() + ":" + key}, args...).Result()
+		done <- reply{values, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return parseTakeReply(r.values)
+	}
+}
+
+// parseTakeReply converts the reply of takeScript to a Result
+func parseTakeReply(reply interface{}) (*Result, error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, fmt.Errorf("unexpected reply from rate limit script: %v", reply)
+	}
+	ints := make([]int64, len(values))
+	for i, value := range values {
+		n, ok := value.(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected reply from rate limit script: %v", reply)
+		}
+		ints[i] = n
+	}
+	return &Result{
+		Allowed:    ints[0] == 1,
+		Remaining:  int(math.Max(0, float64(ints[1]))),
+		RetryAfter: time.Duration(ints[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+/*
+TestRedisLimiter tests that buckets kept in redis are shared by every
+RedisLimiter using the server, as they are by gateway replicas.
+
+The test uses a local instance of redis running on its default port (6379),
+or the address in the REDISADDR environment variable, and is skipped if
+redis can't be reached.
+*/
+func TestRedisLimiter(t *testing.T) {
+	redisaddr := os.Getenv("REDISADDR")
+	if len(redisaddr) == 0 {
+		redisaddr = "127.0.0.1:6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: redisaddr})
+	defer client.Close()
+	if err := client.Ping().Err(); err != nil {
+		t.Skipf("redis is not reachable at %s: %v", redisaddr, err)
+	}
+
+	now := time.Now()
+	replicas := []*RedisLimiter{NewRedisLimiter(client), NewRedisLimiter(client)}
+	for _, replica := range replicas {
+		replica.now = func() time.Time { return now }
+	}
+	ctx := context.Background()
+	rule := &Rule{Route: "/v1/events", Requests: 10, Period: time.Minute, Burst: 2, By: ByUser}
+	key := "test:" + strconv.FormatInt(now.UnixNano(), 10)
+	defer client.Del(redisKeyPrefix + rule.ID() + ":" + key)
+
+	for i, replica := range replicas {
+		result, err := replica.Allow(ctx, rule, key)
+		if err != nil || !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("expected request %d to be allowed with %d remaining, but got %+v (%v)", i+1, 1-i, result, err)
+		}
+	}
+	result, err := replicas[0].Allow(ctx, rule, key)
+	if err != nil || result.Allowed || result.RetryAfter != 6*time.Second {
+		t.Errorf("expected the bucket shared by both replicas to be empty for 6s, but got %+v (%v)", result, err)
+	}
+
+	now = now.Add(6 * time.Second)
+	if result, err := replicas[1].Allow(ctx, rule, key); err != nil || !result.Allowed {
+		t.Errorf("expected a refilled token to be allowed, but got %+v (%v)", result, err)
+	}
+	if ttl := client.PTTL(redisKeyPrefix + rule.ID() + ":" + key).Val(); ttl <= 0 || ttl > 13*time.Second {
+		t.Errorf("expected the bucket to expire once it would have refilled, but got a TTL of %v", ttl)
+	}
+}
+
+// TestRedisLimiterContext tests that operations give up once their context
+// is done, without needing a running redis server
+func TestRedisLimiterContext(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	defer client.Close()
+	limiter := NewRedisLimiter(client)
+	rule := &Rule{Route: "/", Requests: 1, Period: time.Second, By: ByIP}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.Allow(ctx, rule, "ip:10.0.0.1"); err != context.Canceled {
+		t.Errorf("expected %v, but got %v", context.Canceled, err)
+	}
+	if _, err := limiter.Allow(context.Background(), rule, "ip:10.0.0.1"); err == nil {
+		t.Errorf("expected an error when redis can't be reached")
+	}
+}
+
+func TestParseTakeReply(t *testing.T) {
+	result, err := parseTakeReply([]interface{}{int64(0), int64(0), int64(1500)})
+	if err != nil || result.Allowed || result.RetryAfter != 1500*time.Millisecond {
+		t.Errorf("unexpected result %+v (%v)", result, err)
+	}
+	if _, err := parseTakeReply([]interface{}{int64(1), "0"}); err == nil {
+		t.Errorf("expected an error for a malformed reply")
+	}
+}
@@ -1,6 +1,7 @@
 package sessions
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -24,7 +25,7 @@ func NewMemStore(sessionDuration time.Duration, purgeInterval time.Duration) *Me
 // Save saves the provided `sessionState` and associated SessionID to the store.
 // The `sessionState` parameter is typically a pointer to a struct containing
 // all the data you want to associated with the given SessionID.
-func (ms *MemStore) Save(sid SessionID, state interface{}) error {
+func (ms *MemStore) Save(ctx context.Context, sid SessionID, state interface{}) error {
 	j, err := json.Marshal(state)
 	if nil != err {
 		return err
@@ -35,7 +36,7 @@ func (ms *MemStore) Save(sid SessionID, state interface{}) error {
 
 // Get populates `sessionState` with the data previously saved
 // for the given SessionID
-func (ms *MemStore) Get(sid SessionID, state interface{}) error {
+func (ms *MemStore) Get(ctx context.Context, sid SessionID, state interface{}) error {
 	j, found := ms.entries.Get(sid.String())
 	if !found {
 		return ErrStateNotFound
@@ -46,7 +47,7 @@ func (ms *MemStore) Get(sid SessionID, state interface{}) error {
 }
 
 // Delete deletes all state data associated with the SessionID from the store.
-func (ms *MemStore) Delete(sid SessionID) error {
+func (ms *MemStore) Delete(ctx context.Context, sid SessionID) error {
 	ms.entries.Delete(sid.String())
 	return nil
 }
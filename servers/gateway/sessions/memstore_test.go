@@ -1,6 +1,7 @@
 package sessions
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 	"testing"
@@ -27,6 +28,7 @@ func TestMemStore(t *testing.T) {
 		Ival: 99,
 	}
 	stateRet := &sessionState{}
+	ctx := context.Background()
 
 	sid, err := NewSessionID("test key")
 	if err != nil {
@@ -35,15 +37,15 @@ func TestMemStore(t *testing.T) {
 
 	store := NewMemStore(time.Hour, time.Minute)
 
-	if err := store.Get(sid, stateRet); err != ErrStateNotFound {
+	if err := store.Get(ctx, sid, stateRet); err != ErrStateNotFound {
 		t.Errorf("incorrect error when getting state that was never stored: expected %v but got %v", ErrStateNotFound, err)
 	}
 
-	if err := store.Save(sid, &state); err != nil {
+	if err := store.Save(ctx, sid, &state); err != nil {
 		t.Fatalf("error saving state: %v", err)
 	}
 
-	if err := store.Get(sid, &stateRet); err != nil {
+	if err := store.Get(ctx, sid, &stateRet); err != nil {
 		t.Fatalf("error getting state: %v", err)
 	}
 	if !reflect.DeepEqual(state, stateRet) {
@@ -52,11 +54,11 @@ func TestMemStore(t *testing.T) {
 		t.Errorf("incorrect state retrieved:\nEXPECTED\n%s\nACTUAL\n%s", string(jexp), string(jact))
 	}
 
-	if err := store.Delete(sid); err != nil {
+	if err := store.Delete(ctx, sid); err != nil {
 		t.Errorf("error deleting state: %v", err)
 	}
 
-	if err := store.Get(sid, &stateRet); err != ErrStateNotFound {
+	if err := store.Get(ctx, sid, &stateRet); err != ErrStateNotFound {
 		t.Fatalf("incorrect error when getting state that was deleted: expected %v but got %v", ErrStateNotFound, err)
 	}
 }
@@ -71,7 +73,7 @@ func TestMemStoreSaveUnmarshalble(t *testing.T) {
 		t.Fatalf("error generating new SessionID: %v", err)
 	}
 	store := NewMemStore(time.Hour, time.Minute)
-	if err := store.Save(sid, state); err == nil {
+	if err := store.Save(context.Background(), sid, state); err == nil {
 		t.Error("expected error when attempting to save a session state with an unmarshalable field")
 	}
 }
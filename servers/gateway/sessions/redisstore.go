@@ -1,28 +1,44 @@
 package sessions
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
 )
 
+// DefaultOperationTimeout is the default upper bound on how long a single
+// RedisStore operation may take, in addition to any deadline on its context
+const DefaultOperationTimeout = 2 * time.Second
+
+// redisKeyPrefix is prepended to a SessionID to form its redis key
+const redisKeyPrefix = "sid:"
+
+// scanCount is how many keys IDs asks redis to examine per SCAN call
+const scanCount = 100
+
 // RedisStore represents a session.Store backed by redis
 type RedisStore struct {
 	Client          *redis.Client
 	SessionDuration time.Duration
+	// OperationTimeout bounds each operation. Zero means operations are
+	// only bounded by the context they are given.
+	OperationTimeout time.Duration
 }
 
 // NewRedisStore constructs a new RedisStore
 func NewRedisStore(client *redis.Client, sessionDuration time.Duration) *RedisStore {
-	return &RedisStore{client, sessionDuration}
+	return &RedisStore{client, sessionDuration, DefaultOperationTimeout}
 }
 
 // Save saves the provided `sessionState` and associated SessionID to the store.
 // The `sessionState` parameter is typically a pointer to a struct containing
 // all the data you want to be associated with the given SessionID.
-func (rs *RedisStore) Save(sid SessionID, sessionState interface{}) error {
+func (rs *RedisStore) Save(ctx context.Context, sid SessionID, sessionState interface{}) error {
 	// Marshal sessionState to JSON
 	buffer, err := json.Marshal(sessionState)
 	if err != nil {
@@ -30,49 +46,148 @@ func (rs *RedisStore) Save(sid SessionID, sessionState interface{}) error {
 	}
 
 	// Save sessionState to the redis database
-	err = rs.Client.Set(sid.getRedisKey(), buffer, 0).Err()
+	err = rs.do(ctx, func(client *redis.Client) error {
+		return client.Set(sid.getRedisKey(), buffer, 0).Err()
+	})
 	if err != nil {
-		fmt.Println(err)
+		slog.ErrorContext(ctx, "Error saving session state", "error", err)
+		return err
 	}
 
 	return nil
 }
 
 // Get populates `sessionState` with the data previously saved
-// for the given SessionID
-func (rs *RedisStore) Get(sid SessionID, sessionState interface{}) error {
-	val, err := rs.Client.Get(sid.getRedisKey()).Result()
+// for the given SessionID, and refreshes it
+func (rs *RedisStore) Get(ctx context.Context, sid SessionID, sessionState interface{}) error {
+	val, err := rs.read(ctx, sid, sessionState)
 	if err != nil {
-		return ErrStateNotFound
-	}
-
-	// Turn the value into the sessionState decoded JSON parameter
-	buffer := []byte(val)
-	if err := json.Unmarshal(buffer, sessionState); err != nil {
-		fmt.Printf("error unmarshaling JSON: %v\n", err)
 		return err
 	}
 
-	err = rs.Client.Set(sid.getRedisKey(), val, 0).Err()
+	err = rs.do(ctx, func(client *redis.Client) error {
+		return client.Set(sid.getRedisKey(), val, 0).Err()
+	})
 	if err != nil {
-		fmt.Println(err)
+		slog.ErrorContext(ctx, "Error refreshing session state", "error", err)
 		return err
 	}
 
 	return nil
 }
 
+// Peek populates `sessionState` like Get, but without refreshing the
+// session, so that inspecting sessions doesn't extend them
+func (rs *RedisStore) Peek(ctx context.Context, sid SessionID, sessionState interface{}) error {
+	_, err := rs.read(ctx, sid, sessionState)
+	return err
+}
+
+// read decodes the state saved for the given SessionID into `sessionState`,
+// and returns it as saved
+func (rs *RedisStore) read(ctx context.Context, sid SessionID, sessionState interface{}) (string, error) {
+	var val string
+	err := rs.do(ctx, func(client *redis.Client) error {
+		var err error
+		val, err = client.Get(sid.getRedisKey()).Result()
+		return err
+	})
+	if err == redis.Nil {
+		return "", ErrStateNotFound
+	} else if err == context.Canceled || err == context.DeadlineExceeded {
+		return "", err
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error getting session state", "error", err)
+		return "", fmt.Errorf("Error getting session state: %w", err)
+	}
+
+	// Turn the value into the sessionState decoded JSON parameter
+	if err := json.Unmarshal([]byte(val), sessionState); err != nil {
+		slog.ErrorContext(ctx, "Error decoding session state", "error", err)
+		return "", err
+	}
+	return val, nil
+}
+
 // Delete deletes all state data associated with the SessionID from the store.
-func (rs *RedisStore) Delete(sid SessionID) error {
-	err := rs.Client.Del(sid.getRedisKey()).Err()
-	if err != nil {
-		return ErrStateNotFound
+func (rs *RedisStore) Delete(ctx context.Context, sid SessionID) error {
+	err := rs.do(ctx, func(client *redis.Client) error {
+		return client.Del(sid.getRedisKey()).Err()
+	})
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error deleting session state", "error", err)
+		return fmt.Errorf("Error deleting session state: %w", err)
 	}
 
 	return nil
 }
 
+// IDs returns the IDs of every session in the store. The keyspace is scanned
+// incrementally with SCAN so that redis isn't blocked while listing.
+func (rs *RedisStore) IDs(ctx context.Context) ([]SessionID, error) {
+	ids := []SessionID{}
+	err := rs.do(ctx, func(client *redis.Client) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(cursor, redisKeyPrefix+"*", scanCount).Result()
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				ids = append(ids, SessionID(strings.TrimPrefix(key, redisKeyPrefix)))
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Ping checks that redis can be reached
+func (rs *RedisStore) Ping(ctx context.Context) error {
+	return rs.do(ctx, func(client *redis.Client) error {
+		return client.Ping().Err()
+	})
+}
+
+// do runs `op` against a client bound to `ctx`, limited by the store's
+// operation timeout. The redis client does not interrupt commands when
+// their context is done, so do returns the context's error as soon as it
+// is done rather than waiting for the command to finish. The abandoned
+// command keeps its goroutine and connection until it finishes or fails,
+// which the client's own dial, read and write timeouts bound.
+func (rs *RedisStore) do(ctx context.Context, op func(client *redis.Client) error) error {
+	if rs.OperationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rs.OperationTimeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- op(rs.Client.WithContext(ctx))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // getRedisKey returns the redis key to use for the SessionID
 func (sid SessionID) getRedisKey() string {
-	return "sid:" + sid.String()
+	return redisKeyPrefix + sid.String()
 }
@@ -1,6 +1,7 @@
 package sessions
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 	"testing"
@@ -33,6 +34,7 @@ func TestRedisStore(t *testing.T) {
 		Ival: 99,
 	}
 	stateRet := &sessionState{}
+	ctx := context.Background()
 
 	sid, err := NewSessionID("test key")
 	if err != nil {
@@ -50,21 +52,25 @@ func TestRedisStore(t *testing.T) {
 
 	store := NewRedisStore(client, time.Hour)
 
-	if err := store.Get(sid, stateRet); err != ErrStateNotFound {
+	if err := store.Get(ctx, sid, stateRet); err != ErrStateNotFound {
 		t.Errorf("incorrect error when getting state that was never stored: expected %v but got %v", ErrStateNotFound, err)
 	}
 
-	if err := store.Save(sid, &state); err != nil {
+	if err := store.Save(ctx, sid, &state); err != nil {
 		t.Fatalf("error saving state: %v", err)
 	}
 
 	// verify that trying to save an unmarshalable session state
 	// generates an error (function values can't be encoded in JSON)
-	if err := store.Save(sid, func() {}); err == nil {
+	if err := store.Save(ctx, sid, func() {}); err == nil {
 		t.Error("expected erorr when attempting to save an unmarshalable session state")
 	}
 
-	if err := store.Get(sid, &stateRet); err != nil {
+	peeked := &sessionState{}
+	if err := store.Peek(ctx, sid, peeked); err != nil || !reflect.DeepEqual(state, peeked) {
+		t.Errorf("incorrect state peeked: expected %+v but got %+v (%v)", state, peeked, err)
+	}
+	if err := store.Get(ctx, sid, &stateRet); err != nil {
 		t.Fatalf("error getting state: %v", err)
 	}
 	if !reflect.DeepEqual(state, stateRet) {
@@ -73,11 +79,81 @@ func TestRedisStore(t *testing.T) {
 		t.Errorf("incorrect state retrieved:\nEXPECTED\n%s\nACTUAL\n%s", string(jexp), string(jact))
 	}
 
-	if err := store.Delete(sid); err != nil {
+	ids, err := store.IDs(ctx)
+	if err != nil {
+		t.Fatalf("error listing session IDs: %v", err)
+	}
+	listed := false
+	for _, id := range ids {
+		listed = listed || id == sid
+	}
+	if !listed {
+		t.Errorf("saved session ID %v was not listed in %v", sid, ids)
+	}
+
+	if err := store.Delete(ctx, sid); err != nil {
 		t.Errorf("error deleting state: %v", err)
 	}
 
-	if err := store.Get(sid, &stateRet); err != ErrStateNotFound {
+	if err := store.Get(ctx, sid, &stateRet); err != ErrStateNotFound {
 		t.Fatalf("incorrect error when getting state that was deleted: expected %v but got %v", ErrStateNotFound, err)
 	}
 }
+
+// TestRedisStoreContext checks that RedisStore operations give up as soon as
+// their context is done, without needing a running redis server
+func TestRedisStoreContext(t *testing.T) {
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr: "127.0.0.1:1",
+	})
+	store := NewRedisStore(client, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := store.Save(ctx, sid, "state"); err != context.Canceled {
+		t.Errorf("incorrect error when saving with a cancelled context: expected %v but got %v", context.Canceled, err)
+	}
+	state := ""
+	if err := store.Get(ctx, sid, &state); err != context.Canceled {
+		t.Errorf("incorrect error when getting with a cancelled context: expected %v but got %v", context.Canceled, err)
+	}
+	if err := store.Ping(ctx); err != context.Canceled {
+		t.Errorf("incorrect error when pinging with a cancelled context: expected %v but got %v", context.Canceled, err)
+	}
+	if err := store.Ping(context.Background()); err == nil {
+		t.Error("expected an error when pinging an unreachable redis server")
+	}
+}
+
+// TestRedisStoreUnavailable checks that failing to reach redis is reported
+// as an error rather than as the session not being found, so that clients
+// aren't told their session expired during an outage
+func TestRedisStoreUnavailable(t *testing.T) {
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+	})
+	store := NewRedisStore(client, time.Hour)
+
+	state := ""
+	if err := store.Get(context.Background(), sid, &state); err == nil || err == ErrStateNotFound {
+		t.Errorf("expected an error other than %v when getting state from an unreachable redis server, but got %v", ErrStateNotFound, err)
+	}
+	if err := store.Peek(context.Background(), sid, &state); err == nil || err == ErrStateNotFound {
+		t.Errorf("expected an error other than %v when peeking at state on an unreachable redis server, but got %v", ErrStateNotFound, err)
+	}
+	if err := store.Delete(context.Background(), sid); err == nil || err == ErrStateNotFound {
+		t.Errorf("expected an error other than %v when deleting state from an unreachable redis server, but got %v", ErrStateNotFound, err)
+	}
+}
@@ -1,8 +1,10 @@
 package sessions
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strings"
 )
 
 const headerAuthorization = "Authorization"
@@ -17,14 +19,16 @@ var ErrInvalidScheme = errors.New("authorization scheme not supported")
 
 // BeginSession creates a new SessionID, saves the `sessionState` to the store, adds an
 // Authorization header to the response with the SessionID, and returns the new SessionID
-func BeginSession(signingKey string, store Store, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
+func BeginSession(ctx context.Context, signingKey string, store Store, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
 	// Create new sessionID
 	mySessionID, err := NewSessionID(signingKey)
 	if err != nil {
 		return InvalidSessionID, err
 	}
 	// Save sessionID and state to the store
-	store.Save(mySessionID, sessionState)
+	if err := store.Save(ctx, mySessionID, sessionState); err != nil {
+		return InvalidSessionID, err
+	}
 	// Set authorization
 	w.Header().Set(headerAuthorization, schemeBearer+string(mySessionID))
 
@@ -45,9 +49,11 @@ func GetSessionID(r *http.Request, signingKey string) (SessionID, error) {
 		authHeader = authParams[0]
 	}
 
-	authHeader = authHeader[7:]
+	if !strings.HasPrefix(authHeader, schemeBearer) {
+		return InvalidSessionID, ErrInvalidScheme
+	}
 
-	mySessionID, err := ValidateID(authHeader, signingKey)
+	mySessionID, err := ValidateID(strings.TrimPrefix(authHeader, schemeBearer), signingKey)
 	if err != nil {
 		return InvalidSessionID, err
 	}
@@ -57,7 +63,8 @@ func GetSessionID(r *http.Request, signingKey string) (SessionID, error) {
 
 // GetState extracts the SessionID from the request,
 // gets the associated state from the provided store into
-// the `sessionState` parameter, and returns the SessionID
+// the `sessionState` parameter, and returns the SessionID.
+// The store is queried using the request's context.
 func GetState(r *http.Request, signingKey string, store Store, sessionState interface{}) (SessionID, error) {
 	// Extract session id
 	mySessionID, err := GetSessionID(r, signingKey)
@@ -65,7 +72,7 @@ func GetState(r *http.Request, signingKey string, store Store, sessionState inte
 		return InvalidSessionID, err
 	}
 	// Extract the session state
-	err = store.Get(mySessionID, sessionState)
+	err = store.Get(r.Context(), mySessionID, sessionState)
 	if err != nil {
 		return InvalidSessionID, err
 	}
@@ -75,7 +82,7 @@ func GetState(r *http.Request, signingKey string, store Store, sessionState inte
 
 // EndSession extracts the SessionID from the request,
 // and deletes the associated data in the provided store, returning
-// the extracted SessionID.
+// the extracted SessionID and any error deleting the data.
 func EndSession(r *http.Request, signingKey string, store Store) (SessionID, error) {
 	// Extracts the sessionID from the http request
 	mySessionID, err := GetSessionID(r, signingKey)
@@ -83,7 +90,9 @@ func EndSession(r *http.Request, signingKey string, store Store) (SessionID, err
 		return InvalidSessionID, err
 	}
 	// Deletes the associated data
-	store.Delete(mySessionID)
+	if err := store.Delete(r.Context(), mySessionID); err != nil {
+		return mySessionID, err
+	}
 
 	return mySessionID, nil
 }
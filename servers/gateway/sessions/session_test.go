@@ -1,6 +1,7 @@
 package sessions
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -39,6 +40,12 @@ func TestSessionGetSessionID(t *testing.T) {
 			string(sid),
 			true,
 		},
+		{
+			"Short Header",
+			"Remember to check the scheme prefix before removing it",
+			"Bad",
+			true,
+		},
 		{
 			"Invalid SessionID",
 			"Remember to validate the id before returning it",
@@ -106,13 +113,13 @@ func TestSessionCycle(t *testing.T) {
 
 	// try beginning a session with an empty session signing key
 	// and ensure it fails
-	_, err = BeginSession("", store, state, respRec)
+	_, err = BeginSession(context.Background(), "", store, state, respRec)
 	if err == nil {
 		t.Error("expected error when beginning a new session with an empty signing key")
 	}
 
 	// then try with a valid signing key and make sure it works
-	sid, err := BeginSession(key, store, state, respRec)
+	sid, err := BeginSession(context.Background(), key, store, state, respRec)
 	if err != nil {
 		t.Fatalf("error beginning session: %v", err)
 	}
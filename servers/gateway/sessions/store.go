@@ -1,6 +1,7 @@
 package sessions
 
 import (
+	"context"
 	"errors"
 )
 
@@ -13,16 +14,18 @@ var ErrStateNotFound = errors.New("no session state was found in the session sto
 // against several different types of data stores. For example,
 // session data could be stored in memory in a concurrent map,
 // or more typically in a shared key/value server store like redis.
+// Every operation takes a context so that it is abandoned when the
+// request it serves is cancelled or times out.
 type Store interface {
 	// Save saves the provided `sessionState` and associated SessionID to the store.
 	// The `sessionState` parameter is typically a pointer to a struct containing
 	// all the data you want to associated with the given SessionID.
-	Save(sid SessionID, sessionState interface{}) error
+	Save(ctx context.Context, sid SessionID, sessionState interface{}) error
 
 	// Get populates `sessionState` with the data previously saved
 	// for the given SessionID
-	Get(sid SessionID, sessionState interface{}) error
+	Get(ctx context.Context, sid SessionID, sessionState interface{}) error
 
 	// Delete deletes all state data associated with the SessionID from the store.
-	Delete(sid SessionID) error
+	Delete(ctx context.Context, sid SessionID) error
 }
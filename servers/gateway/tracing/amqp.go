@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// AMQPHeaders carries trace context in the headers of a RabbitMQ message.
+// It implements propagation.TextMapCarrier, so the trace of the service that
+// published a message can be continued by its consumer:
+//
+//	ctx := otel.GetTextMapPropagator().Extract(ctx, tracing.AMQPHeaders(msg.Headers))
+type AMQPHeaders amqp.Table
+
+// Get returns the value of the header `key`, or an empty string if it is
+// not set
+func (h AMQPHeaders) Get(key string) string {
+	switch value := h[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// Set sets the header `key` to `value`
+func (h AMQPHeaders) Set(key string, value string) {
+	h[key] = value
+}
+
+// Keys returns the names of every header
+func (h AMQPHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAMQPHeaders(t *testing.T) {
+	propagator := propagation.TraceContext{}
+
+	// Publishers may send headers as strings or bytes
+	for _, value := range []interface{}{clientTraceparent, []byte(clientTraceparent)} {
+		headers := amqp.Table{"traceparent": value, "x-retries": int32(2)}
+		ctx := propagator.Extract(context.Background(), AMQPHeaders(headers))
+		if traceID := trace.SpanContextFromContext(ctx).TraceID().String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected the trace to be extracted from %T headers, but got trace ID %s", value, traceID)
+		}
+	}
+
+	ctx := propagator.Extract(context.Background(), AMQPHeaders(nil))
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		t.Errorf("expected no trace from a message without headers")
+	}
+
+	headers := AMQPHeaders{}
+	propagator.Inject(propagator.Extract(context.Background(), AMQPHeaders{"traceparent": clientTraceparent}), headers)
+	if headers.Get("traceparent") != clientTraceparent || len(headers.Keys()) != 1 {
+		t.Errorf("expected the trace to be injected into the headers, but got %v", headers)
+	}
+	if (AMQPHeaders{"x-retries": int32(2)}).Get("x-retries") != "2" {
+		t.Errorf("expected other header types to be formatted")
+	}
+}
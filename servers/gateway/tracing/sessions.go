@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"serverside-final-project/servers/gateway/sessions"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of this package
+var tracer = otel.Tracer("serverside-final-project/servers/gateway/tracing")
+
+// tracedStore is a sessions.Store that creates a span for each operation of
+// the Store it wraps
+type tracedStore struct {
+	store sessions.Store
+}
+
+// InstrumentStore returns a sessions.Store that creates a span for every
+// operation of `store`
+func InstrumentStore(store sessions.Store) sessions.Store {
+	return &tracedStore{store}
+}
+
+// Save saves the session state to the wrapped store
+func (ts *tracedStore) Save(ctx context.Context, sid sessions.SessionID, sessionState interface{}) error {
+	ctx, span := tracer.Start(ctx, "sessions.Save", trace.WithSpanKind(trace.SpanKindClient))
+	err := ts.store.Save(ctx, sid, sessionState)
+	endStoreSpan(span, err)
+	return err
+}
+
+// Get gets the session state from the wrapped store
+func (ts *tracedStore) Get(ctx context.Context, sid sessions.SessionID, sessionState interface{}) error {
+	ctx, span := tracer.Start(ctx, "sessions.Get", trace.WithSpanKind(trace.SpanKindClient))
+	err := ts.store.Get(ctx, sid, sessionState)
+	endStoreSpan(span, err)
+	return err
+}
+
+// Delete deletes the session state from the wrapped store
+func (ts *tracedStore) Delete(ctx context.Context, sid sessions.SessionID) error {
+	ctx, span := tracer.Start(ctx, "sessions.Delete", trace.WithSpanKind(trace.SpanKindClient))
+	err := ts.store.Delete(ctx, sid)
+	endStoreSpan(span, err)
+	return err
+}
+
+// endStoreSpan records `err` on `span` and ends it. Sessions that aren't
+// found are not errors of the store.
+func endStoreSpan(span trace.Span, err error) {
+	if err != nil && err != sessions.ErrStateNotFound {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"serverside-final-project/servers/gateway/sessions"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestInstrumentStore(t *testing.T) {
+	recorder := recordSpans(t)
+	store := InstrumentStore(sessions.NewMemStore(time.Hour, time.Hour))
+	ctx := context.Background()
+	sid, err := sessions.NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating session ID: %v", err)
+	}
+
+	state := ""
+	if err := store.Get(ctx, sid, &state); err != sessions.ErrStateNotFound {
+		t.Errorf("expected %v from the wrapped store, but got %v", sessions.ErrStateNotFound, err)
+	}
+	if err := store.Save(ctx, sid, "state"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store.Delete(ctx, sid)
+	// The state can't be decoded into an int
+	number := 0
+	store.Save(ctx, sid, "state")
+	if err := store.Get(ctx, sid, &number); err == nil {
+		t.Errorf("expected an error decoding the state")
+	}
+
+	spans := recorder.Ended()
+	expected := []struct {
+		name   string
+		status codes.Code
+	}{
+		{"sessions.Get", codes.Unset},
+		{"sessions.Save", codes.Unset},
+		{"sessions.Delete", codes.Unset},
+		{"sessions.Save", codes.Unset},
+		{"sessions.Get", codes.Error},
+	}
+	if len(spans) != len(expected) {
+		t.Fatalf("expected %d spans, but got %d", len(expected), len(spans))
+	}
+	for i, span := range spans {
+		if span.Name() != expected[i].name || span.Status().Code != expected[i].status {
+			t.Errorf("span %d: expected %s with status %v, but got %s with status %v", i, expected[i].name, expected[i].status, span.Name(), span.Status().Code)
+		}
+	}
+}
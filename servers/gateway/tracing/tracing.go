@@ -0,0 +1,98 @@
+// Package tracing sets up OpenTelemetry tracing for the gateway. Spans are
+// exported over OTLP/HTTP to a collector, and W3C trace context is
+// propagated to upstream services and read from RabbitMQ messages, so that a
+// request can be followed from the client through every service it reaches.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies the gateway in traces
+const ServiceName = "gateway"
+
+// Options configure tracing
+type Options struct {
+	// Endpoint is the URL of the OTLP/HTTP collector spans are exported to,
+	// such as http://localhost:4318. Spans are not recorded when it is empty.
+	Endpoint string
+	// SampleRatio is the fraction of traces started by the gateway that are
+	// recorded. Traces started by a client are recorded if the client
+	// recorded them.
+	SampleRatio float64
+}
+
+// Setup installs the W3C trace context propagator and, if an endpoint is
+// configured, a tracer provider exporting spans to it. The returned function
+// flushes any buffered spans and stops exporting.
+func Setup(ctx context.Context, opts Options) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if len(opts.Endpoint) == 0 {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(opts.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware returns middleware that starts a server span for each request
+// handled by `next`, continuing the trace of the client if its request
+// carries a traceparent header. Spans are named after the method and the
+// pattern of the route in `routes` that the request matches.
+func Middleware(routes *http.ServeMux, next http.Handler) http.Handler {
+	// The route is known before the span starts, but can only be recorded
+	// as an attribute once it has
+	withRoute := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := route(routes, r); len(route) > 0 {
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(route))
+		}
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(withRoute, ServiceName, otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+		if route := route(routes, r); len(route) > 0 {
+			return r.Method + " " + route
+		}
+		return r.Method
+	}))
+}
+
+// Transport returns a RoundTripper that sends requests with `base` within a
+// client span, adding a traceparent header for the upstream service to
+// continue the trace. Requests made outside of a trace, such as health
+// probes, are sent without a span.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return trace.SpanContextFromContext(r.Context()).IsValid()
+		}),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method + " " + r.URL.Host
+		}),
+	)
+}
+
+// route returns the pattern of the route in `routes` that `r` matches, or
+// an empty string if it matches none
+func route(routes *http.ServeMux, r *http.Request) string {
+	_, pattern := routes.Handler(r)
+	return pattern
+}
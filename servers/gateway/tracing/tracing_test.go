@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// clientTraceparent is the trace context of a client that recorded its span
+const clientTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// recordSpans installs a tracer provider that records every span in memory
+// for the rest of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	stop, err := Setup(context.Background(), Options{SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := stop(context.Background()); err != nil {
+		t.Errorf("unexpected error stopping: %v", err)
+	}
+	fields := otel.GetTextMapPropagator().Fields()
+	if len(fields) == 0 || fields[0] != "traceparent" {
+		t.Errorf("expected the W3C trace context propagator to be installed, but got fields %v", fields)
+	}
+}
+
+func TestSetupWithEndpoint(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	stop, err := Setup(context.Background(), Options{Endpoint: "http://127.0.0.1:1", SampleRatio: 0.5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); !ok {
+		t.Errorf("expected an SDK tracer provider to be installed, but got %T", otel.GetTracerProvider())
+	}
+	// Nothing was recorded, so nothing needs to reach the collector
+	if err := stop(context.Background()); err != nil {
+		t.Errorf("unexpected error stopping: %v", err)
+	}
+}
+
+func TestMiddlewareAndTransport(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/channels/", func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("error calling upstream: %v", err)
+			return
+		}
+		resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/channels/42", nil)
+	req.Header.Set("traceparent", clientTraceparent)
+	Middleware(mux, mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected a server and a client span, but got %d spans", len(spans))
+	}
+	clientSpan, serverSpan := spans[0], spans[1]
+	if serverSpan.Name() != "POST /v1/channels/" {
+		t.Errorf("expected the server span to be named after the route, but got %q", serverSpan.Name())
+	}
+	if serverSpan.Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the server span to continue the client's trace, but got parent %v", serverSpan.Parent())
+	}
+	found := false
+	for _, attr := range serverSpan.Attributes() {
+		if attr == semconv.HTTPRoute("/v1/channels/") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the server span to have the route attribute, but got %v", serverSpan.Attributes())
+	}
+	if clientSpan.Parent().SpanID() != serverSpan.SpanContext().SpanID() {
+		t.Errorf("expected the client span to be a child of the server span")
+	}
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + clientSpan.SpanContext().SpanID().String() + "-01"
+	if upstreamTraceparent != expected {
+		t.Errorf("expected the upstream to get traceparent %q, but got %q", expected, upstreamTraceparent)
+	}
+}
+
+func TestTransportOutsideTrace(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	resp, err := (&http.Client{Transport: Transport(http.DefaultTransport)}).Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if len(recorder.Ended()) != 0 || len(upstreamTraceparent) != 0 {
+		t.Errorf("expected requests outside a trace not to be traced, but got %d spans and traceparent %q", len(recorder.Ended()), upstreamTraceparent)
+	}
+}
@@ -0,0 +1,97 @@
+package upstream
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// Backend represents one instance of a microservice in a Pool
+type Backend struct {
+	URL     *url.URL
+	Weight  int
+	Breaker *Breaker
+
+	// active is the number of requests in flight, updated atomically
+	active int64
+
+	mx       sync.Mutex
+	healthy  bool
+	failures int
+	lastErr  error
+	// current is the smooth weighted round robin state, guarded by the
+	// mutex of the Pool
+	current int
+}
+
+// BackendStatus is a snapshot of the state of a Backend
+type BackendStatus struct {
+	URL       string       `json:"url"`
+	Healthy   bool         `json:"healthy"`
+	Active    int64        `json:"active"`
+	Breaker   BreakerState `json:"breaker"`
+	LastError string       `json:"lastError,omitempty"`
+}
+
+// newBackend constructs a new healthy Backend
+func newBackend(target *url.URL, weight int, breaker *Breaker) *Backend {
+	return &Backend{URL: target, Weight: weight, Breaker: breaker, healthy: true}
+}
+
+// Status returns a snapshot of the state of the backend
+func (b *Backend) Status() *BackendStatus {
+	status := &BackendStatus{
+		URL:     b.URL.String(),
+		Healthy: b.Healthy(),
+		Active:  b.Active(),
+		Breaker: b.Breaker.State(),
+	}
+	if err := b.LastError(); err != nil {
+		status.LastError = err.Error()
+	}
+	return status
+}
+
+// Healthy reports whether the backend is receiving requests
+func (b *Backend) Healthy() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.healthy
+}
+
+// Active returns the number of requests in flight to the backend
+func (b *Backend) Active() int64 {
+	return atomic.LoadInt64(&b.active)
+}
+
+// LastError returns the error of the most recent failed request or probe
+func (b *Backend) LastError() error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.lastErr
+}
+
+// succeeded records a successful request or probe, re-admitting the backend
+// if it was ejected. It reports whether the backend was re-admitted.
+func (b *Backend) succeeded() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	readmitted := !b.healthy
+	b.healthy = true
+	b.failures = 0
+	return readmitted
+}
+
+// failed records a failed request or probe, ejecting the backend once
+// `maxFails` have failed in a row. It reports whether the backend was ejected.
+func (b *Backend) failed(err error, maxFails int) bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.failures++
+	b.lastErr = err
+	if b.healthy && b.failures >= maxFails {
+		b.healthy = false
+		return true
+	}
+	return false
+}
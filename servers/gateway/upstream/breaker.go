@@ -0,0 +1,152 @@
+package upstream
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+// States of a circuit breaker
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every request until its cooldown has passed
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial request through, whose outcome
+	// decides whether the breaker closes or opens again
+	BreakerHalfOpen
+)
+
+// String returns the name of the state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// MarshalText encodes the state as its name
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Breaker stops sending requests to a backend once `failures` requests in
+// a row have failed, so that a struggling backend isn't given more work
+// and clients fail fast instead of waiting for it. After `cooldown`, a
+// single trial request is let through to find out whether it has recovered.
+type Breaker struct {
+	failures int
+	cooldown time.Duration
+	// now returns the current time, and is replaced in tests
+	now func() time.Time
+
+	mx       sync.Mutex
+	state    BreakerState
+	failed   int
+	openedAt time.Time
+	// trial is true while the half-open trial request is in flight
+	trial bool
+}
+
+// NewBreaker constructs a new closed Breaker
+func NewBreaker(failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{failures: failures, cooldown: cooldown, now: time.Now}
+}
+
+// State returns the current state of the breaker. An open breaker whose
+// cooldown has passed is reported as half-open.
+func (b *Breaker) State() BreakerState {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.state == BreakerOpen && b.cooled() {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// available reports whether Allow would let a request through now
+func (b *Breaker) available() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		return b.cooled()
+	case BreakerHalfOpen:
+		return !b.trial
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent. Every allowed request must
+// be followed by a call to Success, Failure or Release.
+func (b *Breaker) Allow() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if !b.cooled() {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a successful request, closing the breaker. It reports
+// whether the breaker was closed by this request.
+func (b *Breaker) Success() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	closed := b.state != BreakerClosed
+	b.state = BreakerClosed
+	b.failed = 0
+	b.trial = false
+	return closed
+}
+
+// Failure records a failed request, opening the breaker if the trial
+// request failed or too many requests failed in a row. It reports whether
+// the breaker was opened by this request.
+func (b *Breaker) Failure() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.failed++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failed >= b.failures) {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		b.trial = false
+		return true
+	}
+	return false
+}
+
+// Release records that an allowed request ended without telling anything
+// about the backend, such as when the client went away, so that another
+// trial request may be let through
+func (b *Breaker) Release() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.trial = false
+}
+
+// cooled reports whether the cooldown of an open breaker has passed. The
+// caller must hold the mutex.
+func (b *Breaker) cooled() bool {
+	return b.now().Sub(b.openedAt) >= b.cooldown
+}
@@ -0,0 +1,62 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	breaker := NewBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	// A success resets the count of failures in a row
+	breaker.Failure()
+	breaker.Success()
+	if breaker.Failure() || breaker.State() != BreakerClosed {
+		t.Fatal("expected the breaker to stay closed after non-consecutive failures")
+	}
+	if !breaker.Failure() || breaker.State() != BreakerOpen {
+		t.Fatal("expected the breaker to open after 2 failures in a row")
+	}
+	if breaker.Allow() {
+		t.Error("expected an open breaker to reject requests")
+	}
+
+	// After the cooldown, exactly one trial request is let through
+	now = now.Add(time.Minute)
+	if breaker.State() != BreakerHalfOpen {
+		t.Errorf("expected the breaker to be half-open after its cooldown, but got %v", breaker.State())
+	}
+	if !breaker.Allow() || breaker.Allow() {
+		t.Fatal("expected a half-open breaker to let a single trial request through")
+	}
+	if !breaker.Failure() || breaker.State() != BreakerOpen {
+		t.Fatal("expected a failed trial request to open the breaker again")
+	}
+
+	now = now.Add(time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("expected a trial request after the second cooldown")
+	}
+	breaker.Release()
+	if !breaker.Allow() {
+		t.Fatal("expected another trial request once the first was released")
+	}
+	if !breaker.Success() || breaker.State() != BreakerClosed {
+		t.Error("expected a successful trial request to close the breaker")
+	}
+}
+
+func TestBreakerStateText(t *testing.T) {
+	for state, expected := range map[BreakerState]string{
+		BreakerClosed:   "closed",
+		BreakerOpen:     "open",
+		BreakerHalfOpen: "half-open",
+	} {
+		text, err := state.MarshalText()
+		if err != nil || string(text) != expected {
+			t.Errorf("expected %s, but got %s (%v)", expected, text, err)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+// Package upstream spreads proxied requests across the instances of a
+// microservice. A Pool probes each instance periodically, ejects instances
+// whose requests or probes keep failing, re-admits them once a probe
+// succeeds, and retries idempotent requests on another instance when one
+// can't be reached. Each instance also has a circuit breaker, and every
+// request is bounded by dial, response header and total timeouts.
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults used for Options that are not set
+const (
+	DefaultHealthPath     = "/"
+	DefaultHealthInterval = 10 * time.Second
+	DefaultHealthTimeout  = 2 * time.Second
+	DefaultMaxFails       = 3
+	DefaultAttempts       = 2
+
+	DefaultDialTimeout           = 5 * time.Second
+	DefaultResponseHeaderTimeout = 10 * time.Second
+	DefaultTimeout               = 30 * time.Second
+	DefaultBreakerFailures       = 5
+	DefaultBreakerCooldown       = 30 * time.Second
+)
+
+// ErrNoHealthyBackends is returned when every backend of a pool is ejected
+var ErrNoHealthyBackends = errors.New("no healthy backends")
+
+// ErrCircuitOpen is returned when every healthy backend of a pool has an
+// open circuit breaker
+var ErrCircuitOpen = errors.New("circuit breakers of every healthy backend are open")
+
+// Options configures a Pool. Zero values are replaced by the defaults.
+type Options struct {
+	// Strategy chooses the backend for each request. Defaults to RoundRobin.
+	Strategy Strategy
+	// Weights are the relative weights of the targets for the Weighted
+	// strategy, in the same order. Defaults to 1 for every target.
+	Weights []int
+	// HealthPath is requested from every backend each HealthInterval. A
+	// response with a status code below 500 means the backend is healthy.
+	HealthPath     string
+	HealthInterval time.Duration
+	// HealthTimeout bounds each probe
+	HealthTimeout time.Duration
+	// MaxFails is how many requests or probes must fail in a row for a
+	// backend to be ejected
+	MaxFails int
+	// Attempts is how many backends an idempotent request is tried on
+	// before giving up. 1 disables retries.
+	Attempts int
+
+	// DialTimeout bounds connecting to a backend, ResponseHeaderTimeout
+	// bounds waiting for its response headers once the request is sent,
+	// and Timeout bounds the whole request including retries and reading
+	// the response body
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	Timeout               time.Duration
+	// BreakerFailures is how many requests to a backend must fail in a row
+	// to open its circuit breaker, and BreakerCooldown is how long it stays
+	// open before a trial request is let through
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+// Pool represents the backends of one microservice. Pool implements
+// http.RoundTripper, so it can be used as the Transport of a ReverseProxy:
+// the scheme and host of each request are replaced by those of the backend
+// chosen for it.
+type Pool struct {
+	Name     string
+	Backends []*Backend
+	// Transport sends requests and probes to the backends
+	Transport http.RoundTripper
+	// Observe, if set, is called with the outcome of every request sent to a
+	// backend, except those cancelled by the client: how long the backend
+	// took to respond, and either its response or the error
+	Observe func(backend *Backend, took time.Duration, resp *http.Response, err error)
+
+	strategy       Strategy
+	healthPath     string
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	maxFails       int
+	attempts       int
+	timeout        time.Duration
+
+	// mx guards the strategy state
+	mx   sync.Mutex
+	next int
+}
+
+// NewPool constructs a new Pool named `name` with a healthy backend for
+// each of `targets`. Probes are not sent until Start is called.
+func NewPool(name string, targets []*url.URL, opts Options) (*Pool, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("upstream %s has no targets", name)
+	}
+	if len(opts.Strategy) == 0 {
+		opts.Strategy = RoundRobin
+	}
+	if err := opts.Strategy.validate(); err != nil {
+		return nil, err
+	}
+	if len(opts.Weights) > 0 && len(opts.Weights) != len(targets) {
+		return nil, fmt.Errorf("%d weights given for %d targets", len(opts.Weights), len(targets))
+	}
+	if len(opts.HealthPath) == 0 {
+		opts.HealthPath = DefaultHealthPath
+	}
+	if !strings.HasPrefix(opts.HealthPath, "/") {
+		return nil, fmt.Errorf("health path %q must start with /", opts.HealthPath)
+	}
+	if opts.HealthInterval < 0 || opts.HealthTimeout < 0 || opts.MaxFails < 0 || opts.Attempts < 0 {
+		return nil, errors.New("health interval, health timeout, max fails and attempts can't be negative")
+	}
+	if opts.DialTimeout < 0 || opts.ResponseHeaderTimeout < 0 || opts.Timeout < 0 || opts.BreakerFailures < 0 || opts.BreakerCooldown < 0 {
+		return nil, errors.New("timeouts, breaker failures and breaker cooldown can't be negative")
+	}
+	if opts.HealthInterval == 0 {
+		opts.HealthInterval = DefaultHealthInterval
+	}
+	if opts.HealthTimeout == 0 {
+		opts.HealthTimeout = DefaultHealthTimeout
+	}
+	if opts.MaxFails == 0 {
+		opts.MaxFails = DefaultMaxFails
+	}
+	if opts.Attempts == 0 {
+		opts.Attempts = DefaultAttempts
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.ResponseHeaderTimeout == 0 {
+		opts.ResponseHeaderTimeout = DefaultResponseHeaderTimeout
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.BreakerFailures == 0 {
+		opts.BreakerFailures = DefaultBreakerFailures
+	}
+	if opts.BreakerCooldown == 0 {
+		opts.BreakerCooldown = DefaultBreakerCooldown
+	}
+
+	backends := make([]*Backend, len(targets))
+	for i, target := range targets {
+		weight := 1
+		if len(opts.Weights) > 0 {
+			weight = opts.Weights[i]
+		}
+		if weight < 1 {
+			return nil, fmt.Errorf("weight of %s must be at least 1", target)
+		}
+		backends[i] = newBackend(target, weight, NewBreaker(opts.BreakerFailures, opts.BreakerCooldown))
+	}
+
+	// Requests are sent directly to the backends, never through a proxy
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		TLSHandshakeTimeout:   opts.DialTimeout,
+		MaxIdleConnsPerHost:   32,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &Pool{
+		Name:           name,
+		Backends:       backends,
+		Transport:      transport,
+		strategy:       opts.Strategy,
+		healthPath:     opts.HealthPath,
+		healthInterval: opts.HealthInterval,
+		healthTimeout:  opts.HealthTimeout,
+		maxFails:       opts.MaxFails,
+		attempts:       opts.Attempts,
+		timeout:        opts.Timeout,
+	}, nil
+}
+
+// Next chooses a healthy backend that is not in `tried` and whose circuit
+// breaker lets a request through, or returns nil if there is none. The
+// caller must report the outcome of the request to the backend's breaker.
+func (p *Pool) Next(tried map[*Backend]bool) *Backend {
+	skipped := map[*Backend]bool{}
+	for {
+		candidates := make([]*Backend, 0, len(p.Backends))
+		for _, backend := range p.Backends {
+			if !tried[backend] && !skipped[backend] && backend.Healthy() && backend.Breaker.available() {
+				candidates = append(candidates, backend)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil
+		}
+
+		p.mx.Lock()
+		backend := p.pick(candidates)
+		p.mx.Unlock()
+		if backend.Breaker.Allow() {
+			return backend
+		}
+		// Another request took the half-open trial first
+		skipped[backend] = true
+	}
+}
+
+// RoundTrip sends `req` to a healthy backend. If the backend can't be
+// reached and the request is safe to repeat, it is retried on another
+// backend, up to the pool's number of attempts. The whole exchange is
+// bounded by the pool's timeout, except for protocol upgrades whose
+// connections outlive the request.
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if len(req.Header.Get("Upgrade")) > 0 {
+		ctx, cancel = context.WithCancel(req.Context())
+	} else {
+		ctx, cancel = context.WithTimeout(req.Context(), p.timeout)
+	}
+
+	attempts := 1
+	if retryable(req) {
+		attempts = p.attempts
+	}
+
+	tried := map[*Backend]bool{}
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		backend := p.Next(tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+
+		outreq, err := backendRequest(ctx, req, backend, attempt)
+		if err != nil {
+			backend.Breaker.Release()
+			cancel()
+			return nil, err
+		}
+		resp, err := p.send(outreq, backend, req.Context())
+		if err == nil {
+			resp.Body = track(resp.Body, cancel)
+			return resp, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	cancel()
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	for _, backend := range p.Backends {
+		if backend.Healthy() {
+			return nil, fmt.Errorf("upstream %s: %w", p.Name, ErrCircuitOpen)
+		}
+	}
+	return nil, fmt.Errorf("upstream %s: %w", p.Name, ErrNoHealthyBackends)
+}
+
+// send sends `req` to `backend`, recording the outcome unless `clientCtx`,
+// the context of the client's request, is done. The backend counts the
+// request as active until the response body is closed.
+func (p *Pool) send(req *http.Request, backend *Backend, clientCtx context.Context) (*http.Response, error) {
+	atomic.AddInt64(&backend.active, 1)
+	start := time.Now()
+	resp, err := p.Transport.RoundTrip(req)
+	if err != nil {
+		atomic.AddInt64(&backend.active, -1)
+		// Requests cancelled by the client say nothing about the backend
+		if clientCtx.Err() != nil {
+			backend.Breaker.Release()
+		} else {
+			p.observe(backend, time.Since(start), nil, err)
+			p.failed(backend, err)
+			p.breakerFailed(backend, err)
+		}
+		return nil, err
+	}
+	p.observe(backend, time.Since(start), resp, nil)
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		err := fmt.Errorf("responded with %s", resp.Status)
+		p.failed(backend, err)
+		p.breakerFailed(backend, err)
+	default:
+		p.succeeded(backend)
+		if backend.Breaker.Success() {
+			slog.Info("Upstream circuit breaker closed", "upstream", p.Name, "backend", backend.URL)
+		}
+	}
+
+	resp.Body = track(resp.Body, func() {
+		atomic.AddInt64(&backend.active, -1)
+	})
+	return resp, nil
+}
+
+// Start probes every backend now and then each health interval, until
+// `ctx` is done
+func (p *Pool) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(p.healthInterval)
+		defer ticker.Stop()
+		for {
+			p.probeAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// probeAll probes every backend concurrently and waits for the results
+func (p *Pool) probeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, backend := range p.Backends {
+		wg.Add(1)
+		go func(backend *Backend) {
+			defer wg.Done()
+			p.probe(ctx, backend)
+		}(backend)
+	}
+	wg.Wait()
+}
+
+// probe requests the health path of `backend` and records the outcome
+func (p *Pool) probe(ctx context.Context, backend *Backend) {
+	ctx, cancel := context.WithTimeout(ctx, p.healthTimeout)
+	defer cancel()
+
+	probeURL := *backend.URL
+	probeURL.Path = p.healthPath
+	req, err := http.NewRequest(http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		p.failed(backend, err)
+		return
+	}
+	resp, err := p.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		// Probes cut short by shutdown say nothing about the backend
+		if ctx.Err() != context.Canceled {
+			p.failed(backend, err)
+		}
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		p.failed(backend, fmt.Errorf("health check responded with %s", resp.Status))
+		return
+	}
+	p.succeeded(backend)
+}
+
+// Status returns a snapshot of the state of every backend
+func (p *Pool) Status() []*BackendStatus {
+	statuses := make([]*BackendStatus, len(p.Backends))
+	for i, backend := range p.Backends {
+		statuses[i] = backend.Status()
+	}
+	return statuses
+}
+
+// Check returns an error if every backend is ejected, describing why
+func (p *Pool) Check(ctx context.Context) error {
+	problems := make([]string, 0, len(p.Backends))
+	for _, backend := range p.Backends {
+		if backend.Healthy() {
+			return nil
+		}
+		problems = append(problems, fmt.Sprintf("%s: %v", backend.URL, backend.LastError()))
+	}
+	return fmt.Errorf("%v: %s", ErrNoHealthyBackends, strings.Join(problems, "; "))
+}
+
+// observe reports the outcome of a request to `backend` to the pool's observer
+func (p *Pool) observe(backend *Backend, took time.Duration, resp *http.Response, err error) {
+	if p.Observe != nil {
+		p.Observe(backend, took, resp, err)
+	}
+}
+
+// succeeded records a successful request or probe to `backend`
+func (p *Pool) succeeded(backend *Backend) {
+	if backend.succeeded() {
+		slog.Info("Upstream backend re-admitted", "upstream", p.Name, "backend", backend.URL)
+	}
+}
+
+// breakerFailed records a failed request to the circuit breaker of `backend`
+func (p *Pool) breakerFailed(backend *Backend, err error) {
+	if backend.Breaker.Failure() {
+		slog.Warn("Upstream circuit breaker opened", "upstream", p.Name, "backend", backend.URL, "error", err)
+	}
+}
+
+// failed records a failed request or probe to `backend`
+func (p *Pool) failed(backend *Backend, err error) {
+	if backend.failed(err, p.maxFails) {
+		slog.Warn("Upstream backend ejected", "upstream", p.Name, "backend", backend.URL, "consecutiveFailures", p.maxFails, "error", err)
+	}
+}
+
+// retryable reports whether `req` can safely be sent again after failing:
+// its method must be idempotent, or it must carry an idempotency key as
+// understood by net/http, and its body must be empty or replayable
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+	default:
+		if _, found := req.Header["Idempotency-Key"]; !found {
+			if _, found := req.Header["X-Idempotency-Key"]; !found {
+				return false
+			}
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// backendRequest returns a copy of `req` addressed to `backend` and bound
+// to `ctx`. Retries
+// are given a fresh copy of the body.
+func backendRequest(ctx context.Context, req *http.Request, backend *Backend, attempt int) (*http.Request, error) {
+	outreq := req.Clone(ctx)
+	if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		outreq.Body = body
+	}
+	outreq.URL.Scheme = backend.URL.Scheme
+	outreq.URL.Host = backend.URL.Host
+	outreq.Host = backend.URL.Host
+	return outreq, nil
+}
+
+// track wraps the response `body` so that `done` is called once when it is
+// closed. The body of an upgraded connection stays writable, since the
+// ReverseProxy writes to it.
+func track(body io.ReadCloser, done func()) io.ReadCloser {
+	if conn, ok := body.(io.ReadWriteCloser); ok {
+		return &trackedConn{ReadWriteCloser: conn, done: done}
+	}
+	return &trackedBody{ReadCloser: body, done: done}
+}
+
+// trackedBody calls done once when the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+// Close closes the body and calls done the first time it is called
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// trackedConn calls done once when an upgraded connection is closed
+type trackedConn struct {
+	io.ReadWriteCloser
+	once sync.Once
+	done func()
+}
+
+// Close closes the connection and calls done the first time it is called
+func (c *trackedConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(c.done)
+	return err
+}
@@ -0,0 +1,246 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backendServer starts a server that answers every request with the status
+// code in `status` and counts the requests it receives
+func backendServer(status *int32, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+		w.Write([]byte(r.Host))
+	}))
+}
+
+// statusOK is passed to backendServer for backends that always succeed
+var statusOK int32 = http.StatusOK
+
+// serverPool returns a pool over `servers`
+func serverPool(t *testing.T, opts Options, servers ...*httptest.Server) *Pool {
+	targets := make([]*url.URL, len(servers))
+	for i, server := range servers {
+		target, err := url.Parse(server.URL)
+		if err != nil {
+			t.Fatalf("error parsing server URL: %v", err)
+		}
+		targets[i] = target
+	}
+	pool, err := NewPool("test", targets, opts)
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	return pool
+}
+
+// get sends a `method` request through `pool` and returns the response body
+func get(pool *Pool, method string) (string, error) {
+	req := httptest.NewRequest(method, "/v1/events", nil)
+	req.RequestURI = ""
+	resp, err := pool.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestRoundTripRetriesIdempotentRequests(t *testing.T) {
+	var requests int32
+	up := backendServer(&statusOK, &requests)
+	defer up.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	pool := serverPool(t, Options{MaxFails: 1}, down, up)
+	body, err := get(pool, http.MethodGet)
+	if err != nil {
+		t.Fatalf("expected the GET to be retried on the healthy backend, but got %v", err)
+	}
+	if body != strings.TrimPrefix(up.URL, "http://") {
+		t.Errorf("expected the request to be addressed to the backend, but the host was %q", body)
+	}
+	if pool.Backends[0].Healthy() {
+		t.Error("expected the unreachable backend to be ejected after one failure")
+	}
+
+	// The next POST can only go to the healthy backend
+	if _, err := get(pool, http.MethodPost); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("expected 2 requests to reach the healthy backend, but got %d", requests)
+	}
+	if active := pool.Backends[1].Active(); active != 0 {
+		t.Errorf("expected no active requests once bodies are closed, but got %d", active)
+	}
+}
+
+func TestRoundTripObserve(t *testing.T) {
+	var requests int32
+	up := backendServer(&statusOK, &requests)
+	defer up.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	pool := serverPool(t, Options{}, down, up)
+	observed := map[*Backend]string{}
+	pool.Observe = func(backend *Backend, took time.Duration, resp *http.Response, err error) {
+		if took <= 0 {
+			t.Errorf("expected a positive duration for %s, but got %v", backend.URL, took)
+		}
+		if err != nil {
+			observed[backend] = "error"
+		} else {
+			observed[backend] = resp.Status
+		}
+	}
+
+	if _, err := get(pool, http.MethodGet); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if observed[pool.Backends[0]] != "error" || observed[pool.Backends[1]] != "200 OK" {
+		t.Errorf("expected both attempts to be observed, but got %v", observed)
+	}
+}
+
+func TestRoundTripDoesNotRetryPost(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	var requests int32
+	up := backendServer(&statusOK, &requests)
+	defer up.Close()
+
+	pool := serverPool(t, Options{}, down, up)
+	if _, err := get(pool, http.MethodPost); err == nil {
+		t.Error("expected the POST to the unreachable backend to fail without a retry")
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Errorf("expected the POST not to be retried, but the healthy backend got %d requests", requests)
+	}
+}
+
+func TestPassiveEjectionAndReadmission(t *testing.T) {
+	var requests int32
+	status := int32(http.StatusServiceUnavailable)
+	server := backendServer(&status, &requests)
+	defer server.Close()
+
+	pool := serverPool(t, Options{MaxFails: 2, Attempts: 1}, server)
+	for i := 0; i < 2; i++ {
+		if _, err := get(pool, http.MethodGet); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if pool.Backends[0].Healthy() {
+		t.Fatal("expected the backend to be ejected after 2 failed requests")
+	}
+	if _, err := get(pool, http.MethodGet); !errors.Is(err, ErrNoHealthyBackends) {
+		t.Errorf("expected %v, but got %v", ErrNoHealthyBackends, err)
+	}
+	if err := pool.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected Check to report the last failure, but got %v", err)
+	}
+
+	// Once the backend recovers, a successful probe re-admits it
+	atomic.StoreInt32(&status, http.StatusOK)
+	pool.probe(context.Background(), pool.Backends[0])
+	if !pool.Backends[0].Healthy() {
+		t.Error("expected a successful probe to re-admit the backend")
+	}
+	if err := pool.Check(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStartProbesBackends(t *testing.T) {
+	probed := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case probed <- r.URL.Path:
+		default:
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	pool := serverPool(t, Options{HealthPath: "/healthz", HealthInterval: 10 * time.Millisecond, MaxFails: 2}, server)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.Start(ctx)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case path := <-probed:
+			if path != "/healthz" {
+				t.Errorf("expected the health path to be probed, but got %s", path)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a probe")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for pool.Backends[0].Healthy() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if pool.Backends[0].Healthy() {
+		t.Error("expected failed probes to eject the backend")
+	}
+}
+
+func TestRoundTripTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	pool := serverPool(t, Options{ResponseHeaderTimeout: 20 * time.Millisecond, Attempts: 1}, slow)
+	start := time.Now()
+	_, err := get(pool, http.MethodGet)
+	var netErr interface{ Timeout() bool }
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout error, but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the request to time out quickly, but it took %v", elapsed)
+	}
+}
+
+func TestRoundTripOpensBreaker(t *testing.T) {
+	var requests int32
+	status := int32(http.StatusBadGateway)
+	server := backendServer(&status, &requests)
+	defer server.Close()
+
+	// The breaker opens before passive ejection would
+	pool := serverPool(t, Options{BreakerFailures: 2, BreakerCooldown: time.Hour, MaxFails: 10}, server)
+	for i := 0; i < 2; i++ {
+		if _, err := get(pool, http.MethodGet); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := get(pool, http.MethodGet); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected %v, but got %v", ErrCircuitOpen, err)
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("expected the open breaker to stop requests, but the backend got %d", requests)
+	}
+
+	statuses := pool.Status()
+	if len(statuses) != 1 || statuses[0].Breaker != BreakerOpen || !statuses[0].Healthy || len(statuses[0].LastError) == 0 {
+		t.Errorf("unexpected status %+v", statuses[0])
+	}
+}
@@ -0,0 +1,64 @@
+package upstream
+
+import "fmt"
+
+// Strategy is how a Pool chooses the backend for each request
+type Strategy string
+
+// Strategies supported by a Pool
+const (
+	// RoundRobin sends requests to each healthy backend in turn
+	RoundRobin Strategy = "round-robin"
+	// LeastConnections sends each request to the healthy backend with the
+	// fewest requests in flight
+	LeastConnections Strategy = "least-connections"
+	// Weighted sends each healthy backend a share of requests proportional
+	// to its weight, interleaving them rather than sending bursts
+	Weighted Strategy = "weighted"
+)
+
+// validate returns an error if the strategy is not supported
+func (s Strategy) validate() error {
+	switch s {
+	case RoundRobin, LeastConnections, Weighted:
+		return nil
+	}
+	return fmt.Errorf("unknown strategy %q, expected %s, %s or %s", s, RoundRobin, LeastConnections, Weighted)
+}
+
+// pick chooses one of `candidates`, which is never empty, according to the
+// pool's strategy. The caller must hold the pool's mutex.
+func (p *Pool) pick(candidates []*Backend) *Backend {
+	switch p.strategy {
+	case LeastConnections:
+		// Start from the round robin position so that ties are spread evenly
+		start := p.next
+		p.next++
+		var chosen *Backend
+		for i := range candidates {
+			backend := candidates[(start+i)%len(candidates)]
+			if chosen == nil || backend.Active() < chosen.Active() {
+				chosen = backend
+			}
+		}
+		return chosen
+	case Weighted:
+		// Smooth weighted round robin, as used by nginx: every candidate gains
+		// its weight, and the one with the most is chosen and pays the total
+		var chosen *Backend
+		total := 0
+		for _, backend := range candidates {
+			backend.current += backend.Weight
+			total += backend.Weight
+			if chosen == nil || backend.current > chosen.current {
+				chosen = backend
+			}
+		}
+		chosen.current -= total
+		return chosen
+	default:
+		chosen := candidates[p.next%len(candidates)]
+		p.next++
+		return chosen
+	}
+}
@@ -0,0 +1,80 @@
+package upstream
+
+import (
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+// testPool returns a pool over hosts a, b, c, ... that is never probed
+func testPool(t *testing.T, opts Options, hosts ...string) *Pool {
+	targets := make([]*url.URL, len(hosts))
+	for i, host := range hosts {
+		targets[i] = &url.URL{Scheme: "http", Host: host}
+	}
+	pool, err := NewPool("test", targets, opts)
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	return pool
+}
+
+// sequence returns the hosts of the next `n` backends chosen by `pool`
+func sequence(pool *Pool, n int) string {
+	hosts := ""
+	for i := 0; i < n; i++ {
+		hosts += pool.Next(nil).URL.Host
+	}
+	return hosts
+}
+
+func TestRoundRobin(t *testing.T) {
+	pool := testPool(t, Options{}, "a", "b", "c")
+	if hosts := sequence(pool, 6); hosts != "abcabc" {
+		t.Errorf("expected abcabc, but got %s", hosts)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	pool := testPool(t, Options{Strategy: Weighted, Weights: []int{5, 1, 1}}, "a", "b", "c")
+	// Smooth weighted round robin interleaves the lighter backends
+	if hosts := sequence(pool, 14); hosts != "aabacaaaabacaa" {
+		t.Errorf("expected aabacaaaabacaa, but got %s", hosts)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	pool := testPool(t, Options{Strategy: LeastConnections}, "a", "b", "c")
+	atomic.AddInt64(&pool.Backends[0].active, 2)
+	atomic.AddInt64(&pool.Backends[2].active, 1)
+
+	for i := 0; i < 3; i++ {
+		if host := pool.Next(nil).URL.Host; host != "b" {
+			t.Errorf("expected the idle backend b, but got %s", host)
+		}
+	}
+	atomic.AddInt64(&pool.Backends[1].active, 3)
+	if host := pool.Next(nil).URL.Host; host != "c" {
+		t.Errorf("expected c with the fewest active requests, but got %s", host)
+	}
+}
+
+func TestNewPoolErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		opts Options
+	}{
+		{"Unknown Strategy", Options{Strategy: "random"}},
+		{"Wrong Number of Weights", Options{Strategy: Weighted, Weights: []int{1}}},
+		{"Zero Weight", Options{Strategy: Weighted, Weights: []int{1, 0}}},
+		{"Relative Health Path", Options{HealthPath: "healthz"}},
+		{"Negative Attempts", Options{Attempts: -1}},
+	}
+
+	targets := []*url.URL{{Scheme: "http", Host: "a"}, {Scheme: "http", Host: "b"}}
+	for _, c := range cases {
+		if _, err := NewPool("test", targets, c.opts); err == nil {
+			t.Errorf("case %s: expected an error but got none", c.name)
+		}
+	}
+}
@@ -1,16 +1,29 @@
 "use strict";
 
+const crypto = require("crypto");
+
+// The X-User-Signature scheme and maximum age, which must match the gateway's identity package
+const IDENTITY_VERSION = "v1";
+const IDENTITY_MAX_AGE_SECONDS = 5 * 60;
+
+// identityKey is the key shared with the gateway to sign the X-User header
+const identityKey = process.env.IDENTITYKEY;
+if (!identityKey) {
+  console.log("IDENTITYKEY is not set, so every request will be rejected as unauthorized");
+}
+
 /**
  * isAuthenticatedUser middleware function that checks if the user making a request to this microservice 
- * is authenticted. The current user will be encoded as a JSON object in the X-User header. If that header 
- * is not in the request, assume that the user is unauthenticated and respond with status code 401 
+ * is authenticted. The current user will be encoded as a JSON object in the X-User header, which the 
+ * gateway signs in the X-User-Signature header. If that header is not in the request or its signature 
+ * doesn't verify, assume that the user is unauthenticated and respond with status code 401 
  * (Unauthorized).
  * @param {Request} req HTTP request object
  * @param {Response} res HTTP response object
  * @param {NextFunction} next Function that should be called after this middleware
  */
 function isAuthenticatedUser(req, res, next) {
-  if (!req.get("X-User")) {
+  if (!req.get("X-User") || !verifyIdentity(req)) {
     res.set("Content-Type", "text/plain");
     res.status(401).send("Unauthorized");
     return
@@ -133,6 +146,39 @@ async function isCreatorMsg(req, res, next) {
 
 // ----- Helper Functions -----
 
+/**
+ * verifyIdentity checks that the X-User header of the request was signed by the gateway for this
+ * request, recently. The X-User-Signature header has the form v1,t=<unix seconds>,s=<MAC>, where
+ * the MAC is an unpadded base64url HMAC-SHA256 of the version, the time, the method, the URL and
+ * the X-User header, each on its own line.
+ * @param {Request} req HTTP request object
+ * @returns {Boolean} Whether the identity of the request can be trusted
+ */
+function verifyIdentity(req) {
+  const user = req.get("X-User");
+  const signature = req.get("X-User-Signature");
+  if (!identityKey || !user || !signature) {
+    return false;
+  }
+  const parts = signature.split(",");
+  if (parts.length !== 3 || parts[0] !== IDENTITY_VERSION || !parts[1].startsWith("t=") ||
+    !parts[2].startsWith("s=") || !/^-?\d+$/.test(parts[1].slice(2))) {
+    return false;
+  }
+  const issuedAt = Number(parts[1].slice(2));
+  const mac = Buffer.from(parts[2].slice(2));
+
+  // Node decodes header values as latin1, so their bytes as signed are recovered from it
+  const expected = Buffer.from(crypto.createHmac("sha256", identityKey)
+    .update(`${IDENTITY_VERSION}\n${issuedAt}\n${req.method}\n${req.originalUrl}\n`)
+    .update(Buffer.from(user, "latin1"))
+    .digest("base64url"));
+  if (mac.length !== expected.length || !crypto.timingSafeEqual(mac, expected)) {
+    return false;
+  }
+  return Math.abs(Date.now() / 1000 - issuedAt) <= IDENTITY_MAX_AGE_SECONDS;
+}
+
 /**
  * getSpecificChannelFromDB given a channel id, retrieve the associated channel from database and return
  * a JSON encoded version of the channel model 
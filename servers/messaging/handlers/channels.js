@@ -138,13 +138,16 @@ async function createNewChannel(req, res) {
   }
 
   const memberIDs = await rabbitmqhelpers.getMemberIDs(channelID, db);
-
-  const rabbitNewChannel = {
-    "type": "channel-new",
-    "channel": newChannelWithID,
-    "userIDs": memberIDs.members
+  if (memberIDs.error != null) {
+    console.log(memberIDs.error.message);
+    res.set("Content-Type", "text/plain");
+    res.status(500).send("Server Error: Cannot get channel members from database.");
+    db.end();
+    return;
   }
 
+  const rabbitNewChannel = rabbitmqhelpers.newEvent("channel-new", channelID, newChannelWithID, memberIDs);
+
   const error = sendMessageToRabbitMQ(rabbitNewChannel);
   if (error != null) {
     console.log("Error sending event message to RabbitMQ: " + error);
@@ -258,6 +261,15 @@ async function sendMessage(req, res) {
     db.end();
     return;
   }
+  // Members are found before the connection is ended
+  const memberIDs = await rabbitmqhelpers.getMemberIDs(channelID, db);
+  if (memberIDs.error != null) {
+    console.log(memberIDs.error.message);
+    res.set("Content-Type", "text/plain");
+    res.status(500).send("Server Error: Cannot get channel members from database.");
+    db.end();
+    return;
+  }
   db.end();
 
   const newMsg = {
@@ -269,13 +281,7 @@ async function sendMessage(req, res) {
     "editedAt": newMessage[0].LastUpdated
   }
 
-  const memberIDs = await rabbitmqhelpers.getMemberIDs(channelID, db);
-
-  const rabbitNewMessage = {
-    "type": "message-new",
-    "message": newMsg,
-    "userIDs": memberIDs.members
-  }
+  const rabbitNewMessage = rabbitmqhelpers.newEvent("message-new", newMsg.channelID, newMsg, memberIDs);
 
   const error = sendMessageToRabbitMQ(rabbitNewMessage);
   if (error != null) {
@@ -357,13 +363,16 @@ async function updateChannel(req, res) {
   }
 
   const memberIDs = await rabbitmqhelpers.getMemberIDs(channel.id, db);
-
-  const rabbitUpdateChannel = {
-    "type": "channel-update",
-    "channel": channel,
-    "userIDs": memberIDs.members
+  if (memberIDs.error != null) {
+    console.log(memberIDs.error.message);
+    res.set("Content-Type", "text/plain");
+    res.status(500).send("Server Error: Cannot get channel members from database.");
+    db.end();
+    return;
   }
 
+  const rabbitUpdateChannel = rabbitmqhelpers.newEvent("channel-update", channel.id, channel, memberIDs);
+
   const error = sendMessageToRabbitMQ(rabbitUpdateChannel);
   if (error != null) {
     console.log("Error sending event message to RabbitMQ: " + error);
@@ -384,6 +393,16 @@ async function deleteChannel(req, res) {
   const channel = req.channel;
   const db = req.db;
 
+  // Members are found before they're deleted along with the channel
+  const memberIDs = await rabbitmqhelpers.getMemberIDs(channel.id, db);
+  if (memberIDs.error != null) {
+    console.log(memberIDs.error.message);
+    res.set("Content-Type", "text/plain");
+    res.status(500).send("Server Error: Cannot get channel members from database.");
+    db.end();
+    return;
+  }
+
   try {
     // Delete channel from database
     const qry = "DELETE FROM Channels WHERE ID = ?;";
@@ -408,13 +427,7 @@ async function deleteChannel(req, res) {
     return;
   }
 
-  const memberIDs = await rabbitmqhelpers.getMemberIDs(channel.id, db);
-
-  const rabbitDeleteChannel = {
-    "type": "channel-delete",
-    "channelID": channel.id,
-    "userIDs": memberIDs.members
-  }
+  const rabbitDeleteChannel = rabbitmqhelpers.newEvent("channel-delete", channel.id, { "id": channel.id }, memberIDs);
 
   const error = sendMessageToRabbitMQ(rabbitDeleteChannel);
   if (error != null) {
@@ -36,6 +36,15 @@ async function updateMsg(req, res) {
     db.end();
     return;
   }
+  // Members are found before the connection is ended
+  const memberIDs = await rabbitmqhelpers.getMemberIDs(message.channelID, db);
+  if (memberIDs.error != null) {
+    console.log(memberIDs.error.message);
+    res.set("Content-Type", "text/plain");
+    res.status(500).send("Server Error: Cannot get channel members from database.");
+    db.end();
+    return;
+  }
   db.end();
 
   const updatedMsg = {
@@ -47,13 +56,7 @@ async function updateMsg(req, res) {
     "editedAt": editedAt
   }
 
-  const memberIDs = await rabbitmqhelpers.getMemberIDs(message.channelID, db);
-
-  const rabbitUpdateMessage = {
-    "type": "message-update",
-    "message": updatedMsg,
-    "userIDs": memberIDs.members
-  }
+  const rabbitUpdateMessage = rabbitmqhelpers.newEvent("message-update", updatedMsg.channelID, updatedMsg, memberIDs);
 
   const error = sendMessageToRabbitMQ(rabbitUpdateMessage);
   if (error != null) {
@@ -84,15 +87,18 @@ async function deleteMsg(req, res) {
     db.end();
     return;
   }
+  // Members are found before the connection is ended
+  const memberIDs = await rabbitmqhelpers.getMemberIDs(message.channelID, db);
+  if (memberIDs.error != null) {
+    console.log(memberIDs.error.message);
+    res.set("Content-Type", "text/plain");
+    res.status(500).send("Server Error: Cannot get channel members from database.");
+    db.end();
+    return;
+  }
   db.end();
 
-  const memberIDs = await rabbitmqhelpers.getMemberIDs(channel.id, db);
-
-  const rabbitDeleteMessage = {
-    "type": "message-delete",
-    "channelID": message.id,
-    "userIDs": memberIDs.members
-  }
+  const rabbitDeleteMessage = rabbitmqhelpers.newEvent("message-delete", message.channelID, { "id": message.id, "channelID": message.channelID }, memberIDs);
 
   const error = sendMessageToRabbitMQ(rabbitDeleteMessage);
   if (error != null) {
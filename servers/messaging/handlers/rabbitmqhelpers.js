@@ -1,23 +1,56 @@
 "use strict";
 
-// getMemberIDs returns user ids of all members of given channel
+const crypto = require("crypto");
+
+// EVENT_VERSION is the version of the event envelope, which must match
+// EventVersion in servers/gateway/handlers/events.go
+const EVENT_VERSION = 1;
+
+// getMemberIDs returns whether given channel is private and, if it is, the user ids of all
+// its members, since the gateway sends events of public channels to every subscriber. It must
+// be called while the channel and its members are still in the database and db is open, and
+// no event may be published if it returns an error.
 async function getMemberIDs(channelID, db) {
   const memberIDsArray = [];
   try {
-    const qry = "SELECT MemberID FROM ChannelsJoinMembers C WHERE C.ChannelID = ?";
-    const user = await db.query(qry, [channelID]);
-    for (let i = 0; i < user.length; i++) {
-      memberIDsArray.push(user[i]);
+    const channels = await db.query("SELECT PrivateChannel FROM Channels WHERE ID = ?", [channelID]);
+    if (channels.length == 0) {
+      return { private: true, members: [], error: new Error(`channel ${channelID} not found`) };
+    }
+    const isPrivate = Boolean(channels[0].PrivateChannel);
+    if (isPrivate) {
+      const qry = "SELECT MemberID FROM ChannelsJoinMembers WHERE ChannelID = ?";
+      const user = await db.query(qry, [channelID]);
+      for (let i = 0; i < user.length; i++) {
+        memberIDsArray.push(user[i].MemberID);
+      }
     }
-    return { members: memberIDsArray, error: null };
+    return { private: isPrivate, members: memberIDsArray, error: null };
   } catch (err) {
-    return { members: [], error: err };
+    return { private: true, members: [], error: err };
   }
 }
 
+// newEvent returns the event of given type about the channel with given id for RabbitMQ,
+// in the envelope the gateway validates before sending its payload to WebSocket clients.
+// memberIDs is what getMemberIDs returned for the channel.
+function newEvent(type, channelID, payload, memberIDs) {
+  return {
+    "type": type,
+    "version": EVENT_VERSION,
+    "channelID": Number(channelID),
+    "payload": payload,
+    "eventID": crypto.randomUUID(),
+    "ts": new Date().toISOString(),
+    "private": memberIDs.private,
+    "userIDs": memberIDs.members
+  };
+}
+
 /**
  * Expose public helper functions.
  */
 module.exports = {
-  getMemberIDs
+  getMemberIDs,
+  newEvent
 }
\ No newline at end of file
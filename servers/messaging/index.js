@@ -16,7 +16,7 @@ const [host, port] = addr.split(":");
 
 // ----- Middleware -----
 // Checks if the user making a request to this microservice is authenticted
-// (i.e. check the X-User header is set)
+// (i.e. check the X-User header is set and signed by the gateway)
 app.use(auth.isAuthenticatedUser);
 
 // JSON parsing for application/x-www-form-urlencoded
@@ -8,10 +8,12 @@ docker pull $DOCKERNAME/meetupserver
 docker pull $DOCKERNAME/mysqldb
 echo "✅  Pulled Docker Containers"
 
+# Stop the gateway first so in-flight requests can finish against the other servers
+docker stop --time 20 gatewayserver
+docker rm -f gatewayserver
 docker rm -f messagingserver
 docker rm -f meetupserver
 docker rm -f mysqlserver
-docker rm -f gatewayserver
 docker rm -f redisserver
 docker rm -f rabbitmqserver
 echo "✅  Current Docker Containers Stopped & Removed"
@@ -27,7 +29,8 @@ export PORT="3306"
 export USER="root"
 export MYSQL_ROOT_PASSWORD="testpwd"
 export DATABASE="infodb"
-export SESSIONKEY="key"
+export SESSIONKEY=$(openssl rand -base64 32)
+export IDENTITYKEY=$(openssl rand -base64 32)
 export REDISADDR="redisserver:6379"
 export MESSAGESADDR="messagingserver"
 export MEETUPADDR="meetupserver"
@@ -40,9 +43,14 @@ docker network create -d bridge backendnetwork
 echo "✅  Docker Network Created"
 
 docker run -d --network backendnetwork --name rabbitmqserver --hostname my-rabbit rabbitmq:3-management
-docker run -d --network backendnetwork --name messagingserver --restart unless-stopped -e MYSQL_ROOT_PASSWORD=$MYSQL_ROOT_PASSWORD -e HOST=$HOST -e PORT=$PORT -e USER=$USER -e DATABASE=$DATABASE $DOCKERNAME/messagingserver
-docker run -d --network backendnetwork --name meetupserver --restart unless-stopped -e MYSQL_ROOT_PASSWORD=$MYSQL_ROOT_PASSWORD -e HOST=$HOST -e PORT=$PORT -e USER=$USER -e DATABASE=$DATABASE $DOCKERNAME/meetupserver
+docker run -d --network backendnetwork --name messagingserver --restart unless-stopped -e IDENTITYKEY=$IDENTITYKEY -e MYSQL_ROOT_PASSWORD=$MYSQL_ROOT_PASSWORD -e HOST=$HOST -e PORT=$PORT -e USER=$USER -e DATABASE=$DATABASE $DOCKERNAME/messagingserver
+docker run -d --network backendnetwork --name meetupserver --restart unless-stopped -e IDENTITYKEY=$IDENTITYKEY -e MYSQL_ROOT_PASSWORD=$MYSQL_ROOT_PASSWORD -e HOST=$HOST -e PORT=$PORT -e USER=$USER -e DATABASE=$DATABASE $DOCKERNAME/meetupserver
 docker run -d --network backendnetwork --name mysqlserver -e MYSQL_USER=$USER -e MYSQL_ROOT_PASSWORD=$MYSQL_ROOT_PASSWORD -e MYSQL_DATABASE=$DATABASE $DOCKERNAME/mysqldb
-docker run -d --network backendnetwork --name gatewayserver --restart unless-stopped -p 443:443 -v /etc/letsencrypt:/etc/letsencrypt:ro -e TLSCERT=$TLSCERT -e TLSKEY=$TLSKEY -e REDISADDR=$REDISADDR -e MESSAGESADDR=$MESSAGESADDR -e MEETUPADDR=$MEETUPADDR -e SESSIONKEY=$SESSIONKEY -e MYSQL_ROOT_PASSWORD=$MYSQL_ROOT_PASSWORD -e DSN=$DSN $DOCKERNAME/gatewayserver
+until docker run --rm --network backendnetwork -e DSN=$DSN $DOCKERNAME/gatewayserver migrate up; do
+    echo "Waiting for MySQL to accept connections..."
+    sleep 5
+done
+echo "✅  Database Migrations Applied"
+docker run -d --network backendnetwork --name gatewayserver --restart unless-stopped -p 443:443 -v /etc/letsencrypt:/etc/letsencrypt:ro -e TLSCERT=$TLSCERT -e TLSKEY=$TLSKEY -e REDISADDR=$REDISADDR -e MESSAGESADDR=$MESSAGESADDR -e MEETUPADDR=$MEETUPADDR -e SESSIONKEY=$SESSIONKEY -e IDENTITYKEY=$IDENTITYKEY -e MYSQL_ROOT_PASSWORD=$MYSQL_ROOT_PASSWORD -e DSN=$DSN $DOCKERNAME/gatewayserver
 docker run -d --network backendnetwork --name redisserver redis
 echo "✅  Docker Containers Successfully Running"
\ No newline at end of file